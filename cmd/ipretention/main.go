@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/config"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/services"
+)
+
+// The IP retention job anonymizes the addresses of votes older than the
+// retention period, as configured for the server. It is meant to run on a
+// schedule, daily being enough for a retention counted in days. Its first
+// run also hashes the addresses of votes cast before hashes were recorded,
+// which abuse detection relies on.
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	policy, err := config.LoadIPRetentionPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dbHost := os.Getenv("POSTGRES_HOST")
+	dbPort := os.Getenv("POSTGRES_PORT")
+	dbUser := os.Getenv("POSTGRES_USER")
+	dbPass := os.Getenv("POSTGRES_PASSWORD")
+	dbName := os.Getenv("POSTGRES_DB")
+
+	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUser, dbPass, dbHost, dbPort, dbName)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	retentionService := services.NewIPRetentionService(postgres.NewIPRetentionRepository(db), policy)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	if policy.Anonymization == domain.IPKeep && len(policy.HashKey) == 0 {
+		log.Println("IP_ANONYMIZATION and IP_HASH_SECRET are not set, voter addresses are left as they are")
+	} else if policy.Anonymization == domain.IPKeep {
+		log.Println("IP_ANONYMIZATION is not set, voter addresses are only hashed")
+	} else {
+		log.Printf("Anonymizing (%s) voter addresses older than %s...", policy.Anonymization, policy.RetainFor)
+	}
+
+	result, err := retentionService.Apply(ctx, time.Now())
+	if err != nil {
+		log.Fatalf("Error applying IP retention: %v", err)
+	}
+
+	log.Printf("IP retention completed successfully: %d votes hashed, %d anonymized.", result.Hashed, result.Anonymized)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"github.com/vncsmyrnk/poll/internal/adapters/pollimport"
+	"github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/config"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/services"
+)
+
+const usage = `usage: pollctl <command> [arguments]
+
+commands:
+  import [-format csv|ndjson] [-creator email] <file>
+        imports polls from a CSV or NDJSON file and prints a per-row report
+  promote [-role user|moderator|admin] <email>
+        sets the role of an existing user, admin by default
+  verify [-server url] [-root hash] <receipt.json>
+        checks that the vote of a receipt, as returned when voting, was
+        counted, optionally against a Merkle root published elsewhere
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "promote":
+		err = runPromote(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "file format (csv or ndjson), taken from the file extension when omitted")
+	creator := fs.String("creator", "", "email of the user set as creator of the imported polls")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("import requires exactly one file")
+	}
+	path := fs.Arg(0)
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := pollimport.Read(f, *format)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	pollRepo := postgres.NewPollRepository(db)
+	resultRepo := postgres.NewPollResultRepository(db)
+	voteRepo := postgres.NewVoteRepository(db)
+	userRepo := postgres.NewUserRepository(db)
+
+	if *creator != "" {
+		user, err := userRepo.GetByEmail(ctx, *creator)
+		if err != nil {
+			return fmt.Errorf("failed to get creator: %w", err)
+		}
+		if user == nil {
+			return fmt.Errorf("user %s not found", *creator)
+		}
+		for i := range rows {
+			rows[i].Input.CreatedBy = &user.ID
+		}
+	}
+
+	contentFilter, err := config.LoadContentFilter()
+	if err != nil {
+		return err
+	}
+
+	pollService := services.NewPollService(pollRepo, resultRepo, voteRepo, contentFilter, 0, postgres.NewTransactor(db))
+	report, err := pollService.Import(ctx, rows)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d polls, %d rows failed.", report.Imported, report.Failed)
+	return nil
+}
+
+func runPromote(args []string) error {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	role := fs.String("role", string(domain.RoleAdmin), "role to give the user (user, moderator or admin)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("promote requires exactly one email")
+	}
+	email := fs.Arg(0)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	userService := services.NewUserService(postgres.NewUserRepository(db))
+	user, err := userService.SetRoleByEmail(ctx, email, domain.Role(*role))
+	if err != nil {
+		return fmt.Errorf("failed to promote %s: %w", email, err)
+	}
+
+	log.Printf("User %s (%s) is now %s. The role applies from their next token refresh.", user.Email, user.ID, user.Role)
+	return nil
+}
+
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	server := fs.String("server", "http://localhost:8080", "base URL of the poll API")
+	root := fs.String("root", "", "expected Merkle root of the poll, as published")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("verify requires exactly one receipt file")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var receipt domain.VoteReceipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return fmt.Errorf("failed to parse receipt: %w", err)
+	}
+	if !receipt.Valid() {
+		return fmt.Errorf("receipt does not match its poll, option and nonce")
+	}
+
+	proof, err := fetchTallyProof(*server, receipt)
+	if err != nil {
+		return err
+	}
+
+	if proof.PollID != receipt.PollID {
+		return fmt.Errorf("proof is for poll %s, not %s", proof.PollID, receipt.PollID)
+	}
+	if proof.OptionID != receipt.OptionID {
+		return fmt.Errorf("vote was counted for option %s, not %s", proof.OptionID, receipt.OptionID)
+	}
+	if !proof.Verify(domain.ReceiptHash(receipt.Receipt)) {
+		return fmt.Errorf("proof does not lead to the Merkle root %s", proof.MerkleRoot)
+	}
+	if *root != "" && !strings.EqualFold(*root, proof.MerkleRoot) {
+		return fmt.Errorf("proof leads to the Merkle root %s, not the expected %s", proof.MerkleRoot, *root)
+	}
+
+	log.Printf("Vote counted for option %s, ballot %d of %d under Merkle root %s.", receipt.OptionID, proof.LeafIndex+1, proof.Ballots, proof.MerkleRoot)
+	return nil
+}
+
+func fetchTallyProof(server string, receipt domain.VoteReceipt) (*domain.TallyProof, error) {
+	// Only the receipt hash is sent, so the receipt stays with its voter.
+	endpoint := fmt.Sprintf("%s/api/polls/%s/audit/proof?receipt_hash=%s", strings.TrimSuffix(server, "/"), receipt.PollID, domain.ReceiptHash(receipt.Receipt))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch proof: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var problem struct {
+			Detail string `json:"detail"`
+		}
+		json.NewDecoder(resp.Body).Decode(&problem)
+		return nil, fmt.Errorf("failed to fetch proof: status %d: %s", resp.StatusCode, problem.Detail)
+	}
+
+	var proof domain.TallyProof
+	if err := json.NewDecoder(resp.Body).Decode(&proof); err != nil {
+		return nil, fmt.Errorf("failed to decode proof: %w", err)
+	}
+	return &proof, nil
+}
+
+func openDB() (*sql.DB, error) {
+	dbHost := os.Getenv("POSTGRES_HOST")
+	dbPort := os.Getenv("POSTGRES_PORT")
+	dbUser := os.Getenv("POSTGRES_USER")
+	dbPass := os.Getenv("POSTGRES_PASSWORD")
+	dbName := os.Getenv("POSTGRES_DB")
+
+	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUser, dbPass, dbHost, dbPort, dbName)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -2,25 +2,54 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	stdhttp "net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/vncsmyrnk/poll/internal/adapters/challenge"
 	"github.com/vncsmyrnk/poll/internal/adapters/handler/http"
+	"github.com/vncsmyrnk/poll/internal/adapters/jwtkeys"
+	"github.com/vncsmyrnk/poll/internal/adapters/mail"
+	"github.com/vncsmyrnk/poll/internal/adapters/oauth"
+	"github.com/vncsmyrnk/poll/internal/adapters/oauth/github"
 	"github.com/vncsmyrnk/poll/internal/adapters/oauth/google"
+	"github.com/vncsmyrnk/poll/internal/adapters/oauth/oidc"
+	"github.com/vncsmyrnk/poll/internal/adapters/ratelimit"
 	"github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/config"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
 	"github.com/vncsmyrnk/poll/internal/core/services"
 )
 
+const (
+	defaultReportThreshold = 5
+	defaultAnonymousPerIP  = 5
+)
+
+// defaultRateLimits apply to the variables of loadRateLimits that are not set.
+var defaultRateLimits = map[string]string{
+	"RATE_LIMIT_POLLS_PER_USER":            "10/1h",
+	"RATE_LIMIT_POLLS_PER_IP":              "30/1h",
+	"RATE_LIMIT_VOTES_PER_USER":            "60/1m",
+	"RATE_LIMIT_VOTES_PER_IP":              "300/1m",
+	"RATE_LIMIT_EMAIL_LOGIN_PER_IP":        "10/1h",
+	"RATE_LIMIT_EMAIL_LOGIN_PER_RECIPIENT": "5/1h",
+	"RATE_LIMIT_AUDIT_PER_IP":              "120/1m",
+}
+
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
@@ -44,13 +73,66 @@ func main() {
 	resultRepo := postgres.NewPollResultRepository(db)
 	userRepo := postgres.NewUserRepository(db)
 	authRepo := postgres.NewAuthRepository(db)
+	reportRepo := postgres.NewReportRepository(db)
+	voteFlagRepo := postgres.NewVoteFlagRepository(db)
+	auditRepo := postgres.NewAuditRepository(db)
+	transactor := postgres.NewTransactor(db)
 
-	verifier := google.NewVerifier()
+	providers, err := loadProviders()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := loadMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys, err := loadKeySet()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reportThreshold, err := loadReportThreshold()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	contentFilter, err := config.LoadContentFilter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	challengeVerifier, err := loadChallengeVerifier(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	anonymousPerIP, err := loadAnonymousVotesPerIP()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ipPolicy, err := config.LoadIPRetentionPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ipPolicy.HashKey) == 0 {
+		log.Println("IP_HASH_SECRET was not set, abuse detection and the anonymous per-IP cap are disabled")
+	}
 
-	pollService := services.NewPollService(pollRepo, resultRepo, voteRepo)
-	voteService := services.NewVoteService(pollRepo, voteRepo)
-	authService := services.NewAuthService(userRepo, authRepo, verifier)
+	pollService := services.NewPollService(pollRepo, resultRepo, voteRepo, contentFilter, reportThreshold, transactor)
+	voteService := services.NewVoteService(pollRepo, voteRepo, challengeVerifier, anonymousPerIP, ipPolicy, transactor)
+	authService := services.NewAuthService(userRepo, authRepo, providers, keys, mailer)
 	userService := services.NewUserService(userRepo)
+	reportService := services.NewReportService(pollRepo, reportRepo)
+	adminService := services.NewAdminService(pollRepo, userRepo, voteRepo, authRepo, reportRepo, voteFlagRepo, auditRepo, transactor)
+
+	voterHashSecret := os.Getenv("VOTER_HASH_SECRET")
+	if voterHashSecret == "" {
+		log.Println("VOTER_HASH_SECRET was not set, exported ballots carry no voter hash")
+	}
+	exportService := services.NewExportService(pollRepo, resultRepo, voteRepo, []byte(voterHashSecret))
 
 	redirectURL := os.Getenv("AUTH_REDIRECT_URL")
 	if redirectURL == "" {
@@ -72,8 +154,21 @@ func main() {
 
 	pollHandler := http.NewPollHandler(pollService)
 	voteHandler := http.NewVoteHandler(voteService)
-	authHandler := http.NewAuthHandler(authService, redirectURL, cookieDomain, sameSiteMode)
+	oauthFlow, err := loadOAuthFlow(providers)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rateLimits, err := loadRateLimits(db, ipPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authHandler := http.NewAuthHandler(authService, redirectURL, cookieDomain, sameSiteMode, oauthFlow, rateLimits)
 	userHandler := http.NewUserHandler(userService)
+	exportHandler := http.NewExportHandler(exportService)
+	reportHandler := http.NewReportHandler(reportService)
+	adminHandler := http.NewAdminHandler(adminService)
 
 	corsOrigins := os.Getenv("CORS_ALLOWED_ORIGINS")
 	var allowedOrigins []string
@@ -83,7 +178,19 @@ func main() {
 		allowedOrigins = []string{"https://poll.vncsmyrnk.dev"}
 	}
 
-	handler := http.NewHandler(pollHandler, voteHandler, authHandler, userHandler, allowedOrigins)
+	trustedProxies, err := loadTrustedProxies()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// DEVICE_COOKIE_SECRET signs the cookie identifying anonymous voters.
+	deviceKey := []byte(os.Getenv("DEVICE_COOKIE_SECRET"))
+	if len(deviceKey) == 0 {
+		log.Println("DEVICE_COOKIE_SECRET was not set, anonymous voting is disabled")
+	}
+	devices := http.DeviceCookie{Key: deviceKey, Domain: cookieDomain, SameSite: sameSiteMode}
+
+	handler := http.NewHandler(pollHandler, voteHandler, authHandler, userHandler, exportHandler, reportHandler, adminHandler, keys, authService, allowedOrigins, rateLimits, trustedProxies, devices)
 
 	server := &stdhttp.Server{Addr: "0.0.0.0:8080", Handler: handler}
 
@@ -107,3 +214,281 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// loadKeySet signs access tokens with the private key in
+// JWT_SIGNING_KEY_FILE and also accepts tokens signed by the keys listed in
+// JWT_VERIFICATION_KEY_FILES, which is how a key is rotated out. Without a
+// key file it falls back to HS256 with JWT_SECRET.
+func loadKeySet() (*jwtkeys.KeySet, error) {
+	signingKeyFile := os.Getenv("JWT_SIGNING_KEY_FILE")
+	if signingKeyFile == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("either JWT_SIGNING_KEY_FILE or JWT_SECRET must be set")
+		}
+		log.Println("JWT_SIGNING_KEY_FILE was not set, signing access tokens with JWT_SECRET")
+		return jwtkeys.NewKeySet(jwtkeys.NewHMACKey([]byte(secret)))
+	}
+
+	signingKey, err := jwtkeys.LoadKey(signingKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var verificationKeys []*jwtkeys.Key
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		key, err := jwtkeys.LoadKey(path)
+		if err != nil {
+			return nil, err
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return jwtkeys.NewKeySet(signingKey, verificationKeys...)
+}
+
+// loadProviders registers Google when GOOGLE_CLIENT_ID is set, GitHub when
+// GITHUB_CLIENT_ID is set, which requires GITHUB_CLIENT_SECRET, plus every
+// OpenID Connect provider named in OIDC_PROVIDERS. Each of those is
+// configured through OIDC_<NAME>_ISSUER and OIDC_<NAME>_CLIENT_ID and logs in
+// at /oauth/<name>/callback.
+func loadProviders() (*oauth.Registry, error) {
+	registry := oauth.NewRegistry()
+
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+		registry.Register(&ports.LoginProvider{Name: "google", ClientID: clientID, Verifier: google.NewVerifier()})
+	} else {
+		log.Println("GOOGLE_CLIENT_ID was not set")
+	}
+
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); clientID != "" {
+		clientSecret := os.Getenv("GITHUB_CLIENT_SECRET")
+		if clientSecret == "" {
+			return nil, errors.New("GITHUB_CLIENT_SECRET must be set along with GITHUB_CLIENT_ID")
+		}
+		registry.Register(&ports.LoginProvider{
+			Name: "github",
+			AuthCode: github.NewAuthCodeFlow(github.Config{
+				ClientID:     clientID,
+				ClientSecret: clientSecret,
+			}),
+		})
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID must be set for provider %s", prefix, prefix, name)
+		}
+
+		registry.Register(&ports.LoginProvider{Name: name, ClientID: clientID, Verifier: oidc.NewVerifier(issuer, nil)})
+	}
+
+	return registry, nil
+}
+
+// loadOAuthFlow reads the public URL of the server, OAUTH_CALLBACK_BASE_URL,
+// which providers redirect back to and email login links point to, so it is
+// always required. OAUTH_STATE_SECRET signs the cookie that carries the
+// authorization code flow, and is required as soon as a provider logs in
+// through it.
+func loadOAuthFlow(providers *oauth.Registry) (http.OAuthFlowConfig, error) {
+	flow := http.OAuthFlowConfig{
+		CallbackBaseURL: os.Getenv("OAUTH_CALLBACK_BASE_URL"),
+		StateKey:        []byte(os.Getenv("OAUTH_STATE_SECRET")),
+	}
+	if u, err := url.Parse(flow.CallbackBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return flow, errors.New("OAUTH_CALLBACK_BASE_URL must be set to the absolute public URL of the server")
+	}
+	if len(flow.StateKey) > 0 {
+		return flow, nil
+	}
+
+	for _, name := range providers.Names() {
+		if p, _ := providers.Get(name); p.AuthCode != nil {
+			return flow, fmt.Errorf("OAUTH_STATE_SECRET must be set for provider %s", name)
+		}
+	}
+	return flow, nil
+}
+
+// loadMailer sends email through SMTP_ADDR when it is set. Otherwise emails
+// are written to MAIL_FILE, or to the log when that is not set either.
+func loadMailer() (ports.Mailer, error) {
+	smtpAddr := os.Getenv("SMTP_ADDR")
+	if smtpAddr == "" {
+		log.Println("SMTP_ADDR was not set, emails will not be delivered")
+		return mail.NewFileMailer(os.Getenv("MAIL_FILE")), nil
+	}
+
+	return mail.NewSMTPMailer(smtpAddr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+}
+
+// loadReportThreshold reads how many open reports hide a poll from listings,
+// REPORT_HIDE_THRESHOLD, 0 disabling it.
+func loadReportThreshold() (int, error) {
+	value := os.Getenv("REPORT_HIDE_THRESHOLD")
+	if value == "" {
+		return defaultReportThreshold, nil
+	}
+
+	threshold, err := strconv.Atoi(value)
+	if err != nil || threshold < 0 {
+		return 0, fmt.Errorf("REPORT_HIDE_THRESHOLD must be a non-negative integer")
+	}
+	return threshold, nil
+}
+
+// loadAnonymousVotesPerIP reads how many anonymous votes a poll takes from
+// one address, ANONYMOUS_VOTES_PER_IP, 0 disabling the cap.
+func loadAnonymousVotesPerIP() (int, error) {
+	value := os.Getenv("ANONYMOUS_VOTES_PER_IP")
+	if value == "" {
+		return defaultAnonymousPerIP, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("ANONYMOUS_VOTES_PER_IP must be a non-negative integer")
+	}
+	return limit, nil
+}
+
+// loadChallengeVerifier checks the challenges of polls that require one
+// with the CHALLENGE_PROVIDER, "turnstile" or "hcaptcha" configured through
+// CHALLENGE_SITE_KEY and CHALLENGE_SECRET, or "pow", the default, which needs
+// no external service. CHALLENGE_VERIFY_URL overrides the siteverify
+// endpoint and CHALLENGE_POW_DIFFICULTY the proof of work difficulty in bits.
+// Proof of work tokens are signed with CHALLENGE_SECRET, which replicas must
+// share, and spent ones are remembered in the database. Without it they are
+// signed with a random key, so they only hold on the server that issued them
+// and until it restarts.
+func loadChallengeVerifier(db *sql.DB) (ports.ChallengeVerifier, error) {
+	secret := os.Getenv("CHALLENGE_SECRET")
+
+	switch provider := os.Getenv("CHALLENGE_PROVIDER"); provider {
+	case "", "pow":
+		difficulty := 0
+		if value := os.Getenv("CHALLENGE_POW_DIFFICULTY"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("CHALLENGE_POW_DIFFICULTY must be a positive integer")
+			}
+			difficulty = n
+		}
+		key := []byte(secret)
+		if len(key) == 0 {
+			log.Println("CHALLENGE_SECRET was not set, proof of work tokens are signed with a random key")
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, fmt.Errorf("failed to generate proof of work key: %w", err)
+			}
+		}
+		return challenge.NewProofOfWork(challenge.ProofOfWorkConfig{
+			Key:        key,
+			Spent:      postgres.NewChallengeNonceStore(db),
+			Difficulty: difficulty,
+		})
+	case "turnstile", "hcaptcha":
+		siteKey := os.Getenv("CHALLENGE_SITE_KEY")
+		if siteKey == "" || secret == "" {
+			return nil, fmt.Errorf("CHALLENGE_SITE_KEY and CHALLENGE_SECRET must be set for %s", provider)
+		}
+		verifyURL := os.Getenv("CHALLENGE_VERIFY_URL")
+		if verifyURL == "" && provider == "hcaptcha" {
+			verifyURL = challenge.HCaptchaVerifyURL
+		}
+		return challenge.NewSiteVerifier(challenge.SiteVerifyConfig{SiteKey: siteKey, Secret: secret, VerifyURL: verifyURL}), nil
+	default:
+		return nil, fmt.Errorf("CHALLENGE_PROVIDER must be pow, turnstile or hcaptcha, got %q", provider)
+	}
+}
+
+// loadTrustedProxies reads the load balancers in front of the server from
+// TRUSTED_PROXIES and the header they pass the client address in from
+// TRUSTED_PROXY_HEADER, which must be set along with them.
+func loadTrustedProxies() (http.TrustedProxies, error) {
+	var proxies http.TrustedProxies
+	prefixes, err := http.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return proxies, err
+	}
+	if len(prefixes) == 0 {
+		log.Println("TRUSTED_PROXIES was not set, client IPs are taken from the connection")
+		return proxies, nil
+	}
+
+	header := os.Getenv("TRUSTED_PROXY_HEADER")
+	if header == "" {
+		return proxies, errors.New("TRUSTED_PROXY_HEADER must be set along with TRUSTED_PROXIES")
+	}
+	if header, err = http.ParseProxyHeader(header); err != nil {
+		return proxies, fmt.Errorf("TRUSTED_PROXY_HEADER: %w", err)
+	}
+
+	return http.TrustedProxies{Prefixes: prefixes, Header: header}, nil
+}
+
+// loadRateLimits keeps buckets in memory, or in Postgres when
+// RATE_LIMIT_STORE is "postgres" so that every replica shares them. Limits
+// are read from RATE_LIMIT_{POLLS,VOTES}_PER_{USER,IP} and
+// RATE_LIMIT_{EMAIL_LOGIN,AUDIT}_PER_IP and
+// RATE_LIMIT_EMAIL_LOGIN_PER_RECIPIENT as "<requests>/<window>", such as
+// "10/1h", and "off" disables one. Client IPs are hashed in bucket keys when
+// the IP retention policy does not let votes store them either.
+func loadRateLimits(db *sql.DB, ipPolicy domain.IPRetentionPolicy) (http.RateLimits, error) {
+	var limits http.RateLimits
+	switch store := os.Getenv("RATE_LIMIT_STORE"); store {
+	case "", "memory":
+		limits.Store = ratelimit.NewMemoryStore()
+	case "postgres":
+		limits.Store = postgres.NewRateLimitStore(db)
+	default:
+		return limits, fmt.Errorf("RATE_LIMIT_STORE must be memory or postgres, got %q", store)
+	}
+	if !ipPolicy.StoresAddresses() {
+		limits.IPKey = ipPolicy.HashIP
+	}
+
+	targets := map[string]*domain.RateLimit{
+		"RATE_LIMIT_POLLS_PER_USER": &limits.CreatePoll.PerUser,
+		"RATE_LIMIT_POLLS_PER_IP":   &limits.CreatePoll.PerIP,
+		"RATE_LIMIT_VOTES_PER_USER": &limits.Vote.PerUser,
+		"RATE_LIMIT_VOTES_PER_IP":   &limits.Vote.PerIP,
+
+		"RATE_LIMIT_EMAIL_LOGIN_PER_IP":        &limits.EmailLogin.PerIP,
+		"RATE_LIMIT_EMAIL_LOGIN_PER_RECIPIENT": &limits.EmailRecipient,
+		"RATE_LIMIT_AUDIT_PER_IP":              &limits.Audit.PerIP,
+	}
+	for name, target := range targets {
+		value := os.Getenv(name)
+		if value == "" {
+			value = defaultRateLimits[name]
+		}
+		if value == "off" {
+			continue
+		}
+
+		requests, window, ok := strings.Cut(value, "/")
+		n, err := strconv.Atoi(requests)
+		if !ok || err != nil || n < 1 {
+			return limits, fmt.Errorf("%s must look like 10/1h or be off", name)
+		}
+		per, err := time.ParseDuration(window)
+		if err != nil || per <= 0 || per > 24*time.Hour {
+			return limits, fmt.Errorf("%s must have a window between 1s and 24h", name)
+		}
+		*target = domain.RateLimit{Requests: n, Per: per}
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/services"
+)
+
+// The vote analyzer flags suspicious votes cast recently for admins to
+// review through /api/admin/vote-flags. It is meant to run on a schedule,
+// with -since covering at least the time between two runs; votes are never
+// flagged twice for the same rule.
+func main() {
+	config := domain.DefaultVoteAnalysisConfig()
+	since := flag.Duration("since", 24*time.Hour, "analyse the votes cast within this long")
+	flag.IntVar(&config.BurstVotes, "burst-votes", config.BurstVotes, "votes on one option within -burst-window that make a burst, 0 to disable")
+	flag.DurationVar(&config.BurstWindow, "burst-window", config.BurstWindow, "window of the burst rule")
+	flag.IntVar(&config.AccountsPerIP, "accounts-per-ip", config.AccountsPerIP, "accounts voting on a poll from one IP before they are flagged, 0 to disable")
+	flag.IntVar(&config.AccountsPerSubnet, "accounts-per-subnet", config.AccountsPerSubnet, "accounts voting on a poll from one /24 or /48 before they are flagged, 0 to disable")
+	flag.DurationVar(&config.NewAccountAge, "new-account-age", config.NewAccountAge, "flag votes cast sooner than this after signing up, 0 to disable")
+	flag.IntVar(&config.FlipVotes, "flip-votes", config.FlipVotes, "votes by one user on a poll within -flip-window that count as flipping, 0 to disable")
+	flag.DurationVar(&config.FlipWindow, "flip-window", config.FlipWindow, "window of the flipping rule")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found")
+	}
+
+	dbHost := os.Getenv("POSTGRES_HOST")
+	dbPort := os.Getenv("POSTGRES_PORT")
+	dbUser := os.Getenv("POSTGRES_USER")
+	dbPass := os.Getenv("POSTGRES_PASSWORD")
+	dbName := os.Getenv("POSTGRES_DB")
+
+	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", dbUser, dbPass, dbHost, dbPort, dbName)
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	analysisService := services.NewVoteAnalysisService(postgres.NewVoteFlagRepository(db), config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	log.Printf("Analysing votes cast in the last %s...", *since)
+
+	flagged, err := analysisService.Analyze(ctx, time.Now().Add(-*since))
+	if err != nil {
+		log.Fatalf("Error analysing votes: %v", err)
+	}
+
+	for _, rule := range domain.VoteFlagRules {
+		if count, ok := flagged[rule]; ok {
+			log.Printf("%s: %d new flags", rule, count)
+		}
+	}
+	log.Println("Vote analysis completed successfully.")
+}
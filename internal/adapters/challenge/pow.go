@@ -0,0 +1,156 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+const (
+	defaultDifficulty = 18
+	defaultTTL        = 5 * time.Minute
+)
+
+type ProofOfWorkConfig struct {
+	// Key signs the issued tokens. Replicas behind the same load balancer
+	// must share it.
+	Key []byte
+	// Spent remembers the tokens already redeemed.
+	Spent ports.ChallengeNonceStore
+	// Difficulty is the number of leading zero bits the solution's hash
+	// needs. Each extra bit doubles the expected work.
+	Difficulty int
+	TTL        time.Duration
+}
+
+// ProofOfWork issues stateless tokens signed with the config key and bound
+// to the scope they were requested for. A solution is "<token>:<counter>"
+// whose SHA-256 starts with Difficulty zero bits. Spent tokens are kept in
+// the Spent store until they expire, so a token is accepted at most once.
+type ProofOfWork struct {
+	config ProofOfWorkConfig
+}
+
+func NewProofOfWork(config ProofOfWorkConfig) (ports.ChallengeVerifier, error) {
+	if len(config.Key) == 0 {
+		return nil, errors.New("proof of work key must be set")
+	}
+	if config.Spent == nil {
+		return nil, errors.New("proof of work spent token store must be set")
+	}
+	if config.Difficulty <= 0 {
+		config.Difficulty = defaultDifficulty
+	}
+	if config.Difficulty > 32 {
+		return nil, fmt.Errorf("proof of work difficulty must be at most 32, got %d", config.Difficulty)
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultTTL
+	}
+
+	return &ProofOfWork{config: config}, nil
+}
+
+// NewChallenge returns a token of the form "<expires>.<difficulty>.<nonce>.<mac>".
+func (p *ProofOfWork) NewChallenge(ctx context.Context, scope string) (*domain.Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge nonce: %w", err)
+	}
+
+	expiresAt := time.Now().Add(p.config.TTL).Truncate(time.Second)
+	payload := fmt.Sprintf("%d.%d.%s", expiresAt.Unix(), p.config.Difficulty, hex.EncodeToString(nonce))
+
+	return &domain.Challenge{
+		Type:       domain.ChallengeProofOfWork,
+		Token:      payload + "." + p.sign(scope, payload),
+		Difficulty: p.config.Difficulty,
+		ExpiresAt:  &expiresAt,
+	}, nil
+}
+
+func (p *ProofOfWork) Verify(ctx context.Context, scope, response, remoteIP string) error {
+	token, counter, ok := strings.Cut(response, ":")
+	if !ok || counter == "" {
+		return domain.ErrChallengeFailed
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return domain.ErrChallengeFailed
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(p.sign(scope, payload))) {
+		return domain.ErrChallengeFailed
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return domain.ErrChallengeFailed
+	}
+	expiresAt := time.Unix(expires, 0)
+	if !time.Now().Before(expiresAt) {
+		return domain.ErrChallengeFailed
+	}
+
+	// The difficulty is covered by the signature, so a token keeps the one
+	// it was issued with even if the configuration changes meanwhile.
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return domain.ErrChallengeFailed
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(response))) < difficulty {
+		return domain.ErrChallengeFailed
+	}
+
+	unused, err := p.config.Spent.Spend(ctx, parts[2], expiresAt)
+	if err != nil {
+		return err
+	}
+	if !unused {
+		return domain.ErrChallengeFailed
+	}
+
+	return nil
+}
+
+func (p *ProofOfWork) sign(scope, payload string) string {
+	mac := hmac.New(sha256.New, p.config.Key)
+	mac.Write([]byte(scope))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Solve finds the response to a proof of work token by brute force. Clients
+// would do the same in JavaScript; this one serves Go clients and tests.
+func Solve(token string, difficulty int) string {
+	for counter := 0; ; counter++ {
+		response := token + ":" + strconv.Itoa(counter)
+		if leadingZeroBits(sha256.Sum256([]byte(response))) >= difficulty {
+			return response
+		}
+	}
+}
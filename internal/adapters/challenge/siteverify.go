@@ -0,0 +1,101 @@
+// Package challenge implements ports.ChallengeVerifier. SiteVerifier checks
+// Turnstile or hCaptcha tokens against their siteverify endpoint, while
+// ProofOfWork needs no external service and is the fallback when no captcha
+// provider is configured.
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+const (
+	TurnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	HCaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+)
+
+type SiteVerifyConfig struct {
+	// SiteKey is handed to clients to render the widget and Secret
+	// authenticates the server to the provider.
+	SiteKey string
+	Secret  string
+	// VerifyURL defaults to Turnstile and is set to HCaptchaVerifyURL for
+	// hCaptcha or to a stub in tests.
+	VerifyURL  string
+	HTTPClient *http.Client
+}
+
+type SiteVerifier struct {
+	config SiteVerifyConfig
+}
+
+// NewSiteVerifier speaks the siteverify protocol shared by Turnstile and
+// hCaptcha. Their tokens are single use and short lived on the provider's
+// side, so nothing is tracked here.
+func NewSiteVerifier(config SiteVerifyConfig) ports.ChallengeVerifier {
+	if config.VerifyURL == "" {
+		config.VerifyURL = TurnstileVerifyURL
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &SiteVerifier{config: config}
+}
+
+func (v *SiteVerifier) NewChallenge(ctx context.Context, scope string) (*domain.Challenge, error) {
+	return &domain.Challenge{Type: domain.ChallengeCaptcha, SiteKey: v.config.SiteKey}, nil
+}
+
+// misconfigured are the error codes caused by the server's own setup rather
+// than by the client's answer.
+var misconfigured = []string{"missing-input-secret", "invalid-input-secret", "sitekey-secret-mismatch"}
+
+func (v *SiteVerifier) Verify(ctx context.Context, scope, response, remoteIP string) error {
+	form := url.Values{"secret": {v.config.Secret}, "response": {response}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.config.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to build siteverify request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call siteverify: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("siteverify returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode siteverify response: %w", err)
+	}
+
+	if result.Success {
+		return nil
+	}
+	for _, code := range result.ErrorCodes {
+		if slices.Contains(misconfigured, code) {
+			return fmt.Errorf("siteverify rejected the server configuration: %s", code)
+		}
+	}
+	return domain.ErrChallengeFailed
+}
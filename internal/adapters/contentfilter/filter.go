@@ -0,0 +1,195 @@
+// Package contentfilter is the built-in ports.ContentFilter. It enforces
+// length and link limits, rejects blocklisted terms and duplicate options,
+// and compares text after folding look-alike characters so that "Ѕpam" or
+// "ｓｐａｍ" cannot slip past a blocklist entry for "spam".
+package contentfilter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+// Limits applies to a single field. MaxLength counts characters and 0 means
+// no limit; MaxURLs counts links and a negative value means no limit, so 0
+// forbids links altogether.
+type Limits struct {
+	MaxLength int
+	MaxURLs   int
+}
+
+type Config struct {
+	Title       Limits
+	Description Limits
+	Option      Limits
+	// Blocklist holds words or phrases that may not appear in any field.
+	// They match whole words, ignoring case and look-alike characters.
+	Blocklist []string
+}
+
+// DefaultConfig allows links in the description only and has an empty
+// blocklist.
+func DefaultConfig() Config {
+	return Config{
+		Title:       Limits{MaxLength: 200, MaxURLs: 0},
+		Description: Limits{MaxLength: 2000, MaxURLs: 3},
+		Option:      Limits{MaxLength: 200, MaxURLs: 0},
+	}
+}
+
+type Filter struct {
+	config    Config
+	blocklist []string
+}
+
+func New(config Config) ports.ContentFilter {
+	f := &Filter{config: config}
+	for _, term := range config.Blocklist {
+		if term = words(term); term != "" {
+			f.blocklist = append(f.blocklist, term)
+		}
+	}
+	return f
+}
+
+// LoadBlocklist reads one term per line from path, skipping blank lines and
+// lines starting with #.
+func LoadBlocklist(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist: %w", err)
+	}
+	defer file.Close()
+
+	var terms []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		terms = append(terms, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	return terms, nil
+}
+
+func (f *Filter) Check(ctx context.Context, input ports.CreatePollInput) error {
+	validation := &domain.ValidationError{}
+
+	f.checkField(validation, "title", input.Title, f.config.Title)
+	f.checkField(validation, "description", input.Description, f.config.Description)
+
+	seen := make(map[string]bool, len(input.Options))
+	for i, option := range input.Options {
+		if strings.TrimSpace(option) == "" {
+			continue
+		}
+		field := fmt.Sprintf("options[%d]", i)
+		f.checkField(validation, field, option, f.config.Option)
+
+		key := strings.Join(strings.Fields(normalize(option)), "")
+		if seen[key] {
+			validation.Add(field, "duplicate", fmt.Sprintf("%s repeats another option", field))
+		}
+		seen[key] = true
+	}
+
+	return validation.Err()
+}
+
+func (f *Filter) checkField(validation *domain.ValidationError, field, text string, limits Limits) {
+	if text == "" {
+		return
+	}
+
+	if limits.MaxLength > 0 && utf8.RuneCountInString(text) > limits.MaxLength {
+		validation.Add(field, "too_long", fmt.Sprintf("%s must be at most %d characters", field, limits.MaxLength))
+	}
+
+	normalized := normalize(text)
+	if limits.MaxURLs >= 0 {
+		if n := len(urlPattern.FindAllStringIndex(normalized, -1)); n > limits.MaxURLs {
+			if limits.MaxURLs == 0 {
+				validation.Add(field, "too_many_urls", fmt.Sprintf("%s must not contain links", field))
+			} else {
+				validation.Add(field, "too_many_urls", fmt.Sprintf("%s must contain at most %d links", field, limits.MaxURLs))
+			}
+		}
+	}
+
+	padded := " " + words(text) + " "
+	for _, term := range f.blocklist {
+		if strings.Contains(padded, " "+term+" ") {
+			validation.Add(field, "blocked", fmt.Sprintf("%s contains a blocked term", field))
+			break
+		}
+	}
+}
+
+var urlPattern = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)[^\s]+|\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|net|org|io|co|ly|me|info|biz|xyz|gg|app|dev)\b(?:/[^\s]*)?`)
+
+// words normalizes text and reduces it to its words separated by single
+// spaces, so blocklist terms match regardless of punctuation around them.
+func words(text string) string {
+	return strings.Join(strings.FieldsFunc(normalize(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// normalize lowercases text, drops invisible and combining characters,
+// turns fullwidth forms into ASCII and replaces common look-alikes from
+// other scripts with the Latin letter they imitate.
+func normalize(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Mn, r), unicode.Is(unicode.Cf, r):
+			continue
+		case r >= 0xFF01 && r <= 0xFF5E:
+			r -= 0xFEE0
+		case r == 0x3000:
+			r = ' '
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		} else if c, ok := confusables[unicode.ToLower(r)]; ok {
+			r = c
+		} else {
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// confusables maps look-alikes to the lowercase Latin letter they are
+// usually mistaken for. Capitals are only listed where they imitate a
+// different letter than their lowercase form. It covers the Cyrillic and
+// Greek letters and symbols used to dodge filters in practice, not the full
+// Unicode confusables table.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'һ': 'h',
+	'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'ѵ': 'v',
+	'ԝ': 'w', 'х': 'x', 'у': 'y', 'ү': 'y',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y', 'ω': 'w',
+	'Η': 'h', 'Ν': 'n', 'Μ': 'm', 'Ζ': 'z', 'Β': 'b',
+	// Latin variants and symbols
+	'ı': 'i', 'ȷ': 'j', 'ɡ': 'g', 'ℓ': 'l', 'ꓲ': 'l', '∣': 'l', 'ǀ': 'l',
+	'ø': 'o', 'đ': 'd', 'ħ': 'h', 'ł': 'l',
+}
@@ -0,0 +1,446 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type AdminHandler struct {
+	service ports.AdminService
+}
+
+func NewAdminHandler(service ports.AdminService) *AdminHandler {
+	return &AdminHandler{
+		service: service,
+	}
+}
+
+// moderationRequest is the optional body of every admin action.
+type moderationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ListPolls godoc
+// @Summary      Lists every poll, hidden ones included
+// @Description  Newest first, 20 per page. Hidden polls have `deleted_at` set.
+// @Tags         admin
+// @Produce      json
+// @Param        page  query     int     false  "current results page"
+// @Param        q     query     string  false  "title search"
+// @Success      200   {array}   domain.Poll
+// @Failure      401   {object}  Problem
+// @Failure      403   {object}  Problem
+// @Failure      500   {object}  Problem
+// @Router       /admin/polls [get]
+func (h *AdminHandler) ListPolls(w http.ResponseWriter, r *http.Request) {
+	input := ports.ListPollsInput{
+		Page:  queryPage(r),
+		Query: r.URL.Query().Get("q"),
+	}
+
+	polls, err := h.service.ListPolls(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, polls)
+}
+
+// HidePoll godoc
+// @Summary      Hides a poll from everyone but the admin API
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "poll id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/polls/{id}/hide [post]
+func (h *AdminHandler) HidePoll(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.HidePoll(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestorePoll godoc
+// @Summary      Restores a hidden poll
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "poll id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/polls/{id}/restore [post]
+func (h *AdminHandler) RestorePoll(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RestorePoll(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BanUser godoc
+// @Summary      Bans a user
+// @Description  Banned users cannot log in, refresh their sessions nor use their API tokens. Their votes are kept unless invalidated separately.
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "user id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/users/{id}/ban [post]
+func (h *AdminHandler) BanUser(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.BanUser(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnbanUser godoc
+// @Summary      Lifts the ban of a user
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "user id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/users/{id}/unban [post]
+func (h *AdminHandler) UnbanUser(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.UnbanUser(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type invalidateVotesResponse struct {
+	Invalidated int64 `json:"invalidated"`
+}
+
+// InvalidateUserVotes godoc
+// @Summary      Invalidates every vote of a user
+// @Description  Results are corrected on the next vote summarization. The user cannot retract the invalidated votes nor vote again on their polls.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string             true   "user id"
+// @Param        request  body      moderationRequest  false  "request body"
+// @Success      200      {object}  invalidateVotesResponse
+// @Failure      400      {object}  Problem
+// @Failure      401      {object}  Problem
+// @Failure      403      {object}  Problem
+// @Failure      500      {object}  Problem
+// @Router       /admin/users/{id}/votes/invalidate [post]
+func (h *AdminHandler) InvalidateUserVotes(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := h.service.InvalidateUserVotes(r.Context(), chi.URLParam(r, "id"), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, invalidateVotesResponse{Invalidated: count})
+}
+
+// ListRefreshTokens godoc
+// @Summary      Lists the refresh tokens of a user
+// @Description  Revoked tokens are included. Every call is recorded in the audit log.
+// @Tags         admin
+// @Produce      json
+// @Param        id   path      string  true  "user id"
+// @Success      200  {array}   domain.RefreshToken
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/users/{id}/refresh-tokens [get]
+func (h *AdminHandler) ListRefreshTokens(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	tokens, err := h.service.ListRefreshTokens(r.Context(), chi.URLParam(r, "id"), ports.ModerationInput{ActorID: actorID})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// ListAuditLog godoc
+// @Summary      Lists the audit log of admin actions
+// @Description  Newest first, 50 per page.
+// @Tags         admin
+// @Produce      json
+// @Param        page  query     int  false  "current results page"
+// @Success      200   {array}   domain.AuditEntry
+// @Failure      401   {object}  Problem
+// @Failure      403   {object}  Problem
+// @Failure      500   {object}  Problem
+// @Router       /admin/audit [get]
+func (h *AdminHandler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.service.ListAuditLog(r.Context(), queryPage(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// ListReports godoc
+// @Summary      Lists reports for review
+// @Description  Oldest first, 50 per page. Defaults to open reports.
+// @Tags         admin
+// @Produce      json
+// @Param        status  query     string  false  "report status (open, dismissed or actioned)"
+// @Param        page    query     int     false  "current results page"
+// @Success      200     {array}   domain.Report
+// @Failure      400     {object}  Problem
+// @Failure      401     {object}  Problem
+// @Failure      403     {object}  Problem
+// @Failure      500     {object}  Problem
+// @Router       /admin/reports [get]
+func (h *AdminHandler) ListReports(w http.ResponseWriter, r *http.Request) {
+	status := domain.ReportStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = domain.ReportStatusOpen
+	}
+
+	reports, err := h.service.ListReports(r.Context(), status, queryPage(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// DismissReport godoc
+// @Summary      Dismisses a report
+// @Description  The report stops counting towards hiding the poll from listings.
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "report id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/reports/{id}/dismiss [post]
+func (h *AdminHandler) DismissReport(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DismissReport(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ActionReport godoc
+// @Summary      Acts on a report by hiding the poll
+// @Description  Every open report on the poll is resolved along with this one.
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "report id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/reports/{id}/action [post]
+func (h *AdminHandler) ActionReport(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.ActionReport(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListVoteFlags godoc
+// @Summary      Lists votes flagged by the vote analysis job
+// @Description  Oldest first, 50 per page. Defaults to open flags.
+// @Tags         admin
+// @Produce      json
+// @Param        status  query     string  false  "flag status (open, dismissed or invalidated)"
+// @Param        page    query     int     false  "current results page"
+// @Success      200     {array}   domain.VoteFlag
+// @Failure      400     {object}  Problem
+// @Failure      401     {object}  Problem
+// @Failure      403     {object}  Problem
+// @Failure      500     {object}  Problem
+// @Router       /admin/vote-flags [get]
+func (h *AdminHandler) ListVoteFlags(w http.ResponseWriter, r *http.Request) {
+	status := domain.VoteFlagStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = domain.VoteFlagStatusOpen
+	}
+
+	flags, err := h.service.ListVoteFlags(r.Context(), status, queryPage(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, flags)
+}
+
+// DismissVoteFlag godoc
+// @Summary      Dismisses a vote flag
+// @Description  The vote keeps counting.
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "vote flag id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/vote-flags/{id}/dismiss [post]
+func (h *AdminHandler) DismissVoteFlag(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DismissVoteFlag(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// InvalidateFlaggedVote godoc
+// @Summary      Invalidates a flagged vote
+// @Description  The vote drops out of the results on the next vote summarization, and its voter can neither retract it nor vote again on the poll. Every open flag on it is resolved.
+// @Tags         admin
+// @Accept       json
+// @Param        id       path  string             true   "vote flag id"
+// @Param        request  body  moderationRequest  false  "request body"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /admin/vote-flags/{id}/invalidate [post]
+func (h *AdminHandler) InvalidateFlaggedVote(w http.ResponseWriter, r *http.Request) {
+	input, ok := moderationInput(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.InvalidateFlaggedVote(r.Context(), chi.URLParam(r, "id"), input); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moderationInput reads who is acting and the optional reason they gave,
+// writing the problem response when it cannot.
+func moderationInput(w http.ResponseWriter, r *http.Request) (ports.ModerationInput, bool) {
+	actorID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return ports.ModerationInput{}, false
+	}
+
+	var req moderationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "invalid request body")
+		return ports.ModerationInput{}, false
+	}
+
+	return ports.ModerationInput{ActorID: actorID, Reason: req.Reason}, true
+}
+
+func queryPage(r *http.Request) int {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
@@ -1,8 +1,15 @@
 package http
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"net/http"
+	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
 
@@ -11,36 +18,109 @@ type AuthHandler struct {
 	redirectURL    string
 	cookieDomain   string
 	cookieSameSite http.SameSite
+	oauthFlow      OAuthFlowConfig
+	rateLimits     RateLimits
 }
 
-func NewAuthHandler(authService ports.AuthService, redirectURL string, cookieDomain string, cookieSameSite http.SameSite) *AuthHandler {
+func NewAuthHandler(authService ports.AuthService, redirectURL string, cookieDomain string, cookieSameSite http.SameSite, oauthFlow OAuthFlowConfig, rateLimits RateLimits) *AuthHandler {
 	return &AuthHandler{
 		authService:    authService,
 		redirectURL:    redirectURL,
 		cookieDomain:   cookieDomain,
 		cookieSameSite: cookieSameSite,
+		oauthFlow:      oauthFlow,
+		rateLimits:     rateLimits,
 	}
 }
 
-type googleCallbackRequest struct {
-	Credential string `json:"credential"`
+// GoogleCallback receives the credential posted by Google Identity Services.
+// It predates ProviderCallback and is kept for existing clients.
+func (h *AuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
+	h.loginWithIDToken(w, r, "google")
 }
 
-func (h *AuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+// ProviderCallback receives an ID token posted back by an OpenID Connect
+// provider with response_mode=form_post.
+func (h *AuthHandler) ProviderCallback(w http.ResponseWriter, r *http.Request) {
+	h.loginWithIDToken(w, r, chi.URLParam(r, "provider"))
+}
+
+func (h *AuthHandler) loginWithIDToken(w http.ResponseWriter, r *http.Request, provider string) {
+	credential, ok := idTokenFromForm(w, r)
+	if !ok {
 		return
 	}
 
-	credential := r.FormValue("credential")
-	if credential == "" {
-		http.Error(w, "Missing credential", http.StatusBadRequest)
+	accessToken, refreshToken, err := h.authService.LoginWithIDToken(r.Context(), provider, credential, clientInfo(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.setAccessTokenCookie(w, accessToken)
+	h.setRefreshTokenCookie(w, refreshToken)
+
+	http.Redirect(w, r, h.redirectURL, http.StatusSeeOther)
+}
+
+type emailLoginRequest struct {
+	Email string `json:"email"`
+}
+
+// StartEmailLogin godoc
+// @Summary      Sends a login link by email
+// @Description  The link is single-use and expires after 15 minutes. The response does not tell whether an account exists for the address. Requests are rate limited per client IP and per address the link is mailed to.
+// @Tags         auth
+// @Accept       json
+// @Param        request  body  emailLoginRequest  true  "request body"
+// @Success      202
+// @Failure      400  {object}  Problem
+// @Failure      422  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /auth/email/start [post]
+func (h *AuthHandler) StartEmailLogin(w http.ResponseWriter, r *http.Request) {
+	var req emailLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "invalid request body")
+		return
+	}
+
+	// The IP buckets alone let requests spread over many IPs flood a single
+	// inbox. The recipient is hashed so the store need not hold addresses.
+	recipient := sha256.Sum256([]byte(domain.NormalizeEmail(req.Email)))
+	bucket := rateLimitBucket{"email-login:recipient:" + hex.EncodeToString(recipient[:]), h.rateLimits.EmailRecipient}
+	if !h.rateLimits.take(w, r, bucket) {
+		return
+	}
+
+	if err := h.authService.StartEmailLogin(r.Context(), req.Email, h.publicURL("/auth/email/verify")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// VerifyEmailLogin godoc
+// @Summary      Logs in with an emailed link
+// @Description  Sets the same session cookies as the other logins and redirects to the client.
+// @Tags         auth
+// @Param        token  query  string  true  "token from the login link"
+// @Success      303
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Router       /auth/email/verify [get]
+func (h *AuthHandler) VerifyEmailLogin(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing token")
 		return
 	}
 
-	accessToken, refreshToken, err := h.authService.LoginWithGoogle(r.Context(), credential)
+	accessToken, refreshToken, err := h.authService.LoginWithEmailLink(r.Context(), token, clientInfo(r))
 	if err != nil {
-		http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
@@ -50,25 +130,233 @@ func (h *AuthHandler) GoogleCallback(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, h.redirectURL, http.StatusSeeOther)
 }
 
+// idTokenFromForm reads the ID token posted by the provider, writing the
+// problem response when there is none.
+func idTokenFromForm(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if err := r.ParseForm(); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "failed to parse form")
+		return "", false
+	}
+
+	credential := r.FormValue("id_token")
+	if credential == "" {
+		credential = r.FormValue("credential")
+	}
+	if credential == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing credential")
+		return "", false
+	}
+
+	return credential, true
+}
+
+// ListIdentities godoc
+// @Summary      Lists the login providers linked to the authenticated user
+// @Tags         auth
+// @Produce      json
+// @Success      200  {array}   domain.UserIdentity
+// @Failure      401  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/identities [get]
+func (h *AuthHandler) ListIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	identities, err := h.authService.ListIdentities(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, identities)
+}
+
+// LinkIdentity godoc
+// @Summary      Links an ID token provider to the authenticated user
+// @Description  Takes the same `id_token` or `credential` form field as the provider callback. Authorization-code providers are linked through `/oauth/{provider}/login?link=true` instead.
+// @Tags         auth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        provider    path      string  true  "login provider"
+// @Param        id_token    formData  string  true  "ID token issued by the provider"
+// @Success      201  {object}  domain.UserIdentity
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/identities/{provider} [post]
+func (h *AuthHandler) LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	credential, ok := idTokenFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	identity, err := h.authService.LinkWithIDToken(r.Context(), userID, chi.URLParam(r, "provider"), credential)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, identity)
+}
+
+// UnlinkIdentity godoc
+// @Summary      Unlinks a login provider from the authenticated user
+// @Description  The last identity of an account cannot be unlinked.
+// @Tags         auth
+// @Param        id   path  string  true  "identity id"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/identities/{id} [delete]
+func (h *AuthHandler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	if err := h.authService.UnlinkIdentity(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type createAPITokenRequest struct {
+	Name      string                 `json:"name"`
+	Scopes    []domain.APITokenScope `json:"scopes"`
+	ExpiresAt *time.Time             `json:"expires_at"`
+}
+
+type createAPITokenResponse struct {
+	*domain.APIToken
+	Token string `json:"token"`
+}
+
+// CreateAPIToken godoc
+// @Summary      Creates a personal API token
+// @Description  Scopes are `polls:write`, `votes:write` and `results:read`. The token is sent as `Authorization: Bearer <token>` and is only returned by this call.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        request  body      createAPITokenRequest  true  "request body"
+// @Success      201      {object}  createAPITokenResponse
+// @Failure      400      {object}  Problem
+// @Failure      401      {object}  Problem
+// @Failure      422      {object}  Problem
+// @Failure      500      {object}  Problem
+// @Router       /me/tokens [post]
+func (h *AuthHandler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	var req createAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "invalid request body")
+		return
+	}
+
+	input := ports.CreateAPITokenInput{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+
+	token, secret, err := h.authService.CreateAPIToken(r.Context(), userID, input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, createAPITokenResponse{APIToken: token, Token: secret})
+}
+
+// ListAPITokens godoc
+// @Summary      Lists the personal API tokens of the authenticated user
+// @Tags         auth
+// @Produce      json
+// @Success      200  {array}   domain.APIToken
+// @Failure      401  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/tokens [get]
+func (h *AuthHandler) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	tokens, err := h.authService.ListAPITokens(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// RevokeAPIToken godoc
+// @Summary      Revokes a personal API token
+// @Tags         auth
+// @Param        id   path  string  true  "token id"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/tokens/{id} [delete]
+func (h *AuthHandler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	if err := h.authService.RevokeAPIToken(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Refresh godoc
 // @Summary      Refreshes the autheticated user out
 // @Description  Creates a new access token cookie based on the refresh token. This cookie is used as authentication for `/api` calls.
 // @Tags         auth
 // @Accept       json
 // @Success      200
-// @Failure      401
+// @Failure      401  {object}  Problem
 // @Router       /auth/refresh [post]
 func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("refresh_token")
 	if err != nil {
-		http.Error(w, "Missing refresh token", http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing refresh token")
 		return
 	}
 
-	accessToken, refreshToken, err := h.authService.RefreshAccessToken(r.Context(), cookie.Value)
+	accessToken, refreshToken, err := h.authService.RefreshAccessToken(r.Context(), cookie.Value, clientInfo(r))
 	if err != nil {
 		h.expireCookies(w)
-		http.Error(w, "Refresh failed: "+err.Error(), http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
 
@@ -79,9 +367,7 @@ func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
 		h.setRefreshTokenCookie(w, refreshToken)
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"ok"}`))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
 // Logout godoc
@@ -98,9 +384,87 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.expireCookies(w)
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"ok"}`))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ListSessions godoc
+// @Summary      Lists the authenticated user sessions
+// @Description  Returns every session that can still be refreshed. The session the request was made from is flagged as `current`.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {array}   domain.Session
+// @Failure      401  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/sessions [get]
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	var current string
+	if cookie, err := r.Cookie("refresh_token"); err == nil {
+		current = cookie.Value
+	}
+
+	sessions, err := h.authService.ListSessions(r.Context(), userID, current)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary      Revokes one of the authenticated user sessions
+// @Description  The session can no longer be refreshed. Access tokens already issued to it stay valid until they expire.
+// @Tags         auth
+// @Param        id   path  string  true  "session id"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	if err := h.authService.RevokeSession(r.Context(), userID, chi.URLParam(r, "id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeAllSessions godoc
+// @Summary      Revokes every session of the authenticated user
+// @Description  Signs the user out everywhere, including the current session whose cookies are cleared.
+// @Tags         auth
+// @Success      204
+// @Failure      401  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/sessions/revoke-all [post]
+func (h *AuthHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	if err := h.authService.RevokeAllSessions(r.Context(), userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.expireCookies(w)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *AuthHandler) setAccessTokenCookie(w http.ResponseWriter, token string) {
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+// TrustedProxies are the proxies in front of the server and the single
+// header, X-Forwarded-For or Forwarded, they pass the client address in.
+type TrustedProxies struct {
+	Prefixes []netip.Prefix
+	Header   string
+}
+
+// NewClientIPMiddleware stores the address of the client in the request
+// context under ClientIPKey. The forwarding header of proxies is only
+// believed when the peer that sent it is one of them: the list of hops is
+// then walked from the nearest one back, and the first address that is not a
+// trusted proxy is taken as the client. Anyone can send forwarding headers,
+// so without trusted proxies they are ignored, and so is whichever of the
+// two the proxies were not configured with, since they would pass it on
+// untouched.
+func NewClientIPMiddleware(proxies TrustedProxies) func(http.Handler) http.Handler {
+	trusted := func(addr netip.Addr) bool {
+		for _, prefix := range proxies.Prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := peerIP(r)
+			if addr, err := netip.ParseAddr(ip); err == nil && trusted(addr.Unmap()) {
+				hops := forwardedFor(r.Header, proxies.Header)
+				for i := len(hops) - 1; i >= 0; i-- {
+					hop, err := netip.ParseAddr(hops[i])
+					if err != nil {
+						break
+					}
+					ip = hop.Unmap().String()
+					if !trusted(hop.Unmap()) {
+						break
+					}
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), ClientIPKey, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ParseTrustedProxies reads a comma-separated list of CIDR ranges. A bare
+// address is taken as a range holding only that address.
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ParseProxyHeader checks that name is a forwarding header the client IP
+// middleware understands and returns it in canonical form.
+func ParseProxyHeader(name string) (string, error) {
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	if name != "X-Forwarded-For" && name != "Forwarded" {
+		return "", fmt.Errorf("proxy header must be X-Forwarded-For or Forwarded, got %q", name)
+	}
+	return name, nil
+}
+
+// forwardedFor lists the addresses the request was forwarded for in the
+// given header, the client first.
+func forwardedFor(header http.Header, name string) []string {
+	var hops []string
+
+	if name == "Forwarded" {
+		for _, element := range strings.Split(strings.Join(header.Values("Forwarded"), ","), ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = forwardedNode(value)
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// forwardedNode strips the quotes, brackets and port a Forwarded node may
+// carry, as in for="[2001:db8::17]:4711".
+func forwardedNode(node string) string {
+	node = strings.Trim(node, `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
+
+// peerIP returns the IP address of the peer that sent the request, which is
+// the last proxy when there is one.
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// clientIP returns the address found by NewClientIPMiddleware, or the peer's
+// when the middleware is not mounted.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ClientIPKey).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+func clientInfo(r *http.Request) domain.ClientInfo {
+	return domain.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r),
+	}
+}
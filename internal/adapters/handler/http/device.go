@@ -0,0 +1,99 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+// DeviceCookie configures the cookie identifying anonymous voters. It holds
+// a random device id signed with Key, so a voter can throw their id away but
+// cannot pick another device's one to read or change its vote. Without a Key
+// no cookie is issued, and voting takes an account on every poll.
+type DeviceCookie struct {
+	Key      []byte
+	Domain   string
+	SameSite http.SameSite
+}
+
+const (
+	deviceCookieName   = "device_id"
+	deviceCookieMaxAge = 365 * 24 * 60 * 60 // 1 year
+)
+
+// NewDeviceMiddleware attaches the device id of the request's cookie to its
+// context, issuing a new cookie when there is none or its signature does not
+// match. It must be mounted after the auth middleware, as signed-in users
+// vote as themselves and are left alone.
+func NewDeviceMiddleware(config DeviceCookie) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := r.Context().Value(UserIDKey).(uuid.UUID); ok || len(config.Key) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			deviceID, ok := parseDeviceCookie(r, config.Key)
+			if !ok {
+				deviceID = uuid.New()
+				http.SetCookie(w, &http.Cookie{
+					Name:     deviceCookieName,
+					Value:    deviceID.String() + "." + signDevice(config.Key, deviceID),
+					Path:     "/",
+					Domain:   config.Domain,
+					HttpOnly: true,
+					Secure:   true,
+					SameSite: config.SameSite,
+					MaxAge:   deviceCookieMaxAge,
+				})
+			}
+
+			ctx := context.WithValue(r.Context(), DeviceIDKey, deviceID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func parseDeviceCookie(r *http.Request, key []byte) (uuid.UUID, bool) {
+	cookie, err := r.Cookie(deviceCookieName)
+	if err != nil {
+		return uuid.Nil, false
+	}
+
+	id, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return uuid.Nil, false
+	}
+	deviceID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	if !hmac.Equal([]byte(signature), []byte(signDevice(key, deviceID))) {
+		return uuid.Nil, false
+	}
+	return deviceID, true
+}
+
+func signDevice(key []byte, deviceID uuid.UUID) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(deviceID[:])
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// requestVoter is the signed-in user or else the anonymous device of the
+// request. It reports false when there is neither.
+func requestVoter(r *http.Request) (domain.Voter, bool) {
+	if userID, ok := r.Context().Value(UserIDKey).(uuid.UUID); ok {
+		return domain.UserVoter(userID), true
+	}
+	if deviceID, ok := r.Context().Value(DeviceIDKey).(uuid.UUID); ok {
+		return domain.DeviceVoter(deviceID), true
+	}
+	return domain.Voter{}, false
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+// Problem is an RFC 7807 problem details body. Code is a stable identifier
+// clients can branch on, while Detail is meant for humans and may change.
+type Problem struct {
+	Type     string              `json:"type"`
+	Title    string              `json:"title"`
+	Status   int                 `json:"status"`
+	Detail   string              `json:"detail,omitempty"`
+	Instance string              `json:"instance,omitempty"`
+	Code     string              `json:"code"`
+	Errors   []domain.FieldError `json:"errors,omitempty"`
+}
+
+const (
+	codeBadRequest       = "bad_request"
+	codeValidationFailed = "validation_failed"
+	codeUnauthorized     = "unauthorized"
+	codePayloadTooLarge  = "payload_too_large"
+	codeRateLimited      = "rate_limited"
+	codeInternalError    = "internal_error"
+
+	codeInvalidExportFormat = "invalid_export_format"
+	codeInvalidImportFile   = "invalid_import_file"
+)
+
+type errorMapping struct {
+	err    error
+	status int
+	code   string
+}
+
+var errorMappings = []errorMapping{
+	{domain.ErrPollNotFound, http.StatusNotFound, "poll_not_found"},
+	{domain.ErrInvalidPollID, http.StatusBadRequest, "invalid_poll_id"},
+	{domain.ErrInvalidUserID, http.StatusBadRequest, "invalid_user_id"},
+	{domain.ErrInvalidOption, http.StatusBadRequest, "invalid_option"},
+	{domain.ErrAlreadyVoted, http.StatusConflict, "already_voted"},
+	{domain.ErrUserNotVoted, http.StatusForbidden, "not_voted"},
+	{domain.ErrVoteNotFound, http.StatusNotFound, "vote_not_found"},
+	{domain.ErrVoteInvalidated, http.StatusConflict, "vote_invalidated"},
+	{domain.ErrLoginRequired, http.StatusUnauthorized, "login_required"},
+	{domain.ErrIPVoteLimit, http.StatusConflict, "ip_vote_limit"},
+	{domain.ErrReceiptRequired, http.StatusBadRequest, "receipt_required"},
+	{domain.ErrInvalidReceipt, http.StatusNotFound, "invalid_receipt"},
+	{domain.ErrReceiptNotCounted, http.StatusNotFound, "receipt_not_counted"},
+	{domain.ErrInvalidReceiptHash, http.StatusBadRequest, "invalid_receipt_hash"},
+	{domain.ErrTallyNotPublished, http.StatusNotFound, "tally_not_published"},
+	{domain.ErrChallengeRequired, http.StatusForbidden, "challenge_required"},
+	{domain.ErrChallengeFailed, http.StatusForbidden, "challenge_failed"},
+	{domain.ErrInvalidBucket, http.StatusBadRequest, "invalid_bucket"},
+	{domain.ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+	{domain.ErrUserBanned, http.StatusUnauthorized, "user_banned"},
+	{domain.ErrInvalidRole, http.StatusBadRequest, "invalid_role"},
+	{domain.ErrInsufficientRole, http.StatusForbidden, "insufficient_role"},
+	{domain.ErrBanSelf, http.StatusConflict, "ban_self"},
+	{domain.ErrAlreadyReported, http.StatusConflict, "already_reported"},
+	{domain.ErrReportNotFound, http.StatusNotFound, "report_not_found"},
+	{domain.ErrInvalidReportID, http.StatusBadRequest, "invalid_report_id"},
+	{domain.ErrInvalidReportStatus, http.StatusBadRequest, "invalid_report_status"},
+	{domain.ErrReportResolved, http.StatusConflict, "report_resolved"},
+	{domain.ErrVoteFlagNotFound, http.StatusNotFound, "vote_flag_not_found"},
+	{domain.ErrInvalidVoteFlagID, http.StatusBadRequest, "invalid_vote_flag_id"},
+	{domain.ErrInvalidFlagStatus, http.StatusBadRequest, "invalid_vote_flag_status"},
+	{domain.ErrVoteFlagResolved, http.StatusConflict, "vote_flag_resolved"},
+	{domain.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{domain.ErrUnknownProvider, http.StatusNotFound, "unknown_provider"},
+	{domain.ErrUnsupportedFlow, http.StatusBadRequest, "unsupported_login_flow"},
+	{domain.ErrInvalidLoginLink, http.StatusUnauthorized, "invalid_login_link"},
+	{domain.ErrIdentityNotLinked, http.StatusConflict, "identity_not_linked"},
+	{domain.ErrIdentityLinked, http.StatusConflict, "identity_already_linked"},
+	{domain.ErrIdentityNotFound, http.StatusNotFound, "identity_not_found"},
+	{domain.ErrInvalidIdentityID, http.StatusBadRequest, "invalid_identity_id"},
+	{domain.ErrLastIdentity, http.StatusConflict, "last_identity"},
+	{domain.ErrInvalidRefreshToken, http.StatusUnauthorized, "invalid_refresh_token"},
+	{domain.ErrRefreshTokenRevoked, http.StatusUnauthorized, "refresh_token_revoked"},
+	{domain.ErrRefreshTokenExpired, http.StatusUnauthorized, "refresh_token_expired"},
+	{domain.ErrRefreshTokenReused, http.StatusUnauthorized, "refresh_token_reused"},
+	{domain.ErrInvalidSessionID, http.StatusBadRequest, "invalid_session_id"},
+	{domain.ErrSessionNotFound, http.StatusNotFound, "session_not_found"},
+	{domain.ErrInvalidAPIToken, http.StatusUnauthorized, "invalid_api_token"},
+	{domain.ErrInvalidAPITokenID, http.StatusBadRequest, "invalid_api_token_id"},
+	{domain.ErrAPITokenNotFound, http.StatusNotFound, "api_token_not_found"},
+	{domain.ErrInsufficientScope, http.StatusForbidden, "insufficient_scope"},
+}
+
+// writeError translates an error returned by the core into a problem
+// response. Errors that are not part of the domain are logged and reported
+// as a generic internal error so implementation details do not leak.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *domain.ValidationError
+	if errors.As(err, &validationErr) {
+		writeProblemBody(w, Problem{
+			Status:   http.StatusUnprocessableEntity,
+			Detail:   validationErr.Error(),
+			Instance: r.URL.Path,
+			Code:     codeValidationFailed,
+			Errors:   validationErr.Fields,
+		})
+		return
+	}
+
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			writeProblem(w, r, m.status, m.code, m.err.Error())
+			return
+		}
+	}
+
+	log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+	writeProblem(w, r, http.StatusInternalServerError, codeInternalError, "")
+}
+
+// writeProblem reports errors detected by the handlers themselves, such as a
+// malformed body or a missing token.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblemBody(w, Problem{
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
+
+func writeProblemBody(w http.ResponseWriter, p Problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,273 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type ExportHandler struct {
+	service ports.ExportService
+}
+
+func NewExportHandler(service ports.ExportService) *ExportHandler {
+	return &ExportHandler{
+		service: service,
+	}
+}
+
+// ExportPoll godoc
+// @Summary      Exports the results of a poll
+// @Description  Streams the aggregated results of a poll as CSV, JSON or NDJSON. When the caller created the poll, anonymized ballots (option, timestamp and hashed voter id) are included as well. Secret ballots carry no voter hash and only the day they were cast.
+// @Tags         polls
+// @Produce      json
+// @Produce      plain
+// @Param        Authorization    header    string    true   	"authorization header"
+// @Param        id      path      int     true   "poll id"
+// @Param        format  query     string  false  "export format (csv, json or ndjson)"
+// @Success      200
+// @Failure      400  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /polls/{id}/export [get]
+func (h *ExportHandler) ExportPoll(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing poll id")
+		return
+	}
+
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	switch format {
+	case "csv", "json", "ndjson":
+	default:
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidExportFormat, "format must be csv, json or ndjson")
+		return
+	}
+
+	ew := &lazyExportWriter{w: w, format: format, filename: "poll-" + id}
+
+	err := h.service.Export(r.Context(), id, userID, ew)
+	if err != nil && !ew.started() {
+		writeError(w, r, err)
+		return
+	}
+	if err != nil {
+		// The status line is already out, all we can do is cut the stream short.
+		return
+	}
+
+	ew.ensureStarted()
+	ew.close()
+}
+
+type exportEncoder interface {
+	ports.ExportWriter
+	Close() error
+}
+
+// lazyExportWriter defers writing headers until the first record, so errors
+// raised before any output can still be reported with a proper status code.
+type lazyExportWriter struct {
+	w        http.ResponseWriter
+	format   string
+	filename string
+	enc      exportEncoder
+}
+
+func (l *lazyExportWriter) started() bool {
+	return l.enc != nil
+}
+
+func (l *lazyExportWriter) ensureStarted() {
+	if l.enc != nil {
+		return
+	}
+
+	var contentType, ext string
+	switch l.format {
+	case "csv":
+		contentType, ext = "text/csv", "csv"
+		l.enc = newCSVExportEncoder(l.w)
+	case "ndjson":
+		contentType, ext = "application/x-ndjson", "ndjson"
+		l.enc = newNDJSONExportEncoder(l.w)
+	default:
+		contentType, ext = "application/json", "json"
+		l.enc = newJSONExportEncoder(l.w)
+	}
+
+	l.w.Header().Set("Content-Type", contentType)
+	l.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, l.filename, ext))
+	l.w.WriteHeader(http.StatusOK)
+}
+
+func (l *lazyExportWriter) WriteResult(result domain.OptionResult) error {
+	l.ensureStarted()
+	return l.enc.WriteResult(result)
+}
+
+func (l *lazyExportWriter) WriteBallot(ballot domain.Ballot) error {
+	l.ensureStarted()
+	return l.enc.WriteBallot(ballot)
+}
+
+func (l *lazyExportWriter) close() {
+	_ = l.enc.Close()
+}
+
+// csvExportEncoder writes results and ballots as a single table, telling
+// them apart by the record_type column.
+type csvExportEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVExportEncoder(w io.Writer) *csvExportEncoder {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"record_type", "option_id", "option_text", "vote_count", "percentage", "voter_hash", "cast_at"})
+	return &csvExportEncoder{w: cw}
+}
+
+func (e *csvExportEncoder) WriteResult(result domain.OptionResult) error {
+	return e.w.Write([]string{
+		"result",
+		result.OptionID.String(),
+		result.OptionText,
+		strconv.FormatInt(result.VoteCount, 10),
+		strconv.FormatFloat(result.Percentage, 'f', 2, 64),
+		"",
+		"",
+	})
+}
+
+func (e *csvExportEncoder) WriteBallot(ballot domain.Ballot) error {
+	return e.w.Write([]string{
+		"ballot",
+		ballot.OptionID.String(),
+		ballot.OptionText,
+		"",
+		"",
+		ballot.VoterHash,
+		ballot.CastAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func (e *csvExportEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExportEncoder writes one JSON object per line with a "type" field
+// set to either "result" or "ballot".
+type ndjsonExportEncoder struct {
+	enc *json.Encoder
+}
+
+func newNDJSONExportEncoder(w io.Writer) *ndjsonExportEncoder {
+	return &ndjsonExportEncoder{enc: json.NewEncoder(w)}
+}
+
+func (e *ndjsonExportEncoder) WriteResult(result domain.OptionResult) error {
+	return e.enc.Encode(struct {
+		Type string `json:"type"`
+		domain.OptionResult
+	}{"result", result})
+}
+
+func (e *ndjsonExportEncoder) WriteBallot(ballot domain.Ballot) error {
+	return e.enc.Encode(struct {
+		Type string `json:"type"`
+		domain.Ballot
+	}{"ballot", ballot})
+}
+
+func (e *ndjsonExportEncoder) Close() error {
+	return nil
+}
+
+// jsonExportEncoder streams {"results": [...], "ballots": [...]} by hand, as
+// the service always sends every result before the first ballot.
+type jsonExportEncoder struct {
+	w       io.Writer
+	section string
+	count   int
+}
+
+func newJSONExportEncoder(w io.Writer) *jsonExportEncoder {
+	return &jsonExportEncoder{w: w}
+}
+
+func (e *jsonExportEncoder) WriteResult(result domain.OptionResult) error {
+	return e.writeItem("results", result)
+}
+
+func (e *jsonExportEncoder) WriteBallot(ballot domain.Ballot) error {
+	return e.writeItem("ballots", ballot)
+}
+
+func (e *jsonExportEncoder) writeItem(section string, item any) error {
+	if err := e.openSection(section); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		if _, err := io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.count++
+	_, err = e.w.Write(b)
+	return err
+}
+
+func (e *jsonExportEncoder) openSection(section string) error {
+	if e.section == section {
+		return nil
+	}
+
+	prefix := "{"
+	if e.section != "" {
+		prefix = "],"
+	}
+	e.section = section
+	e.count = 0
+	_, err := io.WriteString(e.w, prefix+`"`+section+`":[`)
+	return err
+}
+
+func (e *jsonExportEncoder) Close() error {
+	if e.section == "" {
+		_, err := io.WriteString(e.w, `{"results":[],"ballots":[]}`+"\n")
+		return err
+	}
+	suffix := "]}"
+	if e.section == "results" {
+		suffix = `],"ballots":[]}`
+	}
+	_, err := io.WriteString(e.w, suffix+"\n")
+	return err
+}
@@ -0,0 +1,22 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/vncsmyrnk/poll/internal/adapters/jwtkeys"
+)
+
+// NewJWKSHandler godoc
+// @Summary      Lists the public keys access tokens are signed with
+// @Description  Other services can verify access tokens by matching their `kid` header against these keys.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  jwtkeys.JWKS
+// @Router       /.well-known/jwks.json [get]
+func NewJWKSHandler(keys *jwtkeys.KeySet) http.HandlerFunc {
+	jwks := keys.JWKS()
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		writeJSON(w, http.StatusOK, jwks)
+	}
+}
@@ -2,78 +2,186 @@ package http
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/adapters/jwtkeys"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "user_id"
+const (
+	UserIDKey   contextKey = "user_id"
+	RoleKey     contextKey = "role"
+	ClientIPKey contextKey = "client_ip"
+	DeviceIDKey contextKey = "device_id"
+)
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("access_token")
-		var tokenString string
-		if err == nil {
-			tokenString = cookie.Value
-		}
+// NewAuthMiddleware rejects requests without an access token signed by one
+// of the keys in the set. Personal API tokens are only accepted when the
+// route lists the scopes it requires, and must have been granted all of
+// them; browser sessions are not restricted by scopes.
+func NewAuthMiddleware(keys *jwtkeys.KeySet, tokens ports.APITokenAuthenticator, scopes ...domain.APITokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString := extractToken(r)
+			if tokenString == "" {
+				writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing token")
+				return
+			}
+
+			if strings.HasPrefix(tokenString, domain.APITokenPrefix) {
+				userID, err := authenticateAPIToken(r.Context(), tokens, tokenString, scopes)
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
 
-		if tokenString == "" {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-				tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+				ctx := context.WithValue(r.Context(), UserIDKey, userID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
 			}
-		}
 
-		if tokenString == "" {
-			http.Error(w, "Unauthorized: missing token", http.StatusUnauthorized)
-			return
-		}
+			userID, role, err := parseAccessToken(tokenString, keys)
+			if err != nil {
+				writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, err.Error())
+				return
+			}
 
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, RoleKey, role)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// NewOptionalAuthMiddleware attaches the user ID to the request context when
+// a valid token is present, but lets anonymous requests through untouched.
+// A stale access token cookie falls back to anonymous, whereas a personal API
+// token that is invalid or lacks scopes is rejected: a script sending one
+// never means to act anonymously.
+func NewOptionalAuthMiddleware(keys *jwtkeys.KeySet, tokens ports.APITokenAuthenticator, scopes ...domain.APITokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString := extractToken(r)
+			if tokenString == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if strings.HasPrefix(tokenString, domain.APITokenPrefix) {
+				userID, err := authenticateAPIToken(r.Context(), tokens, tokenString, scopes)
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				ctx := context.WithValue(r.Context(), UserIDKey, userID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			userID, role, err := parseAccessToken(tokenString, keys)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
 			}
-			return []byte(secret), nil
+
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, RoleKey, role)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}
+}
 
-		if err != nil || !token.Valid {
-			http.Error(w, "Unauthorized: invalid token", http.StatusUnauthorized)
-			return
-		}
+func extractToken(r *http.Request) string {
+	cookie, err := r.Cookie("access_token")
+	if err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			http.Error(w, "Unauthorized: invalid claims", http.StatusUnauthorized)
-			return
-		}
+	authHeader := r.Header.Get("Authorization")
+	if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+
+	return ""
+}
+
+// parseAccessToken returns the user and role the token was issued to.
+// Tokens issued before roles existed carry none and get domain.RoleUser.
+func parseAccessToken(tokenString string, keys *jwtkeys.KeySet) (uuid.UUID, domain.Role, error) {
+	token, err := keys.Parse(tokenString)
+	if err != nil || !token.Valid {
+		return uuid.Nil, "", errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return uuid.Nil, "", errors.New("invalid claims")
+	}
+
+	sub, ok := claims["sub"].(string)
+	if !ok {
+		return uuid.Nil, "", errors.New("missing subject")
+	}
+
+	userID, err := uuid.Parse(sub)
+	if err != nil {
+		return uuid.Nil, "", errors.New("invalid user id")
+	}
 
-		sub, ok := claims["sub"].(string)
-		if !ok {
-			http.Error(w, "Unauthorized: missing subject", http.StatusUnauthorized)
-			return
+	role := domain.RoleUser
+	if claim, ok := claims["role"].(string); ok {
+		role = domain.Role(claim)
+		if !role.Valid() {
+			return uuid.Nil, "", errors.New("invalid role")
 		}
+	}
+
+	return userID, role, nil
+}
 
-		userID, err := uuid.Parse(sub)
-		if err != nil {
-			http.Error(w, "Unauthorized: invalid user id", http.StatusUnauthorized)
-			return
+// RequireRole only lets through users whose role includes role. It must be
+// mounted after NewAuthMiddleware. Personal API tokens never carry a role,
+// so they are always rejected. Roles are read from the access token, so a
+// change takes effect on the next refresh.
+func RequireRole(role domain.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userRole, _ := r.Context().Value(RoleKey).(domain.Role)
+			if !userRole.Includes(role) {
+				writeError(w, r, domain.ErrInsufficientRole)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func authenticateAPIToken(ctx context.Context, tokens ports.APITokenAuthenticator, tokenString string, scopes []domain.APITokenScope) (uuid.UUID, error) {
+	if len(scopes) == 0 {
+		return uuid.Nil, domain.ErrInsufficientScope
+	}
+
+	token, err := tokens.AuthenticateAPIToken(ctx, tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	for _, scope := range scopes {
+		if !token.HasScope(scope) {
+			return uuid.Nil, domain.ErrInsufficientScope
 		}
+	}
 
-		ctx := context.WithValue(r.Context(), UserIDKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	return token.UserID, nil
 }
 
 func NewCorsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
@@ -97,7 +205,7 @@ func NewCorsMiddleware(allowedOrigins []string) func(http.Handler) http.Handler
 			if allowed {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+receiptHeader)
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 			}
 
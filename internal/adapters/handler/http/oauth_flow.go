@@ -0,0 +1,246 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+const (
+	oauthFlowCookie = "oauth_flow"
+	oauthFlowTTL    = 10 * time.Minute
+
+	codeInvalidOAuthState = "invalid_oauth_state"
+)
+
+// OAuthFlowConfig configures the authorization-code flow.
+type OAuthFlowConfig struct {
+	// CallbackBaseURL is the public URL of this server. Providers redirect
+	// back to CallbackBaseURL + /oauth/{provider}/callback.
+	CallbackBaseURL string
+	// StateKey signs the cookie carrying the state and PKCE verifier
+	// between the login redirect and the callback.
+	StateKey []byte
+}
+
+// oauthFlowState is kept in a signed cookie rather than server-side, so any
+// instance can handle the callback. LinkUserID is set when the flow links the
+// provider to that user instead of logging in.
+type oauthFlowState struct {
+	Provider   string `json:"provider"`
+	State      string `json:"state"`
+	Verifier   string `json:"verifier"`
+	LinkUserID string `json:"link_user_id,omitempty"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+var errInvalidOAuthFlow = errors.New("invalid oauth flow cookie")
+
+// AuthCodeLogin godoc
+// @Summary      Starts an authorization-code login
+// @Description  Redirects to the provider with a fresh state and PKCE challenge. The provider redirects back to `/oauth/{provider}/callback`. With `link=true` the provider is linked to the authenticated user instead.
+// @Tags         auth
+// @Param        provider  path   string  true   "login provider"
+// @Param        link      query  bool    false  "link the provider to the authenticated user"
+// @Success      302
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Router       /oauth/{provider}/login [get]
+func (h *AuthHandler) AuthCodeLogin(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+
+	var linkUserID string
+	if r.URL.Query().Get("link") == "true" {
+		userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+		if !ok {
+			writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "linking requires a logged in user")
+			return
+		}
+		linkUserID = userID.String()
+	}
+
+	state, err := randomString()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	verifier, err := randomString()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL, err := h.authService.AuthCodeURL(r.Context(), provider, state, base64.RawURLEncoding.EncodeToString(challenge[:]), h.callbackURL(provider))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.setFlowCookie(w, oauthFlowState{
+		Provider:   provider,
+		State:      state,
+		Verifier:   verifier,
+		LinkUserID: linkUserID,
+		ExpiresAt:  time.Now().Add(oauthFlowTTL).Unix(),
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// AuthCodeCallback godoc
+// @Summary      Completes an authorization-code login
+// @Description  Checks the state against the flow cookie, redeems the code and sets the same session cookies as the other logins before redirecting to the client. Linking flows only add the identity to the account.
+// @Tags         auth
+// @Param        provider  path   string  true  "login provider"
+// @Param        code      query  string  true  "authorization code"
+// @Param        state     query  string  true  "state sent on login"
+// @Success      303
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Router       /oauth/{provider}/callback [get]
+func (h *AuthHandler) AuthCodeCallback(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+
+	flow, err := h.readFlowCookie(r)
+	h.expireFlowCookie(w)
+	if err != nil || flow.Provider != provider {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidOAuthState, "missing or expired login attempt")
+		return
+	}
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidOAuthState, "state does not match the login attempt")
+		return
+	}
+	if query.Get("error") != "" {
+		writeError(w, r, domain.ErrInvalidCredentials)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing code")
+		return
+	}
+
+	if flow.LinkUserID != "" {
+		h.linkWithAuthCode(w, r, flow, code)
+		return
+	}
+
+	accessToken, refreshToken, err := h.authService.LoginWithAuthCode(r.Context(), provider, code, flow.Verifier, h.callbackURL(provider), clientInfo(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	h.setAccessTokenCookie(w, accessToken)
+	h.setRefreshTokenCookie(w, refreshToken)
+
+	http.Redirect(w, r, h.redirectURL, http.StatusSeeOther)
+}
+
+// linkWithAuthCode finishes a linking flow. The user must still be the one
+// who started it, so a victim cannot be tricked into linking someone else's
+// provider account.
+func (h *AuthHandler) linkWithAuthCode(w http.ResponseWriter, r *http.Request, flow *oauthFlowState, code string) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok || userID.String() != flow.LinkUserID {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "linking requires the user who started it")
+		return
+	}
+
+	if _, err := h.authService.LinkWithAuthCode(r.Context(), userID, flow.Provider, code, flow.Verifier, h.callbackURL(flow.Provider)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, h.redirectURL, http.StatusSeeOther)
+}
+
+func (h *AuthHandler) callbackURL(provider string) string {
+	return h.publicURL("/oauth/" + provider + "/callback")
+}
+
+// publicURL resolves path against the URL this server is reachable at.
+func (h *AuthHandler) publicURL(path string) string {
+	return strings.TrimSuffix(h.oauthFlow.CallbackBaseURL, "/") + path
+}
+
+// The flow cookie is Lax regardless of the configured SameSite mode, since
+// it has to come along on the top-level redirect back from the provider.
+func (h *AuthHandler) setFlowCookie(w http.ResponseWriter, flow oauthFlowState) {
+	payload, _ := json.Marshal(flow)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthFlowCookie,
+		Value:    encoded + "." + h.signFlow(encoded),
+		Path:     "/oauth/",
+		Domain:   h.cookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oauthFlowTTL.Seconds()),
+	})
+}
+
+func (h *AuthHandler) readFlowCookie(r *http.Request) (*oauthFlowState, error) {
+	cookie, err := r.Cookie(oauthFlowCookie)
+	if err != nil {
+		return nil, errInvalidOAuthFlow
+	}
+
+	encoded, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(h.signFlow(encoded))) {
+		return nil, errInvalidOAuthFlow
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidOAuthFlow
+	}
+
+	var flow oauthFlowState
+	if err := json.Unmarshal(payload, &flow); err != nil {
+		return nil, errInvalidOAuthFlow
+	}
+	if time.Now().Unix() > flow.ExpiresAt {
+		return nil, errInvalidOAuthFlow
+	}
+
+	return &flow, nil
+}
+
+func (h *AuthHandler) expireFlowCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: oauthFlowCookie, MaxAge: -1, Path: "/oauth/", Domain: h.cookieDomain})
+}
+
+func (h *AuthHandler) signFlow(encoded string) string {
+	mac := hmac.New(sha256.New, h.oauthFlow.StateKey)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
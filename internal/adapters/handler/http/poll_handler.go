@@ -3,11 +3,13 @@ package http
 import (
 	"encoding/json"
 	"errors"
+	"mime"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/adapters/pollimport"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
@@ -23,9 +25,12 @@ func NewPollHandler(service ports.PollService) *PollHandler {
 }
 
 type createPollRequest struct {
-	Title       string   `json:"title"`
-	Description string   `json:"description"`
-	Options     []string `json:"options"`
+	Title            string   `json:"title"`
+	Description      string   `json:"description"`
+	Options          []string `json:"options"`
+	RequireChallenge bool     `json:"require_challenge"`
+	AllowAnonymous   bool     `json:"allow_anonymous"`
+	SecretBallot     bool     `json:"secret_ballot"`
 }
 
 // CreatePoll godoc
@@ -34,32 +39,95 @@ type createPollRequest struct {
 // @Accept       json
 // @Param        request body ports.CreatePollInput true "request body"
 // @Success      201
-// @Failure      500
+// @Failure      400  {object}  Problem
+// @Failure      422  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
 // @Router       /polls [post]
 func (h *PollHandler) CreatePoll(w http.ResponseWriter, r *http.Request) {
 	var req createPollRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "invalid request body")
 		return
 	}
 
 	input := ports.CreatePollInput{
-		Title:       req.Title,
-		Description: req.Description,
-		Options:     req.Options,
+		Title:            req.Title,
+		Description:      req.Description,
+		Options:          req.Options,
+		RequireChallenge: req.RequireChallenge,
+		AllowAnonymous:   req.AllowAnonymous,
+		SecretBallot:     req.SecretBallot,
+	}
+	if userID, ok := r.Context().Value(UserIDKey).(uuid.UUID); ok {
+		input.CreatedBy = &userID
 	}
 
 	poll, err := h.service.Create(r.Context(), input)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(poll); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	writeJSON(w, http.StatusCreated, poll)
+}
+
+// ImportPolls godoc
+// @Summary      Imports polls in bulk
+// @Description  Accepts a CSV file (a title column, an optional description column and option columns) or an NDJSON file (one {"title", "description", "options"} object per line) and reports the outcome of each row.
+// @Tags         polls
+// @Accept       plain
+// @Produce      json
+// @Param        Authorization    header    string    true   	"authorization header"
+// @Param        format  query     string  false  "file format (csv or ndjson), taken from the Content-Type when omitted"
+// @Success      200  {object}  ports.ImportReport
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      413  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /polls/import [post]
+func (h *PollHandler) ImportPolls(w http.ResponseWriter, r *http.Request) {
+	const maxImportSize = 10 << 20 // 10 MB
+
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
 	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+		case "text/csv":
+			format = pollimport.FormatCSV
+		case "application/x-ndjson", "application/ndjson":
+			format = pollimport.FormatNDJSON
+		}
+	}
+
+	rows, err := pollimport.Read(http.MaxBytesReader(w, r.Body, maxImportSize), format)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "import file too large")
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidImportFile, err.Error())
+		return
+	}
+
+	for i := range rows {
+		rows[i].Input.CreatedBy = &userID
+	}
+
+	report, err := h.service.Import(r.Context(), rows)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
 }
 
 // GetPoll godoc
@@ -68,36 +136,24 @@ func (h *PollHandler) CreatePoll(w http.ResponseWriter, r *http.Request) {
 // @Produce      json
 // @Param        id   path      int  true  "poll id"
 // @Success      200  {object}  domain.Poll
-// @Failure      400
-// @Failure      404
-// @Failure      500
+// @Failure      400  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
 // @Router       /polls/{id} [get]
 func (h *PollHandler) GetPoll(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		http.Error(w, "missing poll id", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing poll id")
 		return
 	}
 
 	poll, err := h.service.GetPoll(r.Context(), id)
 	if err != nil {
-		if errors.Is(err, domain.ErrInvalidPollID) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if errors.Is(err, domain.ErrPollNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(poll); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
-	}
+	writeJSON(w, http.StatusOK, poll)
 }
 
 // ListPolls godoc
@@ -107,7 +163,7 @@ func (h *PollHandler) GetPoll(w http.ResponseWriter, r *http.Request) {
 // @Param        page   query      int  fale  "current results page"
 // @Param        q      query   string  false "name search by q"
 // @Success      200  {object}  []domain.Poll
-// @Failure      500
+// @Failure      500  {object}  Problem
 // @Router       /polls [get]
 func (h *PollHandler) ListPolls(w http.ResponseWriter, r *http.Request) {
 	pageStr := r.URL.Query().Get("page")
@@ -128,14 +184,11 @@ func (h *PollHandler) ListPolls(w http.ResponseWriter, r *http.Request) {
 
 	polls, err := h.service.ListPolls(r.Context(), input)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(polls); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
-	}
+	writeJSON(w, http.StatusOK, polls)
 }
 
 // GetPollStats godoc
@@ -145,45 +198,147 @@ func (h *PollHandler) ListPolls(w http.ResponseWriter, r *http.Request) {
 // @Param        Authorization    header    string    true   	"authorization header"
 // @Param        id   path      int  true  "poll id"
 // @Success      200  {object}  map[uuid.UUID]domain.PollOptionStats
-// @Failure      400
-// @Failure      403
-// @Failure      404
-// @Failure      500
+// @Failure      400  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
 // @Router       /polls/{id}/count [get]
 func (h *PollHandler) GetPollStats(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	if id == "" {
-		http.Error(w, "missing poll id", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing poll id")
+		return
+	}
+
+	voter, ok := requestVoter(r)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	stats, err := h.service.GetPollStats(r.Context(), id, voter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// GetPollTimeline godoc
+// @Summary      Get the votes over time for a poll
+// @Description  Returns, per option, the votes gained in each time bucket and the running total, accounting for retracted votes. Available to the poll creator and to users who voted.
+// @Tags         polls
+// @Produce      json
+// @Param        Authorization    header    string    true   	"authorization header"
+// @Param        id      path      int     true   "poll id"
+// @Param        bucket  query     string  false  "bucket size (minute, hour, day or week)"
+// @Success      200  {object}  []domain.PollTimelinePoint
+// @Failure      400  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /polls/{id}/timeline [get]
+func (h *PollHandler) GetPollTimeline(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing poll id")
 		return
 	}
 
 	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
 	if !ok {
-		http.Error(w, "Unauthorized: missing user context", http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
 		return
 	}
 
-	stats, err := h.service.GetPollStats(r.Context(), id, userID)
+	bucket := domain.TimelineBucketHour
+	if b := r.URL.Query().Get("bucket"); b != "" {
+		bucket = domain.TimelineBucket(b)
+	}
+
+	points, err := h.service.GetPollTimeline(r.Context(), id, userID, bucket)
 	if err != nil {
-		if errors.Is(err, domain.ErrInvalidPollID) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if errors.Is(err, domain.ErrPollNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-		if errors.Is(err, domain.ErrUserNotVoted) {
-			http.Error(w, err.Error(), http.StatusForbidden)
-			return
-		}
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, points)
+}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// GetPollTally godoc
+// @Summary      Get the published tally of a poll
+// @Description  Returns the Merkle root over the votes counted in the poll results, as of their last processing.
+// @Tags         polls
+// @Produce      json
+// @Param        id   path      int  true  "poll id"
+// @Success      200  {object}  domain.Tally
+// @Failure      400  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /polls/{id}/audit [get]
+func (h *PollHandler) GetPollTally(w http.ResponseWriter, r *http.Request) {
+	tally, err := h.service.GetTally(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(stats); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	writeJSON(w, http.StatusOK, tally)
+}
+
+// GetTallyProof godoc
+// @Summary      Get the proof that a vote was counted
+// @Description  Returns the path from the vote with the given receipt hash up to the published Merkle root of the poll. The hash is the hex SHA-256 of the receipt, computed by the voter so the receipt itself is never sent. The vote is only found once its poll results were processed.
+// @Tags         polls
+// @Produce      json
+// @Param        id            path      int     true  "poll id"
+// @Param        receipt_hash  query     string  true  "hex SHA-256 of the vote receipt"
+// @Success      200  {object}  domain.TallyProof
+// @Failure      400  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /polls/{id}/audit/proof [get]
+func (h *PollHandler) GetTallyProof(w http.ResponseWriter, r *http.Request) {
+	receiptHash := r.URL.Query().Get("receipt_hash")
+	if receiptHash == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "missing receipt hash")
+		return
+	}
+
+	proof, err := h.service.GetTallyProof(r.Context(), chi.URLParam(r, "id"), receiptHash)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, proof)
+}
+
+// ListMyPolls godoc
+// @Summary      Lists the polls created by the authenticated user
+// @Description  Returns each poll created by the caller with its status, total votes, unique voters, last vote time and pending vote count.
+// @Tags         me
+// @Produce      json
+// @Param        Authorization    header    string    true   	"authorization header"
+// @Success      200  {object}  []domain.PollAnalytics
+// @Failure      401  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /me/polls [get]
+func (h *PollHandler) ListMyPolls(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
 	}
+
+	analytics, err := h.service.ListCreatedPolls(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, analytics)
 }
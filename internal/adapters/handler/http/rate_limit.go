@@ -0,0 +1,134 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+// RateLimitRule limits a route per signed-in user and per client IP. Both
+// buckets must have a token left for a request to go through; anonymous
+// requests only draw from the IP one. The user bucket is checked first, so a
+// user over their own limit does not use up the tokens of everyone sharing
+// their IP. Either limit may be left zero.
+type RateLimitRule struct {
+	PerUser domain.RateLimit
+	PerIP   domain.RateLimit
+}
+
+// RateLimits configures the routes that are rate limited. A nil Store turns
+// rate limiting off.
+type RateLimits struct {
+	Store ports.RateLimitStore
+	// IPKey turns the client IP into the part of the IP bucket keys, so a
+	// store kept in the database need not hold addresses. Nil keeps the IP
+	// as it is.
+	IPKey      func(ip string) string
+	CreatePoll RateLimitRule
+	Vote       RateLimitRule
+	EmailLogin RateLimitRule
+	// EmailRecipient limits the login links mailed to one address, however
+	// many users and IPs ask for them.
+	EmailRecipient domain.RateLimit
+	// Audit covers the public tally and proof routes, which anyone can
+	// call without signing in.
+	Audit RateLimitRule
+}
+
+// NewRateLimitMiddleware rejects requests with 429 once a bucket is empty.
+// Routes sharing a name share their buckets. It must be mounted after the
+// auth middleware to see the user. The outcome is reported in the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of the
+// IETF draft, plus Retry-After on rejections. When the store fails the
+// request is let through: an outage of the limiter should not take the API
+// down with it.
+func NewRateLimitMiddleware(limits RateLimits, name string, rule RateLimitRule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limits.Store == nil || (!rule.PerUser.Enabled() && !rule.PerIP.Enabled()) {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var buckets []rateLimitBucket
+			if userID, ok := r.Context().Value(UserIDKey).(uuid.UUID); ok && rule.PerUser.Enabled() {
+				buckets = append(buckets, rateLimitBucket{name + ":user:" + userID.String(), rule.PerUser})
+			}
+			if rule.PerIP.Enabled() {
+				ip := clientIP(r)
+				if limits.IPKey != nil {
+					ip = limits.IPKey(ip)
+				}
+				buckets = append(buckets, rateLimitBucket{name + ":ip:" + ip, rule.PerIP})
+			}
+
+			if limits.take(w, r, buckets...) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+type rateLimitBucket struct {
+	key   string
+	limit domain.RateLimit
+}
+
+// take draws a token from each bucket in turn, stopping at the first empty
+// one, and reports whether the request may go through. It sets the rate
+// limit headers and, when the request may not, writes the 429 response.
+func (limits RateLimits) take(w http.ResponseWriter, r *http.Request, buckets ...rateLimitBucket) bool {
+	if limits.Store == nil {
+		return true
+	}
+
+	var policies []string
+	var reported *domain.RateLimitDecision
+	for _, b := range buckets {
+		if !b.limit.Enabled() {
+			continue
+		}
+		policies = append(policies, fmt.Sprintf("%d;w=%d", b.limit.Requests, int(b.limit.Per.Seconds())))
+
+		decision, err := limits.Store.Take(r.Context(), b.key, b.limit)
+		if err != nil {
+			log.Printf("rate limit %s: %v", b.key, err)
+			return true
+		}
+
+		if reported == nil || !decision.Allowed || decision.Remaining < reported.Remaining {
+			reported = &decision
+		}
+		if !decision.Allowed {
+			break
+		}
+	}
+	if reported == nil {
+		return true
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Policy", strings.Join(policies, ", "))
+	h.Set("RateLimit-Limit", strconv.Itoa(reported.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(reported.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reported.ResetAfter)))
+
+	if !reported.Allowed {
+		retryAfter := max(ceilSeconds(reported.RetryAfter), 1)
+		h.Set("Retry-After", strconv.Itoa(retryAfter))
+		writeProblem(w, r, http.StatusTooManyRequests, codeRateLimited, fmt.Sprintf("too many requests, retry in %d seconds", retryAfter))
+		return false
+	}
+	return true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type ReportHandler struct {
+	service ports.ReportService
+}
+
+func NewReportHandler(service ports.ReportService) *ReportHandler {
+	return &ReportHandler{
+		service: service,
+	}
+}
+
+type reportRequest struct {
+	Reason  domain.ReportReason `json:"reason" enums:"spam,offensive,misleading,illegal,other"`
+	Details string              `json:"details"`
+}
+
+// ReportPoll godoc
+// @Summary      Reports a poll to the moderators
+// @Description  Each user can report a poll once. Polls with enough open reports are left out of listings until a moderator reviews them.
+// @Tags         polls
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string         true  "poll id"
+// @Param        request  body      reportRequest  true  "request body"
+// @Success      201      {object}  domain.Report
+// @Failure      400      {object}  Problem
+// @Failure      401      {object}  Problem
+// @Failure      404      {object}  Problem
+// @Failure      409      {object}  Problem
+// @Failure      422      {object}  Problem
+// @Failure      500      {object}  Problem
+// @Router       /polls/{id}/reports [post]
+func (h *ReportHandler) ReportPoll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	var req reportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "invalid request body")
+		return
+	}
+
+	input := ports.ReportPollInput{
+		PollID:     chi.URLParam(r, "id"),
+		ReporterID: userID,
+		Reason:     req.Reason,
+		Details:    req.Details,
+	}
+
+	report, err := h.service.ReportPoll(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, report)
+}
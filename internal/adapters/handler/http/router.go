@@ -7,6 +7,9 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	httpSwagger "github.com/swaggo/http-swagger"
 	_ "github.com/vncsmyrnk/poll/docs"
+	"github.com/vncsmyrnk/poll/internal/adapters/jwtkeys"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
 
 // @title           Poll API
@@ -18,35 +21,122 @@ import (
 
 // @externalDocs.description  OpenAPI
 // @externalDocs.url          https://swagger.io/resources/open-api/
-func NewHandler(pollHandler *PollHandler, voteHandler *VoteHandler, authHandler *AuthHandler, userHandler *UserHandler, allowedOrigins []string) http.Handler {
+func NewHandler(pollHandler *PollHandler, voteHandler *VoteHandler, authHandler *AuthHandler, userHandler *UserHandler, exportHandler *ExportHandler, reportHandler *ReportHandler, adminHandler *AdminHandler, keys *jwtkeys.KeySet, apiTokens ports.APITokenAuthenticator, allowedOrigins []string, rateLimits RateLimits, trustedProxies TrustedProxies, devices DeviceCookie) http.Handler {
+	requireAuth := NewAuthMiddleware(keys, apiTokens)
+	optionalAuth := NewOptionalAuthMiddleware(keys, apiTokens)
+	// requireScope also admits personal API tokens granted scope.
+	requireScope := func(scope domain.APITokenScope) func(http.Handler) http.Handler {
+		return NewAuthMiddleware(keys, apiTokens, scope)
+	}
+	// Anonymous voters are identified by their device on the routes that
+	// polls allowing anonymous votes open to them. Only requests without a
+	// token are anonymous: an invalid or expired one is refused, or a
+	// signed-in voter would silently act as their device instead.
+	identifyVoter := func(scope domain.APITokenScope) func(http.Handler) http.Handler {
+		requireAuth := NewAuthMiddleware(keys, apiTokens, scope)
+		identifyDevice := NewDeviceMiddleware(devices)
+		return func(next http.Handler) http.Handler {
+			authenticated, anonymous := requireAuth(next), identifyDevice(next)
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if extractToken(r) != "" {
+					authenticated.ServeHTTP(w, r)
+					return
+				}
+				anonymous.ServeHTTP(w, r)
+			})
+		}
+	}
+	limitPollCreation := NewRateLimitMiddleware(rateLimits, "polls", rateLimits.CreatePoll)
+	limitVotes := NewRateLimitMiddleware(rateLimits, "votes", rateLimits.Vote)
+	limitEmailLogin := NewRateLimitMiddleware(rateLimits, "email-login", rateLimits.EmailLogin)
+	limitAudit := NewRateLimitMiddleware(rateLimits, "audit", rateLimits.Audit)
+
 	r := chi.NewRouter()
+	r.Use(NewClientIPMiddleware(trustedProxies))
 	r.Use(middleware.Logger)
 	r.Use(NewCorsMiddleware(allowedOrigins))
 
+	r.Get("/.well-known/jwks.json", NewJWKSHandler(keys))
+
 	r.Route("/api", func(r chi.Router) {
-		r.With(AuthMiddleware).Get("/me", userHandler.GetMe)
+		r.Route("/me", func(r chi.Router) {
+			r.With(requireScope(domain.ScopeResultsRead)).Get("/polls", pollHandler.ListMyPolls)
+
+			r.Group(func(r chi.Router) {
+				r.Use(requireAuth)
+				r.Get("/", userHandler.GetMe)
+				r.Get("/sessions", authHandler.ListSessions)
+				r.Delete("/sessions/{id}", authHandler.RevokeSession)
+				r.Post("/sessions/revoke-all", authHandler.RevokeAllSessions)
+				r.Get("/identities", authHandler.ListIdentities)
+				r.Post("/identities/{provider}", authHandler.LinkIdentity)
+				r.Delete("/identities/{id}", authHandler.UnlinkIdentity)
+				r.Get("/tokens", authHandler.ListAPITokens)
+				r.Post("/tokens", authHandler.CreateAPIToken)
+				r.Delete("/tokens/{id}", authHandler.RevokeAPIToken)
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(requireAuth)
+
+			r.Group(func(r chi.Router) {
+				r.Use(RequireRole(domain.RoleModerator))
+				r.Get("/polls", adminHandler.ListPolls)
+				r.Post("/polls/{id}/hide", adminHandler.HidePoll)
+				r.Post("/polls/{id}/restore", adminHandler.RestorePoll)
+				r.Get("/reports", adminHandler.ListReports)
+				r.Post("/reports/{id}/dismiss", adminHandler.DismissReport)
+				r.Post("/reports/{id}/action", adminHandler.ActionReport)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(RequireRole(domain.RoleAdmin))
+				r.Post("/users/{id}/ban", adminHandler.BanUser)
+				r.Post("/users/{id}/unban", adminHandler.UnbanUser)
+				r.Post("/users/{id}/votes/invalidate", adminHandler.InvalidateUserVotes)
+				r.Get("/users/{id}/refresh-tokens", adminHandler.ListRefreshTokens)
+				r.Get("/audit", adminHandler.ListAuditLog)
+				r.Get("/vote-flags", adminHandler.ListVoteFlags)
+				r.Post("/vote-flags/{id}/dismiss", adminHandler.DismissVoteFlag)
+				r.Post("/vote-flags/{id}/invalidate", adminHandler.InvalidateFlaggedVote)
+			})
+		})
 
 		r.Route("/polls", func(r chi.Router) {
 			r.Get("/", pollHandler.ListPolls)
-			r.Post("/", pollHandler.CreatePoll)
+			r.With(NewOptionalAuthMiddleware(keys, apiTokens, domain.ScopePollsWrite), limitPollCreation).Post("/", pollHandler.CreatePoll)
+			r.With(requireScope(domain.ScopePollsWrite), limitPollCreation).Post("/import", pollHandler.ImportPolls)
 			r.Get("/{id}", pollHandler.GetPoll)
-			r.With(AuthMiddleware).Get("/{id}/count", pollHandler.GetPollStats)
+			r.With(identifyVoter(domain.ScopeResultsRead)).Get("/{id}/count", pollHandler.GetPollStats)
+			r.With(requireScope(domain.ScopeResultsRead)).Get("/{id}/timeline", pollHandler.GetPollTimeline)
+			r.With(requireScope(domain.ScopeResultsRead)).Get("/{id}/export", exportHandler.ExportPoll)
+			r.With(limitAudit).Get("/{id}/audit", pollHandler.GetPollTally)
+			r.With(limitAudit).Get("/{id}/audit/proof", pollHandler.GetTallyProof)
 
+			r.Get("/{id}/challenge", voteHandler.GetChallenge)
 			r.Route("/{id}/votes", func(r chi.Router) {
-				r.Use(AuthMiddleware)
-				r.Post("/", voteHandler.VoteOnPoll)
+				r.Use(identifyVoter(domain.ScopeVotesWrite))
+				r.With(limitVotes).Post("/", voteHandler.VoteOnPoll)
+				r.With(limitVotes).Delete("/", voteHandler.RetractVote)
 			})
-			r.With(AuthMiddleware).Get("/{id}/my-vote", voteHandler.GetMyVote)
+			r.With(identifyVoter(domain.ScopeVotesWrite)).Get("/{id}/my-vote", voteHandler.GetMyVote)
+			r.With(requireAuth).Post("/{id}/reports", reportHandler.ReportPoll)
 		})
 	})
 
 	r.Route("/oauth", func(r chi.Router) {
 		r.Post("/callback", authHandler.GoogleCallback)
+		r.With(optionalAuth).Get("/{provider}/login", authHandler.AuthCodeLogin)
+		r.With(optionalAuth).Get("/{provider}/callback", authHandler.AuthCodeCallback)
+		r.Post("/{provider}/callback", authHandler.ProviderCallback)
 	})
 
 	r.Route("/auth", func(r chi.Router) {
 		r.Post("/logout", authHandler.Logout)
 		r.Post("/refresh", authHandler.Refresh)
+		r.With(limitEmailLogin).Post("/email/start", authHandler.StartEmailLogin)
+		r.Get("/email/verify", authHandler.VerifyEmailLogin)
 	})
 
 	r.Get("/swagger/*", httpSwagger.Handler())
@@ -1,7 +1,6 @@
 package http
 
 import (
-	"encoding/json"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -21,20 +20,15 @@ func NewUserHandler(service ports.UserService) *UserHandler {
 func (h *UserHandler) GetMe(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
 	if !ok {
-		http.Error(w, "Unauthorized: missing user context", http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
 		return
 	}
 
 	user, err := h.service.GetByID(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "Failed to fetch user: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	writeJSON(w, http.StatusOK, user)
 }
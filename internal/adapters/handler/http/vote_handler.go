@@ -2,8 +2,6 @@ package http
 
 import (
 	"encoding/json"
-	"errors"
-	"net"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
@@ -12,6 +10,10 @@ import (
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
 
+// receiptHeader carries the ballot receipt on requests without a body, so it
+// stays out of URLs and the logs that record them.
+const receiptHeader = "X-Vote-Receipt"
+
 type VoteHandler struct {
 	service ports.VoteService
 }
@@ -22,107 +24,98 @@ func NewVoteHandler(service ports.VoteService) *VoteHandler {
 	}
 }
 
+// voteRequest carries ChallengeResponse on polls that require a challenge:
+// the captcha token, or "<token>:<counter>" for proof of work. Receipt is
+// the one of the current ballot when changing a vote on a secret ballot poll.
 type voteRequest struct {
-	OptionID uuid.UUID `json:"option_id"`
+	OptionID          uuid.UUID `json:"option_id"`
+	ChallengeResponse string    `json:"challenge_response,omitempty"`
+	Receipt           string    `json:"receipt,omitempty"`
 }
 
 // VoteOnPoll godoc
 // @Summary      Casts a vote on a poll
+// @Description  The response is the receipt of the vote, which only its voter gets. It proves the vote was counted through /polls/{id}/audit/proof, and on secret ballot polls it is the only way to see, change or retract the vote later.
 // @Tags         polls
 // @Accept       json
+// @Produce      json
 // @Param        Authorization  header  string       true  "authorization header"
 // @Param        request        body    voteRequest  true  "request body"
 // @Param        id             path    int          true  "poll id"
-// @Success      201
-// @Failure      400
-// @Failure      404
-// @Failure      409
-// @Failure      500
+// @Success      201  {object}  domain.VoteReceipt
+// @Failure      400  {object}  Problem
+// @Failure      403  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
 // @Router       /polls/{id}/votes [post]
 func (h *VoteHandler) VoteOnPoll(w http.ResponseWriter, r *http.Request) {
 	pollIDStr := chi.URLParam(r, "id")
 	pollID, err := uuid.Parse(pollIDStr)
 	if err != nil {
-		http.Error(w, "invalid poll id", http.StatusBadRequest)
+		writeError(w, r, domain.ErrInvalidPollID)
 		return
 	}
 
 	var req voteRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, codeBadRequest, "invalid request body")
 		return
 	}
 
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		ip = r.RemoteAddr
-	}
-
-	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	voter, ok := requestVoter(r)
 	if !ok {
-		http.Error(w, "Unauthorized: missing user context", http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
 		return
 	}
 
 	input := ports.VoteInput{
-		PollID:   pollID,
-		OptionID: req.OptionID,
-		UserID:   userID,
-		VoterIP:  ip,
-	}
-
-	if err := h.service.Vote(r.Context(), input); err != nil {
-		if errors.Is(err, domain.ErrInvalidOption) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		if errors.Is(err, domain.ErrAlreadyVoted) {
-			http.Error(w, err.Error(), http.StatusConflict)
-			return
-		}
-		if errors.Is(err, domain.ErrPollNotFound) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-			return
-		}
-
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		PollID:            pollID,
+		OptionID:          req.OptionID,
+		Voter:             voter,
+		VoterIP:           clientIP(r),
+		ChallengeResponse: req.ChallengeResponse,
+		Receipt:           req.Receipt,
+	}
+
+	receipt, err := h.service.Vote(r.Context(), input)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, http.StatusCreated, receipt)
 }
 
 // GetMyVote godoc
 // @Summary      Gets the user vote on a poll
 // @Tags         polls
 // @Produce      json
-// @Param        Authorization  header  string       true  "authorization header"
-// @Param        id             path    int          true  "poll id"
+// @Param        Authorization   header  string  true   "authorization header"
+// @Param        id              path    int     true   "poll id"
+// @Param        X-Vote-Receipt  header  string  false  "ballot receipt, required on secret ballot polls"
 // @Success      200  {object}  domain.Vote
-// @Failure      400
-// @Failure      404
-// @Failure      500
+// @Failure      400  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
 // @Router       /polls/{id}/my-vote [get]
 func (h *VoteHandler) GetMyVote(w http.ResponseWriter, r *http.Request) {
 	pollIDStr := chi.URLParam(r, "id")
 	pollID, err := uuid.Parse(pollIDStr)
 	if err != nil {
-		http.Error(w, "invalid poll id", http.StatusBadRequest)
+		writeError(w, r, domain.ErrInvalidPollID)
 		return
 	}
 
-	userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+	voter, ok := requestVoter(r)
 	if !ok {
-		http.Error(w, "Unauthorized: missing user context", http.StatusUnauthorized)
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
 		return
 	}
 
-	vote, err := h.service.GetUserVote(r.Context(), pollID, userID)
+	vote, err := h.service.GetVote(r.Context(), pollID, voter, r.Header.Get(receiptHeader))
 	if err != nil {
-		if errors.Is(err, domain.ErrUserNotVoted) {
-			http.Error(w, "vote not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -130,8 +123,72 @@ func (h *VoteHandler) GetMyVote(w http.ResponseWriter, r *http.Request) {
 		"option_id": vote.OptionID,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RetractVote godoc
+// @Summary      Retracts the user vote on a poll
+// @Description  Responds 409 when an admin invalidated the vote.
+// @Tags         polls
+// @Param        Authorization   header  string  true   "authorization header"
+// @Param        id              path    int     true   "poll id"
+// @Param        X-Vote-Receipt  header  string  false  "ballot receipt, required on secret ballot polls"
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      401  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      409  {object}  Problem
+// @Failure      429  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /polls/{id}/votes [delete]
+func (h *VoteHandler) RetractVote(w http.ResponseWriter, r *http.Request) {
+	pollID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, domain.ErrInvalidPollID)
+		return
+	}
+
+	voter, ok := requestVoter(r)
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing user context")
+		return
+	}
+
+	if err := h.service.RetractVote(r.Context(), pollID, voter, r.Header.Get(receiptHeader)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetChallenge godoc
+// @Summary      Gets the challenge to solve before voting on a poll
+// @Description  Responds 204 when the poll does not require a challenge.
+// @Tags         polls
+// @Produce      json
+// @Param        id   path      int  true  "poll id"
+// @Success      200  {object}  domain.Challenge
+// @Success      204
+// @Failure      400  {object}  Problem
+// @Failure      404  {object}  Problem
+// @Failure      500  {object}  Problem
+// @Router       /polls/{id}/challenge [get]
+func (h *VoteHandler) GetChallenge(w http.ResponseWriter, r *http.Request) {
+	pollID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, r, domain.ErrInvalidPollID)
+		return
+	}
+
+	challenge, err := h.service.GetChallenge(r.Context(), pollID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if challenge == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
+
+	writeJSON(w, http.StatusOK, challenge)
 }
@@ -0,0 +1,287 @@
+// Package jwtkeys holds the keys access tokens are signed and verified with.
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key type, expected RSA or Ed25519")
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrNotPrivateKey  = errors.New("signing key must be a private key")
+)
+
+// Key is a single signing or verification key. Asymmetric keys are
+// identified by their RFC 7638 thumbprint, which is sent as the kid header.
+type Key struct {
+	ID     string
+	method jwt.SigningMethod
+	sign   any
+	verify any
+	public crypto.PublicKey
+}
+
+// NewKey wraps an *rsa.PrivateKey, *rsa.PublicKey, ed25519.PrivateKey or
+// ed25519.PublicKey. Only private keys can be used to sign.
+func NewKey(key any) (*Key, error) {
+	k := &Key{}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.sign, k.public = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.method, k.sign, k.public = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.method, k.public = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	k.verify = k.public
+
+	thumbprint, err := json.Marshal(k.jwk())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(thumbprint)
+	k.ID = base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return k, nil
+}
+
+// NewHMACKey returns a shared secret key for HS256. It has no ID and is never
+// published in the JWKS.
+func NewHMACKey(secret []byte) *Key {
+	return &Key{method: jwt.SigningMethodHS256, sign: secret, verify: secret}
+}
+
+// LoadKey reads a PEM encoded key from path. PKCS#1, PKCS#8 and PKIX
+// encodings are accepted.
+func LoadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+
+	return NewKey(key)
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// PublicKey decodes an RSA, EC or Ed25519 JWK, as published by identity
+// providers.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwk returns only the required members, in lexicographic order, which is
+// the exact input RFC 7638 hashes for the thumbprint.
+func (k *Key) jwk() any {
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		return struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		}
+	case ed25519.PublicKey:
+		return struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{
+			Crv: "Ed25519",
+			Kty: "OKP",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	}
+	return nil
+}
+
+func (k *Key) publicJWK() JWK {
+	j := JWK{Kid: k.ID, Use: "sig", Alg: k.method.Alg()}
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		j.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		j.Kty = "OKP"
+		j.Crv = "Ed25519"
+		j.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return j
+}
+
+// KeySet signs new tokens with a single key and accepts tokens signed by any
+// of its keys, so a key being rotated out keeps verifying the tokens it
+// issued until they expire.
+type KeySet struct {
+	signing *Key
+	ordered []*Key
+	keys    map[string]*Key
+	methods []string
+}
+
+// NewKeySet returns a key set that signs with signing and also verifies with
+// the given retired or external keys.
+func NewKeySet(signing *Key, verification ...*Key) (*KeySet, error) {
+	if signing == nil || signing.sign == nil {
+		return nil, ErrNotPrivateKey
+	}
+
+	s := &KeySet{signing: signing, keys: map[string]*Key{}}
+	seen := map[string]bool{}
+	for _, k := range append([]*Key{signing}, verification...) {
+		if _, ok := s.keys[k.ID]; ok {
+			continue
+		}
+		s.keys[k.ID] = k
+		s.ordered = append(s.ordered, k)
+		if alg := k.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			s.methods = append(s.methods, alg)
+		}
+	}
+
+	return s, nil
+}
+
+// Sign signs claims with the signing key and sets the kid header.
+func (s *KeySet) Sign(claims map[string]any) (string, error) {
+	token := jwt.NewWithClaims(s.signing.method, jwt.MapClaims(claims))
+	if s.signing.ID != "" {
+		token.Header["kid"] = s.signing.ID
+	}
+	return token.SignedString(s.signing.sign)
+}
+
+// Parse verifies tokenString against the key named by its kid header. Shared
+// secrets have no ID, so tokens without a kid are only accepted when the set
+// holds one.
+func (s *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, s.keyfunc, jwt.WithValidMethods(s.methods))
+}
+
+func (s *KeySet) keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verify, nil
+}
+
+// JWKS returns the public keys of the set. Shared secrets are left out.
+func (s *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, k := range s.ordered {
+		if k.public != nil {
+			jwks.Keys = append(jwks.Keys, k.publicJWK())
+		}
+	}
+	return jwks
+}
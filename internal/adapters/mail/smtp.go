@@ -0,0 +1,77 @@
+// Package mail delivers the emails sent through ports.Mailer.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	netmail "net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from *netmail.Address
+}
+
+// NewSMTPMailer sends through the SMTP server at addr (host:port). Username
+// and password are optional; when set, PLAIN auth is used, which net/smtp
+// only allows over TLS or to localhost.
+func NewSMTPMailer(addr, username, password, from string) (ports.Mailer, error) {
+	sender, err := netmail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{addr: addr, auth: auth, from: sender}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, email ports.Email) error {
+	msg, err := m.message(email)
+	if err != nil {
+		return err
+	}
+
+	// net/smtp has no context support, so cancellation is only honoured
+	// before the connection is made.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.from.Address, []string{email.To}, msg)
+}
+
+func (m *SMTPMailer) message(email ports.Email) ([]byte, error) {
+	to, err := netmail.ParseAddress(email.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+	if strings.ContainsAny(email.Subject, "\r\n") {
+		return nil, errors.New("subject must be a single line")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,132 @@
+// Package github logs users in through GitHub's OAuth authorization-code
+// flow. GitHub does not issue ID tokens, so the profile is read from its API.
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+	"golang.org/x/oauth2"
+	githuboauth "golang.org/x/oauth2/github"
+)
+
+var ErrNoVerifiedEmail = errors.New("github account has no verified primary email")
+
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	// Endpoint and APIURL default to github.com and are only overridden to
+	// point at GitHub Enterprise or a stub.
+	Endpoint   oauth2.Endpoint
+	APIURL     string
+	HTTPClient *http.Client
+}
+
+type AuthCodeFlow struct {
+	config Config
+}
+
+func NewAuthCodeFlow(config Config) ports.AuthCodeFlow {
+	if config.Endpoint.AuthURL == "" {
+		config.Endpoint = githuboauth.Endpoint
+	}
+	if config.APIURL == "" {
+		config.APIURL = "https://api.github.com"
+	}
+	config.APIURL = strings.TrimSuffix(config.APIURL, "/")
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &AuthCodeFlow{config: config}
+}
+
+func (f *AuthCodeFlow) AuthCodeURL(ctx context.Context, state, codeChallenge, redirectURI string) (string, error) {
+	return f.oauth2Config(redirectURI).AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+func (f *AuthCodeFlow) Exchange(ctx context.Context, code, codeVerifier, redirectURI string) (*ports.TokenPayload, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, f.config.HTTPClient)
+
+	token, err := f.oauth2Config(redirectURI).Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(token))
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := f.get(ctx, client, "/user", &user); err != nil {
+		return nil, err
+	}
+
+	// The email on /user is whatever the user made public, which may be
+	// unverified or missing, so the primary verified one is looked up.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := f.get(ctx, client, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+
+	payload := &ports.TokenPayload{Subject: strconv.FormatInt(user.ID, 10), EmailVerified: true, Name: user.Name}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			payload.Email = e.Email
+		}
+	}
+	if payload.Email == "" {
+		return nil, ErrNoVerifiedEmail
+	}
+	if payload.Name == "" {
+		payload.Name = user.Login
+	}
+
+	return payload, nil
+}
+
+func (f *AuthCodeFlow) oauth2Config(redirectURI string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     f.config.ClientID,
+		ClientSecret: f.config.ClientSecret,
+		Endpoint:     f.config.Endpoint,
+		RedirectURL:  redirectURI,
+		Scopes:       []string{"read:user", "user:email"},
+	}
+}
+
+func (f *AuthCodeFlow) get(ctx context.Context, client *http.Client, path string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.config.APIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, path)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
@@ -27,5 +27,6 @@ func (v *GoogleVerifier) Verify(ctx context.Context, token string, clientID stri
 	if !ok {
 		return nil, errors.New("name not found in claims")
 	}
-	return &ports.TokenPayload{Email: email, Name: name}, nil
+	verified, _ := payload.Claims["email_verified"].(bool)
+	return &ports.TokenPayload{Subject: payload.Subject, Email: email, EmailVerified: verified, Name: name}, nil
 }
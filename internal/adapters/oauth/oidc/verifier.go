@@ -0,0 +1,186 @@
+// Package oidc verifies ID tokens issued by any OpenID Connect provider.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/vncsmyrnk/poll/internal/adapters/jwtkeys"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+const (
+	// keysTTL bounds how long fetched signing keys are trusted.
+	keysTTL = time.Hour
+	// minRefreshInterval throttles refetching the JWKS when tokens name a
+	// key we do not know, so forged kids cannot hammer the provider.
+	minRefreshInterval = time.Minute
+)
+
+var ErrUnknownKey = errors.New("token signed with an unknown key")
+
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Metadata is the part of the discovery document the verifier relies on.
+type Metadata struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+type Verifier struct {
+	issuer string
+	client *http.Client
+
+	mu        sync.Mutex
+	metadata  *Metadata
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewVerifier returns a verifier for tokens issued by issuer. Discovery
+// happens on first use, so the provider does not have to be up at startup.
+func NewVerifier(issuer string, client *http.Client) ports.TokenVerifier {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Verifier{
+		issuer: strings.TrimSuffix(issuer, "/"),
+		client: client,
+	}
+}
+
+func (v *Verifier) Verify(ctx context.Context, token string, clientID string) (*ports.TokenPayload, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(v.issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("subject not found in claims")
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return nil, errors.New("email not found in claims")
+	}
+	verified, _ := claims["email_verified"].(bool)
+
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name, _ = claims["preferred_username"].(string)
+	}
+	if name == "" {
+		name = email
+	}
+
+	return &ports.TokenPayload{Subject: subject, Email: email, EmailVerified: verified, Name: name}, nil
+}
+
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	age := time.Since(v.fetchedAt)
+	key, ok := v.keys[kid]
+	if ok && age < keysTTL {
+		return key, nil
+	}
+	if ok || age >= minRefreshInterval {
+		if err := v.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = v.keys[kid]
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return key, nil
+}
+
+func (v *Verifier) discover(ctx context.Context) (*Metadata, error) {
+	if v.metadata != nil {
+		return v.metadata, nil
+	}
+
+	var m Metadata
+	if err := v.getJSON(ctx, v.issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != v.issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", m.Issuer, v.issuer)
+	}
+	if m.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+
+	v.metadata = &m
+	return v.metadata, nil
+}
+
+func (v *Verifier) refreshKeys(ctx context.Context) error {
+	m, err := v.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	var jwks jwtkeys.JWKS
+	if err := v.getJSON(ctx, m.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			// Providers may publish key types we do not use; skip them
+			// rather than failing every login.
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	return nil
+}
+
+func (v *Verifier) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
@@ -0,0 +1,39 @@
+// Package oauth groups the identity providers users can log in with.
+package oauth
+
+import (
+	"sort"
+
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type Registry struct {
+	providers map[string]*ports.LoginProvider
+}
+
+func NewRegistry(providers ...*ports.LoginProvider) *Registry {
+	r := &Registry{providers: make(map[string]*ports.LoginProvider, len(providers))}
+	for _, p := range providers {
+		r.Register(p)
+	}
+	return r
+}
+
+// Register adds p, replacing any provider already registered under its name.
+func (r *Registry) Register(p *ports.LoginProvider) {
+	r.providers[p.Name] = p
+}
+
+func (r *Registry) Get(name string) (*ports.LoginProvider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,152 @@
+package pollimport
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported import format")
+
+// Read parses polls from r. A row that cannot be parsed is returned with its
+// Err set so it shows up in the import report; only problems that make the
+// whole file unreadable are returned as an error.
+func Read(r io.Reader, format string) ([]ports.ImportPollRow, error) {
+	switch format {
+	case FormatCSV:
+		return readCSV(r)
+	case FormatNDJSON:
+		return readNDJSON(r)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// readCSV expects a header with a "title" column, an optional "description"
+// column and one or more columns whose name starts with "option".
+func readCSV(r io.Reader) ([]ports.ImportPollRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+
+	titleCol, descriptionCol := -1, -1
+	var optionCols []int
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case name == "title":
+			titleCol = i
+		case name == "description":
+			descriptionCol = i
+		case strings.HasPrefix(name, "option"):
+			optionCols = append(optionCols, i)
+		}
+	}
+	if titleCol == -1 {
+		return nil, errors.New("csv header must have a title column")
+	}
+	if len(optionCols) == 0 {
+		return nil, errors.New("csv header must have at least one option column")
+	}
+
+	var rows []ports.ImportPollRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rows = append(rows, ports.ImportPollRow{Row: parseErr.StartLine, Err: errors.New("malformed csv row")})
+				continue
+			}
+			return nil, fmt.Errorf("failed to read csv: %w", err)
+		}
+		line, _ := cr.FieldPos(0)
+
+		if len(record) > len(header) {
+			rows = append(rows, ports.ImportPollRow{Row: line, Err: errors.New("row has more columns than the header")})
+			continue
+		}
+
+		cell := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		input := ports.CreatePollInput{
+			Title:       cell(titleCol),
+			Description: cell(descriptionCol),
+		}
+		for _, col := range optionCols {
+			if opt := cell(col); opt != "" {
+				input.Options = append(input.Options, opt)
+			}
+		}
+
+		rows = append(rows, ports.ImportPollRow{Row: line, Input: input})
+	}
+
+	return rows, nil
+}
+
+type ndjsonPoll struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Options     []string `json:"options"`
+}
+
+// readNDJSON expects one {"title", "description", "options"} object per line.
+func readNDJSON(r io.Reader) ([]ports.ImportPollRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []ports.ImportPollRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var p ndjsonPoll
+		if err := json.Unmarshal([]byte(text), &p); err != nil {
+			rows = append(rows, ports.ImportPollRow{Row: line, Err: errors.New("malformed json line")})
+			continue
+		}
+
+		rows = append(rows, ports.ImportPollRow{
+			Row: line,
+			Input: ports.CreatePollInput{
+				Title:       p.Title,
+				Description: p.Description,
+				Options:     p.Options,
+			},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ndjson: %w", err)
+	}
+
+	return rows, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
+	"github.com/lib/pq"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
@@ -19,16 +21,16 @@ func NewAuthRepository(db *sql.DB) ports.AuthRepository {
 
 func (r *AuthRepository) StoreRefreshToken(ctx context.Context, token *domain.RefreshToken) error {
 	query := `
-		INSERT INTO refresh_tokens (user_id, token_hash, expires_at, revoked)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, revoked, user_agent, ip_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, created_at
 	`
-	return r.db.QueryRowContext(ctx, query, token.UserID, token.TokenHash, token.ExpiresAt, token.Revoked).Scan(&token.ID, &token.CreatedAt)
+	return r.db.QueryRowContext(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt, token.Revoked, token.UserAgent, token.IPAddress).Scan(&token.ID, &token.CreatedAt)
 }
 
 func (r *AuthRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*domain.RefreshToken, error) {
 	query := `
-		SELECT id, user_id, token_hash, expires_at, revoked, created_at
+		SELECT id, user_id, family_id, token_hash, expires_at, revoked, replaced_by, user_agent, ip_address, created_at
 		FROM refresh_tokens
 		WHERE token_hash = $1
 	`
@@ -36,9 +38,13 @@ func (r *AuthRepository) GetRefreshTokenByHash(ctx context.Context, tokenHash st
 	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
 		&token.ID,
 		&token.UserID,
+		&token.FamilyID,
 		&token.TokenHash,
 		&token.ExpiresAt,
 		&token.Revoked,
+		&token.ReplacedBy,
+		&token.UserAgent,
+		&token.IPAddress,
 		&token.CreatedAt,
 	)
 	if err != nil {
@@ -55,3 +61,274 @@ func (r *AuthRepository) RevokeRefreshToken(ctx context.Context, id string) erro
 	_, err := r.db.ExecContext(ctx, query, id)
 	return err
 }
+
+func (r *AuthRepository) RotateRefreshToken(ctx context.Context, oldID string, next *domain.RefreshToken) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insert := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, revoked, user_agent, ip_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+	err = tx.QueryRowContext(ctx, insert, next.UserID, next.FamilyID, next.TokenHash, next.ExpiresAt, next.Revoked, next.UserAgent, next.IPAddress).Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	// The revoked = false guard makes two concurrent refreshes with the same
+	// token race on this row, and only one of them can win.
+	revoke := `UPDATE refresh_tokens SET revoked = true, replaced_by = $2 WHERE id = $1 AND revoked = false`
+	res, err := tx.ExecContext(ctx, revoke, oldID, next.ID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrRefreshTokenRevoked
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *AuthRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE refresh_tokens SET revoked = true WHERE family_id = $1 AND revoked = false`
+	_, err := r.db.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// ListSessions relies on rotation leaving a single active token per family:
+// that token was issued at the last refresh, and the oldest token of the
+// family at login.
+func (r *AuthRepository) ListSessions(ctx context.Context, userID string) ([]*domain.Session, error) {
+	query := `
+		SELECT
+			rt.family_id,
+			rt.user_agent,
+			rt.ip_address,
+			(SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = rt.family_id),
+			rt.created_at,
+			rt.expires_at
+		FROM refresh_tokens rt
+		WHERE rt.user_id = $1 AND rt.revoked = false AND rt.expires_at > NOW()
+		ORDER BY rt.created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*domain.Session{}
+	for rows.Next() {
+		s := &domain.Session{}
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IPAddress, &s.CreatedAt, &s.LastUsedAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (r *AuthRepository) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	query := `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1 AND family_id = $2 AND revoked = false`
+	res, err := r.db.ExecContext(ctx, query, userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r *AuthRepository) RevokeAllSessions(ctx context.Context, userID string) error {
+	query := `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1 AND revoked = false`
+	_, err := conn(ctx, r.db).ExecContext(ctx, query, userID)
+	return err
+}
+
+func (r *AuthRepository) ListRefreshTokens(ctx context.Context, userID string) ([]*domain.RefreshToken, error) {
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, revoked, replaced_by, user_agent, ip_address, created_at
+		FROM refresh_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query refresh tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []*domain.RefreshToken{}
+	for rows.Next() {
+		token := &domain.RefreshToken{}
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.FamilyID,
+			&token.TokenHash,
+			&token.ExpiresAt,
+			&token.Revoked,
+			&token.ReplacedBy,
+			&token.UserAgent,
+			&token.IPAddress,
+			&token.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan refresh token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (r *AuthRepository) StoreEmailLoginToken(ctx context.Context, token *domain.EmailLoginToken) error {
+	query := `
+		INSERT INTO email_login_tokens (email, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRowContext(ctx, query, token.Email, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (r *AuthRepository) ConsumeEmailLoginToken(ctx context.Context, tokenHash string) (*domain.EmailLoginToken, error) {
+	query := `
+		UPDATE email_login_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, email, token_hash, expires_at, used_at, created_at
+	`
+	token := &domain.EmailLoginToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.Email,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *AuthRepository) StoreAPIToken(ctx context.Context, token *domain.APIToken) error {
+	query := `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	scopes := make([]string, len(token.Scopes))
+	for i, s := range token.Scopes {
+		scopes[i] = string(s)
+	}
+	return r.db.QueryRowContext(ctx, query, token.UserID, token.Name, token.TokenHash, pq.Array(scopes), token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (r *AuthRepository) GetAPITokenByHash(ctx context.Context, tokenHash string) (*domain.APIToken, error) {
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_hash, t.scopes, t.expires_at, t.last_used_at, t.created_at
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND u.deleted_at IS NULL
+	`
+	token, err := scanAPIToken(r.db.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *AuthRepository) ListAPITokens(ctx context.Context, userID string) ([]*domain.APIToken, error) {
+	query := `
+		SELECT id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []*domain.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (r *AuthRepository) DeleteAPIToken(ctx context.Context, userID, tokenID string) error {
+	query := `DELETE FROM api_tokens WHERE user_id = $1 AND id = $2`
+	res, err := r.db.ExecContext(ctx, query, userID, tokenID)
+	if err != nil {
+		return fmt.Errorf("failed to delete api token: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete api token: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func (r *AuthRepository) TouchAPIToken(ctx context.Context, id string) error {
+	query := `UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+func scanAPIToken(row interface{ Scan(dest ...any) error }) (*domain.APIToken, error) {
+	token := &domain.APIToken{}
+	var scopes []string
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		pq.Array(&scopes),
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scopes {
+		token.Scopes = append(token.Scopes, domain.APITokenScope(s))
+	}
+	return token, nil
+}
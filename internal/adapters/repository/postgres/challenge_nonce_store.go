@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+// ChallengeNonceStore shares spent proof of work nonces between every
+// replica using the same database.
+type ChallengeNonceStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func NewChallengeNonceStore(db *sql.DB) ports.ChallengeNonceStore {
+	return &ChallengeNonceStore{db: db, lastPrune: time.Now()}
+}
+
+// Spend inserts the nonce unless it is already there, so concurrent requests
+// on different replicas cannot both redeem it.
+func (s *ChallengeNonceStore) Spend(ctx context.Context, nonce string, expiresAt time.Time) (bool, error) {
+	s.pruneExpired(ctx)
+
+	query := `INSERT INTO challenge_nonces (nonce, expires_at) VALUES ($1, $2) ON CONFLICT (nonce) DO NOTHING`
+	res, err := s.db.ExecContext(ctx, query, nonce, expiresAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to spend challenge nonce: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to spend challenge nonce: %w", err)
+	}
+	return affected == 1, nil
+}
+
+// pruneExpired deletes the nonces of expired tokens at most once per minute
+// per replica. Expired tokens are refused before their nonce is looked up,
+// so they no longer need remembering.
+func (s *ChallengeNonceStore) pruneExpired(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastPrune) < time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM challenge_nonces WHERE expires_at <= NOW()")
+	if err != nil {
+		log.Printf("failed to prune challenge nonces: %v", err)
+	}
+}
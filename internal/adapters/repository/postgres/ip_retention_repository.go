@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type ipRetentionRepository struct {
+	db *sql.DB
+}
+
+func NewIPRetentionRepository(db *sql.DB) ports.IPRetentionRepository {
+	return &ipRetentionRepository{
+		db: db,
+	}
+}
+
+func (r *ipRetentionRepository) ListUnhashedVoterIPs(ctx context.Context, limit int) ([]*domain.Vote, error) {
+	query := `
+		SELECT id, host(voter_ip)
+		FROM votes
+		WHERE voter_ip IS NOT NULL AND voter_subnet_hash IS NULL
+		ORDER BY id
+		LIMIT $1
+	`
+	rows, err := r.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unhashed voter ips: %w", err)
+	}
+	defer rows.Close()
+
+	var votes []*domain.Vote
+	for rows.Next() {
+		var vote domain.Vote
+		if err := rows.Scan(&vote.ID, &vote.VoterIP); err != nil {
+			return nil, fmt.Errorf("failed to scan voter ip: %w", err)
+		}
+		votes = append(votes, &vote)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating voter ips: %w", err)
+	}
+
+	return votes, nil
+}
+
+func (r *ipRetentionRepository) SetVoterIPHashes(ctx context.Context, votes []*domain.Vote) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE votes SET voter_ip_hash = $2, voter_subnet_hash = $3 WHERE id = $1`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare voter ip hashes update: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, vote := range votes {
+		if _, err := stmt.ExecContext(ctx, vote.ID, vote.VoterIPHash, vote.VoterSubnetHash); err != nil {
+			return fmt.Errorf("failed to set voter ip hashes: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// truncatedIP is the address in column with its host part below the /24 or
+// /48 network zeroed.
+func truncatedIP(column string) string {
+	return fmt.Sprintf("host(network(set_masklen(%[1]s, CASE family(%[1]s) WHEN 4 THEN 24 ELSE 48 END)))::inet", column)
+}
+
+func (r *ipRetentionRepository) AnonymizeVoterIPs(ctx context.Context, anonymization domain.IPAnonymization, before time.Time) (int64, error) {
+	var votesQuery, flagsQuery string
+	switch anonymization {
+	case domain.IPTruncate:
+		// The hash of the whole address would identify it as well as the
+		// address itself, so only the network's is left.
+		votesQuery = `
+			UPDATE votes SET voter_ip = ` + truncatedIP("voter_ip") + `, voter_ip_hash = NULL
+			WHERE created_at < $1 AND voter_ip IS NOT NULL AND voter_subnet_hash IS NOT NULL
+			  AND (voter_ip <> ` + truncatedIP("voter_ip") + ` OR voter_ip_hash IS NOT NULL)`
+		flagsQuery = `
+			UPDATE vote_flags f SET voter_ip = ` + truncatedIP("f.voter_ip") + `
+			FROM votes v
+			WHERE v.id = f.vote_id AND v.created_at < $1 AND f.voter_ip IS NOT NULL
+			  AND f.voter_ip <> ` + truncatedIP("f.voter_ip")
+	case domain.IPHash:
+		votesQuery = `
+			UPDATE votes SET voter_ip = NULL
+			WHERE created_at < $1 AND voter_ip IS NOT NULL AND voter_subnet_hash IS NOT NULL
+		`
+		flagsQuery = `
+			UPDATE vote_flags f SET voter_ip = NULL
+			FROM votes v
+			WHERE v.id = f.vote_id AND v.created_at < $1 AND f.voter_ip IS NOT NULL
+		`
+	default:
+		return 0, fmt.Errorf("unknown ip anonymization %q", anonymization)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, votesQuery, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to anonymize voter ips: %w", err)
+	}
+	anonymized, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to anonymize voter ips: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, flagsQuery, before); err != nil {
+		return 0, fmt.Errorf("failed to anonymize vote flag ips: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return anonymized, nil
+}
@@ -0,0 +1,3 @@
+ALTER TABLE polls ADD COLUMN created_by UUID REFERENCES users(id);
+
+CREATE INDEX idx_polls_created_by ON polls(created_by);
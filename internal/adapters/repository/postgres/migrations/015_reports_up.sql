@@ -0,0 +1,21 @@
+CREATE TYPE report_status AS ENUM (
+  'open',
+  'dismissed',
+  'actioned'
+);
+
+CREATE TABLE reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    poll_id UUID NOT NULL REFERENCES polls(id),
+    reporter_id UUID NOT NULL REFERENCES users(id),
+    reason TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    status report_status NOT NULL DEFAULT 'open',
+    resolved_by UUID REFERENCES users(id),
+    resolved_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_unique_report_per_user ON reports(poll_id, reporter_id);
+
+CREATE INDEX idx_reports_open ON reports(poll_id) WHERE status = 'open';
@@ -0,0 +1,24 @@
+CREATE TYPE vote_flag_status AS ENUM (
+  'open',
+  'dismissed',
+  'invalidated'
+);
+
+CREATE TABLE vote_flags (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    vote_id UUID NOT NULL REFERENCES votes(id),
+    poll_id UUID NOT NULL REFERENCES polls(id),
+    user_id UUID NOT NULL REFERENCES users(id),
+    voter_ip INET NOT NULL,
+    rule TEXT NOT NULL,
+    details JSONB NOT NULL DEFAULT '{}',
+    status vote_flag_status NOT NULL DEFAULT 'open',
+    resolved_by UUID REFERENCES users(id),
+    resolved_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Each run of the analysis job only adds the flags it did not raise before.
+CREATE UNIQUE INDEX idx_unique_vote_flag_per_rule ON vote_flags(vote_id, rule);
+
+CREATE INDEX idx_vote_flags_status ON vote_flags(status, created_at);
@@ -0,0 +1 @@
+ALTER TABLE polls ADD COLUMN require_challenge BOOLEAN NOT NULL DEFAULT FALSE;
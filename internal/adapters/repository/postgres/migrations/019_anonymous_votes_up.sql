@@ -0,0 +1,15 @@
+ALTER TABLE polls ADD COLUMN allow_anonymous BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Anonymous votes belong to the device in the voter's signed cookie rather
+-- than to a user.
+ALTER TABLE votes ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE votes ADD COLUMN device_id UUID;
+ALTER TABLE votes ADD CONSTRAINT votes_user_or_device CHECK ((user_id IS NULL) <> (device_id IS NULL));
+
+CREATE UNIQUE INDEX idx_unique_vote_per_device ON votes(poll_id, device_id) WHERE deleted_at IS NULL;
+
+-- Backs the cap on anonymous votes per address.
+CREATE INDEX idx_anonymous_votes_by_ip ON votes(poll_id, voter_ip) WHERE device_id IS NOT NULL AND deleted_at IS NULL;
+
+ALTER TABLE vote_flags ALTER COLUMN user_id DROP NOT NULL;
+ALTER TABLE vote_flags ADD COLUMN device_id UUID;
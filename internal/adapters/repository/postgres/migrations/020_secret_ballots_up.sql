@@ -0,0 +1,21 @@
+ALTER TABLE polls ADD COLUMN secret_ballot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Who voted on a secret ballot poll. It is kept apart from the ballots and
+-- has no timestamp, so the API and exports never pair a participant with
+-- their ballot. Whoever reads the database still can, from the transaction
+-- both rows were written in.
+CREATE TABLE poll_participants (
+    poll_id UUID NOT NULL REFERENCES polls(id),
+    user_id UUID NOT NULL REFERENCES users(id),
+    PRIMARY KEY (poll_id, user_id)
+);
+
+-- A secret ballot is only identified by the hash of the receipt its voter
+-- holds, and records no voter nor address.
+ALTER TABLE votes ADD COLUMN ballot_token TEXT;
+ALTER TABLE votes ALTER COLUMN voter_ip DROP NOT NULL;
+ALTER TABLE votes DROP CONSTRAINT votes_user_or_device;
+ALTER TABLE votes ADD CONSTRAINT votes_single_voter CHECK (num_nonnulls(user_id, device_id, ballot_token) = 1);
+ALTER TABLE votes ADD CONSTRAINT votes_ballot_without_ip CHECK (ballot_token IS NULL OR voter_ip IS NULL);
+
+CREATE UNIQUE INDEX idx_unique_ballot_token ON votes(ballot_token);
@@ -0,0 +1,25 @@
+-- Every vote now comes with a receipt, so the hash secret ballots were
+-- looked up by becomes the receipt hash of all votes. A secret ballot is
+-- then simply a vote with neither user nor device.
+ALTER TABLE votes RENAME COLUMN ballot_token TO receipt_hash;
+ALTER INDEX idx_unique_ballot_token RENAME TO idx_unique_receipt_hash;
+
+ALTER TABLE votes DROP CONSTRAINT votes_single_voter;
+ALTER TABLE votes DROP CONSTRAINT votes_ballot_without_ip;
+ALTER TABLE votes ADD CONSTRAINT votes_single_voter CHECK (num_nonnulls(user_id, device_id) <= 1);
+ALTER TABLE votes ADD CONSTRAINT votes_ballot_without_ip CHECK (num_nonnulls(user_id, device_id) = 1 OR voter_ip IS NULL);
+
+-- Votes cast before receipts existed get one that nobody holds, so they
+-- still take part in the tally tree.
+ALTER TABLE votes ALTER COLUMN receipt_hash SET DEFAULT encode(sha256(uuid_send(gen_random_uuid())), 'hex');
+UPDATE votes SET receipt_hash = DEFAULT WHERE receipt_hash IS NULL;
+ALTER TABLE votes ALTER COLUMN receipt_hash SET NOT NULL;
+
+-- The Merkle root over the counted votes of a poll, as of its last
+-- ProcessVotes run.
+CREATE TABLE poll_tallies (
+    poll_id UUID PRIMARY KEY REFERENCES polls(id),
+    merkle_root TEXT NOT NULL,
+    ballots BIGINT NOT NULL,
+    computed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
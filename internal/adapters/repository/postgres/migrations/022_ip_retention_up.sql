@@ -0,0 +1,16 @@
+-- Abuse detection matches votes by keyed hashes of their address and of its
+-- /24 or /48 network, so it keeps working once the IP retention job has
+-- truncated or removed the address itself. The job also hashes the votes
+-- cast before these columns existed.
+ALTER TABLE votes ADD COLUMN voter_ip_hash TEXT;
+ALTER TABLE votes ADD COLUMN voter_subnet_hash TEXT;
+
+ALTER TABLE votes DROP CONSTRAINT votes_ballot_without_ip;
+ALTER TABLE votes ADD CONSTRAINT votes_ballot_without_ip CHECK (num_nonnulls(user_id, device_id) = 1 OR num_nonnulls(voter_ip, voter_ip_hash, voter_subnet_hash) = 0);
+
+DROP INDEX idx_anonymous_votes_by_ip;
+CREATE INDEX idx_anonymous_votes_by_ip ON votes(poll_id, voter_ip_hash) WHERE device_id IS NOT NULL AND deleted_at IS NULL;
+CREATE INDEX idx_votes_unhashed_ip ON votes(id) WHERE voter_ip IS NOT NULL AND voter_ip_hash IS NULL;
+CREATE INDEX idx_votes_raw_ip_created_at ON votes(created_at) WHERE voter_ip IS NOT NULL;
+
+ALTER TABLE vote_flags ALTER COLUMN voter_ip DROP NOT NULL;
@@ -0,0 +1,3 @@
+-- Emails are looked up case-insensitively, since accounts created before
+-- emails were normalized may have kept the provider's capitalization.
+CREATE INDEX idx_users_lower_email ON users(lower(email));
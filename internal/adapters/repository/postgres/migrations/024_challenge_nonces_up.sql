@@ -0,0 +1,9 @@
+-- Spent proof of work nonces only matter until their token expires, and
+-- losing them on a crash merely lets a few tokens be replayed until then, so
+-- the table skips the WAL.
+CREATE UNLOGGED TABLE challenge_nonces (
+    nonce TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_challenge_nonces_expires_at ON challenge_nonces(expires_at);
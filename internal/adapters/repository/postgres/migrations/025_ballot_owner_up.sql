@@ -0,0 +1,5 @@
+-- The owner hash of a secret ballot takes both its receipt and its voter's
+-- id to compute, so only the voter holding the receipt may read, change or
+-- retract it. Ballots cast before it have none and can no longer be changed.
+ALTER TABLE votes ADD COLUMN owner_hash TEXT;
+ALTER TABLE votes ADD CONSTRAINT votes_owner_only_on_ballots CHECK (owner_hash IS NULL OR num_nonnulls(user_id, device_id) = 0);
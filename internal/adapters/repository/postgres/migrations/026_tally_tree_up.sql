@@ -0,0 +1,52 @@
+-- The tally tree of each poll, kept up to date by ProcessVotes so proofs are
+-- read from it rather than rebuilt from the votes. A leaf stands for a
+-- counted vote at its position in the tree.
+CREATE TABLE poll_tally_leaves (
+    poll_id UUID NOT NULL REFERENCES polls(id),
+    receipt_hash TEXT NOT NULL,
+    option_id UUID NOT NULL,
+    position INT NOT NULL,
+    PRIMARY KEY (poll_id, receipt_hash),
+    UNIQUE (poll_id, position)
+);
+
+-- Level 0 holds the hashes of the leaves, and each level above pairs up the
+-- nodes of the one below, up to the root.
+CREATE TABLE poll_tally_nodes (
+    poll_id UUID NOT NULL REFERENCES polls(id),
+    level INT NOT NULL,
+    position INT NOT NULL,
+    hash BYTEA NOT NULL,
+    PRIMARY KEY (poll_id, level, position)
+);
+
+-- Published tallies ordered all their leaves by receipt hash. The stored
+-- trees start from that order, so their roots still hold.
+INSERT INTO poll_tally_leaves (poll_id, receipt_hash, option_id, position)
+SELECT poll_id, receipt_hash, option_id, (ROW_NUMBER() OVER (PARTITION BY poll_id ORDER BY receipt_hash) - 1)::INT
+FROM votes
+WHERE status = 'valid';
+
+INSERT INTO poll_tally_nodes (poll_id, level, position, hash)
+SELECT poll_id, 0, position, sha256('\x00'::BYTEA || decode(receipt_hash, 'hex') || uuid_send(option_id))
+FROM poll_tally_leaves;
+
+DO $$
+DECLARE
+    lvl INT := 0;
+BEGIN
+    LOOP
+        INSERT INTO poll_tally_nodes (poll_id, level, position, hash)
+        SELECT poll_id, lvl + 1, position / 2,
+               CASE WHEN COUNT(*) = 2
+                    THEN sha256('\x01'::BYTEA || (ARRAY_AGG(hash ORDER BY position))[1] || (ARRAY_AGG(hash ORDER BY position))[2])
+                    ELSE (ARRAY_AGG(hash))[1]
+               END
+        FROM poll_tally_nodes
+        WHERE level = lvl
+          AND poll_id IN (SELECT poll_id FROM poll_tally_nodes WHERE level = lvl GROUP BY poll_id HAVING COUNT(*) > 1)
+        GROUP BY poll_id, position / 2;
+        EXIT WHEN NOT FOUND;
+        lvl := lvl + 1;
+    END LOOP;
+END $$;
@@ -0,0 +1,5 @@
+-- Truncating an address now drops its hash too, leaving only the network's,
+-- so the votes yet to be hashed are those without a network hash. Addresses
+-- truncated earlier lose their hash on the next IP retention run.
+DROP INDEX idx_votes_unhashed_ip;
+CREATE INDEX idx_votes_unhashed_ip ON votes(id) WHERE voter_ip IS NOT NULL AND voter_subnet_hash IS NULL;
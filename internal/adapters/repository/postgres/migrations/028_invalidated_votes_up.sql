@@ -0,0 +1,7 @@
+-- Invalidating a vote no longer retracts it: the row stays without
+-- deleted_at, so its voter still counts as having voted on the poll and
+-- cannot vote again. A counted vote is 'invalidated' until ProcessVotes
+-- takes it out of the results and marks it 'invalid'. Votes invalidated
+-- before this stay retracted, as they cannot be told apart from the votes
+-- their voters retracted.
+ALTER TYPE vote_status ADD VALUE 'invalidated';
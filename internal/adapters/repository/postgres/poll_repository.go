@@ -21,55 +21,68 @@ func NewPollRepository(db *sql.DB) ports.PollRepository {
 }
 
 func (r *pollRepository) Save(ctx context.Context, poll *domain.Poll) error {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-	defer tx.Rollback()
+	return r.SaveBatch(ctx, []*domain.Poll{poll})
+}
 
-	queryPoll := `
-		INSERT INTO polls (id, title, description, expires_at)
-		VALUES ($1, $2, $3, $4)
-	`
-	_, err = tx.ExecContext(ctx, queryPoll, poll.ID, poll.Title, poll.Description, poll.ExpiresAt)
-	if err != nil {
-		return fmt.Errorf("failed to insert poll: %w", err)
-	}
+// SaveBatch saves the polls in one transaction, or in the one
+// Transactor.WithinTx started for ctx, so several batches can be saved
+// together.
+func (r *pollRepository) SaveBatch(ctx context.Context, polls []*domain.Poll) error {
+	return NewTransactor(r.db).WithinTx(ctx, func(ctx context.Context) error {
+		tx := ctx.Value(txKey{}).(*sql.Tx)
 
-	queryOption := `
-		INSERT INTO poll_options (id, poll_id, text)
-		VALUES ($1, $2, $3)
-	`
-	stmt, err := tx.PrepareContext(ctx, queryOption)
-	if err != nil {
-		return fmt.Errorf("failed to prepare option statement: %w", err)
-	}
-	defer stmt.Close()
+		queryPoll := `
+			INSERT INTO polls (id, title, description, require_challenge, allow_anonymous, secret_ballot, created_by, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`
+		pollStmt, err := tx.PrepareContext(ctx, queryPoll)
+		if err != nil {
+			return fmt.Errorf("failed to prepare poll statement: %w", err)
+		}
+		defer pollStmt.Close()
 
-	for _, opt := range poll.Options {
-		_, err = stmt.ExecContext(ctx, opt.ID, opt.PollID, opt.Text)
+		queryOption := `
+			INSERT INTO poll_options (id, poll_id, text)
+			VALUES ($1, $2, $3)
+		`
+		optionStmt, err := tx.PrepareContext(ctx, queryOption)
 		if err != nil {
-			return fmt.Errorf("failed to insert option: %w", err)
+			return fmt.Errorf("failed to prepare option statement: %w", err)
 		}
-	}
+		defer optionStmt.Close()
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
+		for _, poll := range polls {
+			_, err = pollStmt.ExecContext(ctx, poll.ID, poll.Title, poll.Description, poll.RequireChallenge, poll.AllowAnonymous, poll.SecretBallot, poll.CreatedBy, poll.ExpiresAt)
+			if err != nil {
+				return fmt.Errorf("failed to insert poll: %w", err)
+			}
 
-	return nil
+			for _, opt := range poll.Options {
+				_, err = optionStmt.ExecContext(ctx, opt.ID, opt.PollID, opt.Text)
+				if err != nil {
+					return fmt.Errorf("failed to insert option: %w", err)
+				}
+			}
+		}
+		return nil
+	})
 }
 
+// belowReportThreshold filters List and Search, which take the threshold as
+// their third argument.
+const belowReportThreshold = `
+		AND ($3 = 0 OR (SELECT COUNT(*) FROM reports r WHERE r.poll_id = p.id AND r.status = 'open') < $3)`
+
 func (r *pollRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.Poll, error) {
 	queryPoll := `
-		SELECT id, title, description, created_at, expires_at
+		SELECT id, title, description, require_challenge, allow_anonymous, secret_ballot, created_by, created_at, expires_at
 		FROM polls
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
 	var poll domain.Poll
 	err := r.db.QueryRowContext(ctx, queryPoll, id).Scan(
-		&poll.ID, &poll.Title, &poll.Description, &poll.CreatedAt, &poll.ExpiresAt,
+		&poll.ID, &poll.Title, &poll.Description, &poll.RequireChallenge, &poll.AllowAnonymous, &poll.SecretBallot, &poll.CreatedBy, &poll.CreatedAt, &poll.ExpiresAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -89,7 +102,7 @@ func (r *pollRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.Pol
 
 func (r *pollRepository) GetAll(ctx context.Context) ([]*domain.Poll, error) {
 	query := `
-		SELECT id, title, description, created_at, expires_at
+		SELECT id, title, description, require_challenge, allow_anonymous, secret_ballot, created_by, created_at, expires_at
 		FROM polls
 		WHERE deleted_at IS NULL
 	`
@@ -102,17 +115,17 @@ func (r *pollRepository) GetAll(ctx context.Context) ([]*domain.Poll, error) {
 	return r.scanPolls(ctx, rows)
 }
 
-func (r *pollRepository) List(ctx context.Context, limit, offset int) ([]*domain.Poll, error) {
+func (r *pollRepository) List(ctx context.Context, limit, offset, reportThreshold int) ([]*domain.Poll, error) {
 	query := `
-		SELECT p.id, p.title, p.description, p.created_at, p.expires_at
+		SELECT p.id, p.title, p.description, p.require_challenge, p.allow_anonymous, p.secret_ballot, p.created_by, p.created_at, p.expires_at
 		FROM polls p
 		LEFT JOIN poll_results pr ON p.id = pr.poll_id
-		WHERE p.deleted_at IS NULL
+		WHERE p.deleted_at IS NULL` + belowReportThreshold + `
 		GROUP BY p.id
 		ORDER BY COALESCE(SUM(pr.vote_count), 0) DESC, p.created_at DESC
 		LIMIT $1 OFFSET $2
 	`
-	rows, err := r.db.QueryContext(ctx, query, limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, limit, offset, reportThreshold)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list polls: %w", err)
 	}
@@ -121,17 +134,17 @@ func (r *pollRepository) List(ctx context.Context, limit, offset int) ([]*domain
 	return r.scanPolls(ctx, rows)
 }
 
-func (r *pollRepository) Search(ctx context.Context, limit, offset int, q string) ([]*domain.Poll, error) {
+func (r *pollRepository) Search(ctx context.Context, limit, offset, reportThreshold int, q string) ([]*domain.Poll, error) {
 	query := `
-		SELECT p.id, p.title, p.description, p.created_at, p.expires_at
+		SELECT p.id, p.title, p.description, p.require_challenge, p.allow_anonymous, p.secret_ballot, p.created_by, p.created_at, p.expires_at
 		FROM polls p
 		LEFT JOIN poll_results pr ON p.id = pr.poll_id
-		WHERE p.deleted_at IS NULL AND p.title ILIKE $1
+		WHERE p.deleted_at IS NULL AND p.title ILIKE $4` + belowReportThreshold + `
 		GROUP BY p.id
 		ORDER BY COALESCE(SUM(pr.vote_count), 0) DESC, p.created_at DESC
-		LIMIT $2 OFFSET $3
+		LIMIT $1 OFFSET $2
 	`
-	rows, err := r.db.QueryContext(ctx, query, "%"+q+"%", limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, limit, offset, reportThreshold, "%"+q+"%")
 	if err != nil {
 		return nil, fmt.Errorf("failed to search polls: %w", err)
 	}
@@ -140,11 +153,129 @@ func (r *pollRepository) Search(ctx context.Context, limit, offset int, q string
 	return r.scanPolls(ctx, rows)
 }
 
+func (r *pollRepository) ListAnalyticsByCreator(ctx context.Context, creatorID uuid.UUID) ([]*domain.PollAnalytics, error) {
+	query := `
+		SELECT
+			p.id,
+			p.title,
+			CASE
+				WHEN p.deleted_at IS NOT NULL THEN 'deleted'
+				WHEN p.expires_at IS NOT NULL AND p.expires_at <= NOW() THEN 'closed'
+				ELSE 'open'
+			END,
+			COALESCE(pr.total_votes, 0),
+			COALESCE(v.unique_voters, 0) + COALESCE(pp.participants, 0),
+			COALESCE(v.pending_votes, 0),
+			v.last_vote_at,
+			p.created_at,
+			p.expires_at
+		FROM polls p
+		LEFT JOIN (
+			SELECT poll_id, SUM(vote_count) AS total_votes
+			FROM poll_results
+			GROUP BY poll_id
+		) pr ON pr.poll_id = p.id
+		LEFT JOIN (
+			SELECT
+				poll_id,
+				COUNT(DISTINCT COALESCE(user_id, device_id)) FILTER (WHERE deleted_at IS NULL AND status IN ('pending', 'valid')) AS unique_voters,
+				COUNT(*) FILTER (WHERE status = 'pending' AND deleted_at IS NULL) AS pending_votes,
+				MAX(created_at) AS last_vote_at
+			FROM votes
+			GROUP BY poll_id
+		) v ON v.poll_id = p.id
+		LEFT JOIN (
+			SELECT poll_id, COUNT(*) AS participants
+			FROM poll_participants
+			GROUP BY poll_id
+		) pp ON pp.poll_id = p.id
+		WHERE p.created_by = $1
+		ORDER BY p.created_at DESC
+	`
+	rows, err := r.db.QueryContext(ctx, query, creatorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list poll analytics: %w", err)
+	}
+	defer rows.Close()
+
+	analytics := []*domain.PollAnalytics{}
+	for rows.Next() {
+		var a domain.PollAnalytics
+		if err := rows.Scan(
+			&a.PollID, &a.Title, &a.Status, &a.TotalVotes, &a.UniqueVoters,
+			&a.PendingVotes, &a.LastVoteAt, &a.CreatedAt, &a.ExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan poll analytics: %w", err)
+		}
+		analytics = append(analytics, &a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating poll analytics: %w", err)
+	}
+	return analytics, nil
+}
+
+func (r *pollRepository) ListAll(ctx context.Context, limit, offset int, q string) ([]*domain.Poll, error) {
+	query := `
+		SELECT id, title, description, require_challenge, allow_anonymous, secret_ballot, created_by, created_at, expires_at, deleted_at
+		FROM polls
+		WHERE $1 = '' OR title ILIKE '%' || $1 || '%'
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.db.QueryContext(ctx, query, q, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list all polls: %w", err)
+	}
+	defer rows.Close()
+
+	polls := []*domain.Poll{}
+	for rows.Next() {
+		var poll domain.Poll
+		if err := rows.Scan(&poll.ID, &poll.Title, &poll.Description, &poll.RequireChallenge, &poll.AllowAnonymous, &poll.SecretBallot, &poll.CreatedBy, &poll.CreatedAt, &poll.ExpiresAt, &poll.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan poll: %w", err)
+		}
+
+		options, err := r.fetchOptions(ctx, poll.ID)
+		if err != nil {
+			return nil, err
+		}
+		poll.Options = options
+
+		polls = append(polls, &poll)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating polls: %w", err)
+	}
+	return polls, nil
+}
+
+func (r *pollRepository) SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error {
+	// Hiding again keeps the original deleted_at.
+	query := `
+		UPDATE polls
+		SET deleted_at = CASE WHEN $2 THEN COALESCE(deleted_at, NOW()) ELSE NULL END
+		WHERE id = $1
+	`
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, id, hidden)
+	if err != nil {
+		return fmt.Errorf("failed to update poll: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update poll: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrPollNotFound
+	}
+	return nil
+}
+
 func (r *pollRepository) scanPolls(ctx context.Context, rows *sql.Rows) ([]*domain.Poll, error) {
 	var polls []*domain.Poll
 	for rows.Next() {
 		var poll domain.Poll
-		if err := rows.Scan(&poll.ID, &poll.Title, &poll.Description, &poll.CreatedAt, &poll.ExpiresAt); err != nil {
+		if err := rows.Scan(&poll.ID, &poll.Title, &poll.Description, &poll.RequireChallenge, &poll.AllowAnonymous, &poll.SecretBallot, &poll.CreatedBy, &poll.CreatedAt, &poll.ExpiresAt); err != nil {
 			return nil, fmt.Errorf("failed to scan poll: %w", err)
 		}
 
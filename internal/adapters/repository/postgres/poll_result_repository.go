@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
@@ -71,6 +72,52 @@ func (r *pollResultRepository) GetPollOptionStats(ctx context.Context, pollID uu
 	return result, nil
 }
 
+func (r *pollResultRepository) GetPollTimeline(ctx context.Context, pollID uuid.UUID, bucket domain.TimelineBucket) ([]domain.PollTimelinePoint, error) {
+	// Every vote counts +1 in the bucket it was cast and -1 in the bucket it
+	// was retracted, so the running sum is the option's tally at each bucket.
+	// Votes an admin invalidated are left out altogether.
+	query := `
+		WITH events AS (
+			SELECT option_id, date_trunc($2, created_at) AS bucket_start, 1 AS delta
+			FROM votes
+			WHERE poll_id = $1 AND (deleted_at IS NOT NULL OR status IN ('pending', 'valid'))
+			UNION ALL
+			SELECT option_id, date_trunc($2, deleted_at) AS bucket_start, -1 AS delta
+			FROM votes
+			WHERE poll_id = $1 AND deleted_at IS NOT NULL
+		), per_bucket AS (
+			SELECT bucket_start, option_id, SUM(delta) AS votes
+			FROM events
+			GROUP BY bucket_start, option_id
+		)
+		SELECT bucket_start, option_id, votes,
+		       SUM(votes) OVER (PARTITION BY option_id ORDER BY bucket_start) AS total
+		FROM per_bucket
+		ORDER BY bucket_start, option_id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pollID, string(bucket))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch poll timeline: %w", err)
+	}
+	defer rows.Close()
+
+	points := []domain.PollTimelinePoint{}
+	for rows.Next() {
+		var p domain.PollTimelinePoint
+		if err := rows.Scan(&p.BucketStart, &p.OptionID, &p.Votes, &p.Total); err != nil {
+			return nil, fmt.Errorf("failed to scan timeline point: %w", err)
+		}
+		points = append(points, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating timeline: %w", err)
+	}
+
+	return points, nil
+}
+
 func (r *pollResultRepository) ProcessVotes(ctx context.Context, pollID uuid.UUID) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -78,22 +125,31 @@ func (r *pollResultRepository) ProcessVotes(ctx context.Context, pollID uuid.UUI
 	}
 	defer tx.Rollback()
 
+	// The counted votes only change here, so the tally published along
+	// stays current until the next run.
+	tree, err := openTallyTree(ctx, tx, pollID)
+	if err != nil {
+		return err
+	}
+
 	queryInc := `
 		WITH processed_votes AS (
 			UPDATE votes
 			SET status = 'valid'
 			WHERE poll_id = $1 AND status = 'pending' AND deleted_at IS NULL
-			RETURNING option_id
+			RETURNING option_id, receipt_hash
+		), counted AS (
+			INSERT INTO poll_results (poll_id, option_id, vote_count, last_updated_at)
+			SELECT $1, option_id, COUNT(*), NOW()
+			FROM processed_votes
+			GROUP BY option_id
+			ON CONFLICT (poll_id, option_id) DO UPDATE
+			SET vote_count = poll_results.vote_count + EXCLUDED.vote_count,
+			    last_updated_at = NOW()
 		)
-		INSERT INTO poll_results (poll_id, option_id, vote_count, last_updated_at)
-		SELECT $1, option_id, COUNT(*), NOW()
-		FROM processed_votes
-		GROUP BY option_id
-		ON CONFLICT (poll_id, option_id) DO UPDATE
-		SET vote_count = poll_results.vote_count + EXCLUDED.vote_count,
-		    last_updated_at = NOW();
+		SELECT receipt_hash, option_id FROM processed_votes ORDER BY receipt_hash;
 	`
-	_, err = tx.ExecContext(ctx, queryInc, pollID)
+	added, err := scanCountedVotes(tx.QueryContext(ctx, queryInc, pollID))
 	if err != nil {
 		return fmt.Errorf("failed to process increments for poll %s: %w", pollID, err)
 	}
@@ -102,22 +158,50 @@ func (r *pollResultRepository) ProcessVotes(ctx context.Context, pollID uuid.UUI
 		WITH deleted_votes AS (
 			UPDATE votes
 			SET status = 'invalid'
-			WHERE poll_id = $1 AND status = 'valid' AND deleted_at IS NOT NULL
-			RETURNING option_id
+			WHERE poll_id = $1 AND (status = 'valid' AND deleted_at IS NOT NULL OR status = 'invalidated')
+			RETURNING option_id, receipt_hash
+		), uncounted AS (
+			INSERT INTO poll_results (poll_id, option_id, vote_count, last_updated_at)
+			SELECT $1, option_id, -COUNT(*), NOW()
+			FROM deleted_votes
+			GROUP BY option_id
+			ON CONFLICT (poll_id, option_id) DO UPDATE
+			SET vote_count = poll_results.vote_count + EXCLUDED.vote_count,
+			    last_updated_at = NOW()
 		)
-		INSERT INTO poll_results (poll_id, option_id, vote_count, last_updated_at)
-		SELECT $1, option_id, -COUNT(*), NOW()
-		FROM deleted_votes
-		GROUP BY option_id
-		ON CONFLICT (poll_id, option_id) DO UPDATE
-		SET vote_count = poll_results.vote_count + EXCLUDED.vote_count,
-		    last_updated_at = NOW();
+		SELECT receipt_hash, option_id FROM deleted_votes;
 	`
-	_, err = tx.ExecContext(ctx, queryDec, pollID)
+	removed, err := scanCountedVotes(tx.QueryContext(ctx, queryDec, pollID))
 	if err != nil {
 		return fmt.Errorf("failed to process decrements for poll %s: %w", pollID, err)
 	}
 
+	for _, v := range removed {
+		if err := tree.remove(ctx, v.receiptHash); err != nil {
+			return err
+		}
+	}
+	if err := tree.append(ctx, added); err != nil {
+		return err
+	}
+	root, err := tree.root(ctx)
+	if err != nil {
+		return err
+	}
+
+	queryTally := `
+		INSERT INTO poll_tallies (poll_id, merkle_root, ballots, computed_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (poll_id) DO UPDATE
+		SET merkle_root = EXCLUDED.merkle_root,
+		    ballots = EXCLUDED.ballots,
+		    computed_at = EXCLUDED.computed_at;
+	`
+	_, err = tx.ExecContext(ctx, queryTally, pollID, root, tree.size)
+	if err != nil {
+		return fmt.Errorf("failed to publish tally for poll %s: %w", pollID, err)
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
@@ -125,12 +209,89 @@ func (r *pollResultRepository) ProcessVotes(ctx context.Context, pollID uuid.UUI
 	return nil
 }
 
+func (r *pollResultRepository) GetTally(ctx context.Context, pollID uuid.UUID) (*domain.Tally, error) {
+	return getTally(ctx, r.db, pollID)
+}
+
+// GetTallyProof reads the proof off the stored tree in a single statement,
+// so it matches the tally it is returned with even while ProcessVotes runs.
+func (r *pollResultRepository) GetTallyProof(ctx context.Context, pollID uuid.UUID, receiptHash string) (*domain.TallyProof, error) {
+	query := `
+		SELECT t.merkle_root, t.ballots, l.position, l.option_id,
+		       ARRAY(
+		           SELECT encode(n.hash, 'hex')
+		           FROM generate_series(0, 31) AS lv(level)
+		           JOIN poll_tally_nodes n
+		             ON n.poll_id = t.poll_id AND n.level = lv.level
+		            AND n.position = (l.position >> lv.level) # 1
+		           ORDER BY lv.level
+		       )
+		FROM poll_tallies t
+		LEFT JOIN poll_tally_leaves l ON l.poll_id = t.poll_id AND l.receipt_hash = $2
+		WHERE t.poll_id = $1
+	`
+	proof := domain.TallyProof{PollID: pollID}
+	var position sql.NullInt64
+	var optionID uuid.NullUUID
+	err := r.db.QueryRowContext(ctx, query, pollID, receiptHash).Scan(
+		&proof.MerkleRoot, &proof.Ballots, &position, &optionID, pq.Array(&proof.Path),
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrTallyNotPublished
+		}
+		return nil, fmt.Errorf("failed to get tally proof: %w", err)
+	}
+	if !position.Valid {
+		return nil, domain.ErrReceiptNotCounted
+	}
+
+	proof.LeafIndex = int(position.Int64)
+	proof.OptionID = optionID.UUID
+	return &proof, nil
+}
+
+func getTally(ctx context.Context, q queryRower, pollID uuid.UUID) (*domain.Tally, error) {
+	query := `
+		SELECT poll_id, merkle_root, ballots, computed_at
+		FROM poll_tallies
+		WHERE poll_id = $1
+	`
+	var tally domain.Tally
+	err := q.QueryRowContext(ctx, query, pollID).Scan(&tally.PollID, &tally.MerkleRoot, &tally.Ballots, &tally.ComputedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, domain.ErrTallyNotPublished
+		}
+		return nil, fmt.Errorf("failed to get tally: %w", err)
+	}
+	return &tally, nil
+}
+
+func scanCountedVotes(rows *sql.Rows, err error) ([]countedVote, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var votes []countedVote
+	for rows.Next() {
+		var v countedVote
+		if err := rows.Scan(&v.receiptHash, &v.optionID); err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
 func (r *pollResultRepository) GetPollsWithUnprocessedVotes(ctx context.Context) ([]uuid.UUID, error) {
 	query := `
 		SELECT DISTINCT poll_id
 		FROM votes
 		WHERE (status = 'pending' AND deleted_at IS NULL)
 		   OR (status = 'valid' AND deleted_at IS NOT NULL)
+		   OR status = 'invalidated'
 	`
 	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
@@ -0,0 +1,73 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+// staleBucketAge is how long a bucket may go unused before it is pruned.
+// Limits refill within a day, so by then the bucket is full and dropping it
+// changes nothing.
+const staleBucketAge = 24 * time.Hour
+
+// RateLimitStore shares token buckets between every replica using the same
+// database.
+type RateLimitStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func NewRateLimitStore(db *sql.DB) ports.RateLimitStore {
+	return &RateLimitStore{db: db, lastPrune: time.Now()}
+}
+
+// Take refills and takes from the bucket in a single upsert, so concurrent
+// requests on different replicas cannot both spend the last token.
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit domain.RateLimit) (domain.RateLimitDecision, error) {
+	s.pruneStale(ctx)
+
+	query := `
+		INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at)
+		VALUES ($1, $2::float8 - 1, TRUE, NOW())
+		ON CONFLICT (key) DO UPDATE SET (tokens, allowed, updated_at) = (
+			SELECT CASE WHEN refill.tokens >= 1 THEN refill.tokens - 1 ELSE refill.tokens END, refill.tokens >= 1, NOW()
+			FROM (
+				SELECT LEAST($2::float8, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.updated_at)::float8 * $3::float8) AS tokens
+			) AS refill
+		)
+		RETURNING tokens, allowed
+	`
+	var tokens float64
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, query, key, float64(limit.Requests), limit.RefillRate()).Scan(&tokens, &allowed)
+	if err != nil {
+		return domain.RateLimitDecision{}, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+
+	return domain.NewRateLimitDecision(limit, tokens, allowed), nil
+}
+
+// pruneStale deletes unused buckets at most once per hour per replica.
+func (s *RateLimitStore) pruneStale(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastPrune) < time.Hour {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at < $1", time.Now().Add(-staleBucketAge))
+	if err != nil {
+		log.Printf("failed to prune rate limit buckets: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type reportRepository struct {
+	db *sql.DB
+}
+
+func NewReportRepository(db *sql.DB) ports.ReportRepository {
+	return &reportRepository{db: db}
+}
+
+func (r *reportRepository) Create(ctx context.Context, report *domain.Report) error {
+	query := `
+		INSERT INTO reports (poll_id, reporter_id, reason, details)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, status, created_at
+	`
+	err := r.db.QueryRowContext(ctx, query, report.PollID, report.ReporterID, report.Reason, report.Details).Scan(&report.ID, &report.Status, &report.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return domain.ErrAlreadyReported
+		}
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	return nil
+}
+
+func (r *reportRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.Report, error) {
+	query := `
+		SELECT id, poll_id, reporter_id, reason, details, status, resolved_by, resolved_at, created_at
+		FROM reports
+		WHERE id = $1
+	`
+	report, err := scanReport(conn(ctx, r.db).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get report: %w", err)
+	}
+	return report, nil
+}
+
+func (r *reportRepository) List(ctx context.Context, status domain.ReportStatus, limit, offset int) ([]*domain.Report, error) {
+	query := `
+		SELECT id, poll_id, reporter_id, reason, details, status, resolved_by, resolved_at, created_at
+		FROM reports
+		WHERE status = $1
+		ORDER BY created_at
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.db.QueryContext(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reports: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []*domain.Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan report: %w", err)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+func (r *reportRepository) Resolve(ctx context.Context, id uuid.UUID, status domain.ReportStatus, resolvedBy uuid.UUID) error {
+	query := `
+		UPDATE reports
+		SET status = $2, resolved_by = $3, resolved_at = NOW()
+		WHERE id = $1 AND status = 'open'
+	`
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, id, status, resolvedBy)
+	if err != nil {
+		return fmt.Errorf("failed to resolve report: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to resolve report: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrReportResolved
+	}
+	return nil
+}
+
+func (r *reportRepository) ResolveOpenForPoll(ctx context.Context, pollID uuid.UUID, status domain.ReportStatus, resolvedBy uuid.UUID) (int64, error) {
+	query := `
+		UPDATE reports
+		SET status = $2, resolved_by = $3, resolved_at = NOW()
+		WHERE poll_id = $1 AND status = 'open'
+	`
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, pollID, status, resolvedBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve reports: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func scanReport(row interface{ Scan(dest ...any) error }) (*domain.Report, error) {
+	report := &domain.Report{}
+	err := row.Scan(
+		&report.ID,
+		&report.PollID,
+		&report.ReporterID,
+		&report.Reason,
+		&report.Details,
+		&report.Status,
+		&report.ResolvedBy,
+		&report.ResolvedAt,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+type countedVote struct {
+	receiptHash string
+	optionID    uuid.UUID
+}
+
+// tallyTree updates the stored tally tree of a poll within a ProcessVotes
+// run. Only the leaves that changed and the nodes above them are hashed
+// again, so a run costs in proportion to the votes it counts or removes,
+// not to the votes the poll holds.
+type tallyTree struct {
+	tx     *sql.Tx
+	pollID uuid.UUID
+	size   int
+	// changed holds the leaf positions whose hash changed in this run.
+	changed map[int]bool
+}
+
+// openTallyTree locks the tree of the poll until the transaction ends, so
+// concurrent runs on it cannot both place leaves at the same position.
+func openTallyTree(ctx context.Context, tx *sql.Tx, pollID uuid.UUID) (*tallyTree, error) {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('tally:' || $1::text, 0))`, pollID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock tally of poll %s: %w", pollID, err)
+	}
+
+	t := &tallyTree{tx: tx, pollID: pollID, changed: make(map[int]bool)}
+	err = tx.QueryRowContext(ctx, `SELECT ballots FROM poll_tallies WHERE poll_id = $1`, pollID).Scan(&t.size)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get tally of poll %s: %w", pollID, err)
+	}
+	return t, nil
+}
+
+// remove drops the leaf of a vote that is no longer counted and moves the
+// last leaf into its place.
+func (t *tallyTree) remove(ctx context.Context, receiptHash string) error {
+	var position int
+	err := t.tx.QueryRowContext(ctx, `
+		DELETE FROM poll_tally_leaves WHERE poll_id = $1 AND receipt_hash = $2 RETURNING position
+	`, t.pollID, receiptHash).Scan(&position)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove tally leaf: %w", err)
+	}
+
+	last := t.size - 1
+	if position != last {
+		_, err = t.tx.ExecContext(ctx, `
+			UPDATE poll_tally_leaves SET position = $2 WHERE poll_id = $1 AND position = $3
+		`, t.pollID, position, last)
+		if err != nil {
+			return fmt.Errorf("failed to move tally leaf: %w", err)
+		}
+		_, err = t.tx.ExecContext(ctx, `
+			UPDATE poll_tally_nodes SET hash = moved.hash
+			FROM poll_tally_nodes moved
+			WHERE poll_tally_nodes.poll_id = $1 AND poll_tally_nodes.level = 0 AND poll_tally_nodes.position = $2
+			  AND moved.poll_id = $1 AND moved.level = 0 AND moved.position = $3
+		`, t.pollID, position, last)
+		if err != nil {
+			return fmt.Errorf("failed to move tally leaf: %w", err)
+		}
+		t.changed[position] = true
+	}
+
+	_, err = t.tx.ExecContext(ctx, `
+		DELETE FROM poll_tally_nodes WHERE poll_id = $1 AND level = 0 AND position = $2
+	`, t.pollID, last)
+	if err != nil {
+		return fmt.Errorf("failed to remove tally leaf: %w", err)
+	}
+	delete(t.changed, last)
+	t.size--
+	return nil
+}
+
+// append adds the leaves of newly counted votes after the last one.
+func (t *tallyTree) append(ctx context.Context, votes []countedVote) error {
+	if len(votes) == 0 {
+		return nil
+	}
+
+	leafStmt, err := t.tx.PrepareContext(ctx, `
+		INSERT INTO poll_tally_leaves (poll_id, receipt_hash, option_id, position) VALUES ($1, $2, $3, $4)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare tally leaf statement: %w", err)
+	}
+	defer leafStmt.Close()
+
+	nodeStmt, err := t.tx.PrepareContext(ctx, `
+		INSERT INTO poll_tally_nodes (poll_id, level, position, hash) VALUES ($1, 0, $2, $3)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare tally node statement: %w", err)
+	}
+	defer nodeStmt.Close()
+
+	for _, v := range votes {
+		leaf, err := domain.TallyLeaf(v.receiptHash, v.optionID)
+		if err != nil {
+			return fmt.Errorf("invalid receipt hash on poll %s: %w", t.pollID, err)
+		}
+		if _, err := leafStmt.ExecContext(ctx, t.pollID, v.receiptHash, v.optionID, t.size); err != nil {
+			return fmt.Errorf("failed to add tally leaf: %w", err)
+		}
+		if _, err := nodeStmt.ExecContext(ctx, t.pollID, t.size, leaf); err != nil {
+			return fmt.Errorf("failed to add tally leaf: %w", err)
+		}
+		t.changed[t.size] = true
+		t.size++
+	}
+	return nil
+}
+
+// root hashes the parents of the changed nodes level by level, drops the
+// nodes the tree no longer reaches and returns the new root in hex.
+func (t *tallyTree) root(ctx context.Context) (string, error) {
+	changed := make([]int, 0, len(t.changed))
+	for position := range t.changed {
+		changed = append(changed, position)
+	}
+
+	level, width := 0, t.size
+	for width > 1 {
+		if err := t.truncate(ctx, level, width); err != nil {
+			return "", err
+		}
+
+		// The last parent is always hashed again, since removals may have
+		// taken away its right child.
+		parents := []int{(width - 1) / 2}
+		for _, position := range changed {
+			if position < width {
+				parents = append(parents, position/2)
+			}
+		}
+		slices.Sort(parents)
+		parents = slices.Compact(parents)
+
+		children, err := t.nodes(ctx, level, parents)
+		if err != nil {
+			return "", err
+		}
+		for _, parent := range parents {
+			left, right := 2*parent, 2*parent+1
+			hash := children[left]
+			if right < width {
+				hash = domain.TallyNode(children[left], children[right])
+			}
+			_, err := t.tx.ExecContext(ctx, `
+				INSERT INTO poll_tally_nodes (poll_id, level, position, hash) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (poll_id, level, position) DO UPDATE SET hash = EXCLUDED.hash
+			`, t.pollID, level+1, parent, hash)
+			if err != nil {
+				return "", fmt.Errorf("failed to save tally node: %w", err)
+			}
+		}
+
+		changed = parents
+		level, width = level+1, (width+1)/2
+	}
+
+	if err := t.truncate(ctx, level, width); err != nil {
+		return "", err
+	}
+	if width == 0 {
+		return domain.MerkleRoot(nil), nil
+	}
+
+	var root []byte
+	err := t.tx.QueryRowContext(ctx, `
+		SELECT hash FROM poll_tally_nodes WHERE poll_id = $1 AND level = $2 AND position = 0
+	`, t.pollID, level).Scan(&root)
+	if err != nil {
+		return "", fmt.Errorf("failed to get tally root: %w", err)
+	}
+	return hex.EncodeToString(root), nil
+}
+
+// truncate drops the nodes of a level past its width. At the top level it
+// also drops every level above, which a larger tree left behind.
+func (t *tallyTree) truncate(ctx context.Context, level, width int) error {
+	query := `DELETE FROM poll_tally_nodes WHERE poll_id = $1 AND level = $2 AND position >= $3`
+	if width <= 1 {
+		query = `DELETE FROM poll_tally_nodes WHERE poll_id = $1 AND (level = $2 AND position >= $3 OR level > $2)`
+	}
+	if _, err := t.tx.ExecContext(ctx, query, t.pollID, level, width); err != nil {
+		return fmt.Errorf("failed to trim tally tree: %w", err)
+	}
+	return nil
+}
+
+// nodes returns the hashes of the children of parents on the level.
+func (t *tallyTree) nodes(ctx context.Context, level int, parents []int) (map[int][]byte, error) {
+	positions := make([]int64, 0, 2*len(parents))
+	for _, parent := range parents {
+		positions = append(positions, int64(2*parent), int64(2*parent+1))
+	}
+
+	rows, err := t.tx.QueryContext(ctx, `
+		SELECT position, hash FROM poll_tally_nodes WHERE poll_id = $1 AND level = $2 AND position = ANY($3)
+	`, t.pollID, level, pq.Array(positions))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tally nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make(map[int][]byte, len(positions))
+	for rows.Next() {
+		var position int
+		var hash []byte
+		if err := rows.Scan(&position, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan tally node: %w", err)
+		}
+		nodes[position] = hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tally nodes: %w", err)
+	}
+	return nodes, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
+	"github.com/lib/pq"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
@@ -18,9 +20,9 @@ func NewUserRepository(db *sql.DB) ports.UserRepository {
 }
 
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*domain.User, error) {
-	query := `SELECT id, email, name, created_at FROM users WHERE email = $1 AND deleted_at IS NULL`
+	query := `SELECT id, email, name, role, created_at FROM users WHERE lower(email) = lower($1) AND deleted_at IS NULL`
 	user := &domain.User{}
-	err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
+	err := r.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Name, &user.Role, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -31,9 +33,9 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*domain.
 }
 
 func (r *UserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
-	query := `SELECT id, email, name, created_at FROM users WHERE id = $1 AND deleted_at IS NULL`
+	query := `SELECT id, email, name, role, created_at FROM users WHERE id = $1 AND deleted_at IS NULL`
 	user := &domain.User{}
-	err := r.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Email, &user.Name, &user.Role, &user.CreatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -44,10 +46,204 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (*domain.User,
 }
 
 func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
-	query := `INSERT INTO users (email, name) VALUES ($1, $2) RETURNING id, created_at`
-	err := r.db.QueryRowContext(ctx, query, user.Email, user.Name).Scan(&user.ID, &user.CreatedAt)
+	query := `INSERT INTO users (email, name) VALUES ($1, $2) RETURNING id, role, created_at`
+	err := r.db.QueryRowContext(ctx, query, user.Email, user.Name).Scan(&user.ID, &user.Role, &user.CreatedAt)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (r *UserRepository) SetRole(ctx context.Context, id string, role domain.Role) error {
+	query := `UPDATE users SET role = $2 WHERE id = $1 AND deleted_at IS NULL`
+	res, err := r.db.ExecContext(ctx, query, id, role)
+	if err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to set role: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) SetBanned(ctx context.Context, id string, banned bool) error {
+	query := `
+		UPDATE users
+		SET deleted_at = CASE WHEN $2 THEN COALESCE(deleted_at, NOW()) ELSE NULL END
+		WHERE id = $1
+	`
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, id, banned)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrUserNotFound
+	}
+	return nil
+}
+
+func (r *UserRepository) CreateWithIdentity(ctx context.Context, user *domain.User, identity *domain.UserIdentity) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO users (email, name) VALUES ($1, $2) RETURNING id, role, created_at`
+	if err := tx.QueryRowContext(ctx, query, user.Email, user.Name).Scan(&user.ID, &user.Role, &user.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	identity.UserID = user.ID
+	if err := insertIdentity(ctx, tx, identity); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *UserRepository) GetIdentity(ctx context.Context, provider, subject string) (*domain.UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, email_verified, created_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+	identity := &domain.UserIdentity{}
+	err := r.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.EmailVerified,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (r *UserRepository) ListIdentities(ctx context.Context, userID string) ([]*domain.UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, email_verified, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []*domain.UserIdentity{}
+	for rows.Next() {
+		identity := &domain.UserIdentity{}
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.EmailVerified,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+func (r *UserRepository) CreateIdentity(ctx context.Context, identity *domain.UserIdentity) error {
+	return insertIdentity(ctx, r.db, identity)
+}
+
+// DeleteIdentity locks the user first, so concurrent unlinks of two of
+// their identities cannot both see the other one left.
+func (r *UserRepository) DeleteIdentity(ctx context.Context, userID, identityID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	query := `
+		DELETE FROM user_identities
+		WHERE id = $1 AND user_id = $2
+		  AND EXISTS (SELECT 1 FROM user_identities WHERE user_id = $2 AND id <> $1)
+	`
+	res, err := tx.ExecContext(ctx, query, identityID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+	if affected == 0 {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM user_identities WHERE id = $1 AND user_id = $2)`, identityID, userID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to get identity: %w", err)
+		}
+		if exists {
+			return domain.ErrLastIdentity
+		}
+		return domain.ErrIdentityNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (r *UserRepository) IsBanned(ctx context.Context, email string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1) AND deleted_at IS NOT NULL)`
+	var banned bool
+	if err := r.db.QueryRowContext(ctx, query, email).Scan(&banned); err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+	return banned, nil
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertIdentity(ctx context.Context, q queryRower, identity *domain.UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email, email_verified)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+	err := q.QueryRowContext(ctx, query, identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.EmailVerified).
+		Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return domain.ErrIdentityLinked
+		}
+		return fmt.Errorf("failed to create identity: %w", err)
+	}
+	return nil
+}
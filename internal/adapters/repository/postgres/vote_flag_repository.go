@@ -0,0 +1,248 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type voteFlagRepository struct {
+	db *sql.DB
+}
+
+func NewVoteFlagRepository(db *sql.DB) ports.VoteFlagRepository {
+	return &voteFlagRepository{db: db}
+}
+
+// Every rule query selects the vote_id, poll_id, user_id, device_id,
+// voter_ip and details of the flags to insert. $1 is always the start of the
+// analysed period. Anonymous voters are told apart by their device, which
+// COALESCE(user_id, device_id) folds into one voter key. Secret ballots have
+// neither voter nor address to act on; they count towards bursts but are
+// never flagged themselves.
+const (
+	// A vote is part of a burst when some window of BurstWindow around it
+	// holds BurstVotes votes on its option: "recent" counts the votes in the
+	// window ending at each vote, and a vote belongs to a burst when one of
+	// the windows ending within BurstWindow after it is full.
+	burstVotesQuery = `
+		WITH windowed AS (
+			SELECT id, poll_id, option_id, user_id, device_id, voter_ip, created_at, deleted_at,
+			       COUNT(*) OVER (PARTITION BY option_id ORDER BY created_at
+			                      RANGE BETWEEN make_interval(secs => $2::float8) PRECEDING AND CURRENT ROW) AS recent
+			FROM votes
+			WHERE created_at >= $1
+		), bursts AS (
+			SELECT *, MAX(recent) OVER (PARTITION BY option_id ORDER BY created_at
+			                            RANGE BETWEEN CURRENT ROW AND make_interval(secs => $2::float8) FOLLOWING) AS burst
+			FROM windowed
+		)
+		SELECT id, poll_id, user_id, device_id, voter_ip, jsonb_build_object('option_id', option_id, 'votes_in_window', burst)
+		FROM bursts
+		WHERE burst >= $3 AND deleted_at IS NULL AND COALESCE(user_id, device_id) IS NOT NULL
+	`
+
+	// Addresses are matched by their keyed hashes, which are kept when the
+	// addresses themselves are anonymized. The subnet is only shown while
+	// some of its addresses are still stored.
+	sharedIPVotesQuery = `
+		WITH shared AS (
+			SELECT poll_id, voter_ip_hash, COUNT(DISTINCT COALESCE(user_id, device_id)) AS accounts
+			FROM votes
+			WHERE created_at >= $1 AND deleted_at IS NULL AND voter_ip_hash IS NOT NULL
+			GROUP BY poll_id, voter_ip_hash
+			HAVING COUNT(DISTINCT COALESCE(user_id, device_id)) >= $2
+		)
+		SELECT v.id, v.poll_id, v.user_id, v.device_id, v.voter_ip, jsonb_build_object('accounts', s.accounts)
+		FROM votes v
+		JOIN shared s ON s.poll_id = v.poll_id AND s.voter_ip_hash = v.voter_ip_hash
+		WHERE v.created_at >= $1 AND v.deleted_at IS NULL
+	`
+
+	sharedSubnetVotesQuery = `
+		WITH shared AS (
+			SELECT poll_id, voter_subnet_hash, COUNT(DISTINCT COALESCE(user_id, device_id)) AS accounts,
+			       MIN(network(set_masklen(voter_ip, CASE family(voter_ip) WHEN 4 THEN 24 ELSE 48 END))::text) AS subnet
+			FROM votes
+			WHERE created_at >= $1 AND deleted_at IS NULL AND voter_subnet_hash IS NOT NULL
+			GROUP BY poll_id, voter_subnet_hash
+			HAVING COUNT(DISTINCT COALESCE(user_id, device_id)) >= $2
+		)
+		SELECT v.id, v.poll_id, v.user_id, v.device_id, v.voter_ip, jsonb_build_object('subnet', s.subnet, 'accounts', s.accounts)
+		FROM votes v
+		JOIN shared s ON s.poll_id = v.poll_id AND s.voter_subnet_hash = v.voter_subnet_hash
+		WHERE v.created_at >= $1 AND v.deleted_at IS NULL
+	`
+
+	newAccountVotesQuery = `
+		SELECT v.id, v.poll_id, v.user_id, v.device_id, v.voter_ip,
+		       jsonb_build_object('account_age_seconds', EXTRACT(EPOCH FROM v.created_at - u.created_at)::int)
+		FROM votes v
+		JOIN users u ON u.id = v.user_id
+		WHERE v.created_at >= $1 AND v.deleted_at IS NULL
+		  AND v.created_at - u.created_at < make_interval(secs => $2::float8)
+	`
+
+	// Changing a vote retracts it and casts a new one, so a flipping voter
+	// leaves many rows behind. They all count towards the flips, but only
+	// their latest vote still standing is flagged.
+	flippingVotesQuery = `
+		WITH windowed AS (
+			SELECT id, poll_id, user_id, device_id, voter_ip, created_at, deleted_at, COALESCE(user_id, device_id) AS voter,
+			       COUNT(*) OVER (PARTITION BY poll_id, COALESCE(user_id, device_id) ORDER BY created_at
+			                      RANGE BETWEEN make_interval(secs => $2::float8) PRECEDING AND CURRENT ROW) AS recent
+			FROM votes
+			WHERE created_at >= $1 AND COALESCE(user_id, device_id) IS NOT NULL
+		), flippers AS (
+			SELECT poll_id, voter, MAX(recent) AS votes
+			FROM windowed
+			GROUP BY poll_id, voter
+			HAVING MAX(recent) >= $3
+		)
+		SELECT DISTINCT ON (w.poll_id, w.voter) w.id, w.poll_id, w.user_id, w.device_id, w.voter_ip, jsonb_build_object('votes_in_window', f.votes)
+		FROM windowed w
+		JOIN flippers f ON f.poll_id = w.poll_id AND f.voter = w.voter
+		WHERE w.deleted_at IS NULL
+		ORDER BY w.poll_id, w.voter, w.created_at DESC
+	`
+)
+
+func (r *voteFlagRepository) FlagVotes(ctx context.Context, rule domain.VoteFlagRule, since time.Time, config domain.VoteAnalysisConfig) (int64, error) {
+	var query string
+	var args []any
+	switch rule {
+	case domain.VoteFlagBurst:
+		query, args = burstVotesQuery, []any{since, config.BurstWindow.Seconds(), config.BurstVotes}
+	case domain.VoteFlagSharedIP:
+		query, args = sharedIPVotesQuery, []any{since, config.AccountsPerIP}
+	case domain.VoteFlagSharedSubnet:
+		query, args = sharedSubnetVotesQuery, []any{since, config.AccountsPerSubnet}
+	case domain.VoteFlagNewAccount:
+		query, args = newAccountVotesQuery, []any{since, config.NewAccountAge.Seconds()}
+	case domain.VoteFlagFlipping:
+		query, args = flippingVotesQuery, []any{since, config.FlipWindow.Seconds(), config.FlipVotes}
+	default:
+		return 0, fmt.Errorf("unknown vote flag rule %q", rule)
+	}
+
+	insert := fmt.Sprintf(`
+		INSERT INTO vote_flags (vote_id, poll_id, user_id, device_id, voter_ip, details, rule)
+		SELECT matches.*, $%d::text FROM (%s) AS matches
+		ON CONFLICT (vote_id, rule) DO NOTHING
+	`, len(args)+1, query)
+	res, err := r.db.ExecContext(ctx, insert, append(args, rule)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to flag %s votes: %w", rule, err)
+	}
+	return res.RowsAffected()
+}
+
+func (r *voteFlagRepository) GetByID(ctx context.Context, id uuid.UUID) (*domain.VoteFlag, error) {
+	query := `
+		SELECT id, vote_id, poll_id, user_id, device_id, voter_ip, rule, details, status, resolved_by, resolved_at, created_at
+		FROM vote_flags
+		WHERE id = $1
+	`
+	flag, err := scanVoteFlag(conn(ctx, r.db).QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get vote flag: %w", err)
+	}
+	return flag, nil
+}
+
+func (r *voteFlagRepository) List(ctx context.Context, status domain.VoteFlagStatus, limit, offset int) ([]*domain.VoteFlag, error) {
+	query := `
+		SELECT id, vote_id, poll_id, user_id, device_id, voter_ip, rule, details, status, resolved_by, resolved_at, created_at
+		FROM vote_flags
+		WHERE status = $1
+		ORDER BY created_at, id
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.db.QueryContext(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query vote flags: %w", err)
+	}
+	defer rows.Close()
+
+	flags := []*domain.VoteFlag{}
+	for rows.Next() {
+		flag, err := scanVoteFlag(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan vote flag: %w", err)
+		}
+		flags = append(flags, flag)
+	}
+
+	return flags, rows.Err()
+}
+
+func (r *voteFlagRepository) Resolve(ctx context.Context, id uuid.UUID, status domain.VoteFlagStatus, resolvedBy uuid.UUID) error {
+	query := `
+		UPDATE vote_flags
+		SET status = $2, resolved_by = $3, resolved_at = NOW()
+		WHERE id = $1 AND status = 'open'
+	`
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, id, status, resolvedBy)
+	if err != nil {
+		return fmt.Errorf("failed to resolve vote flag: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to resolve vote flag: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrVoteFlagResolved
+	}
+	return nil
+}
+
+func (r *voteFlagRepository) ResolveOpenForVote(ctx context.Context, voteID uuid.UUID, status domain.VoteFlagStatus, resolvedBy uuid.UUID) (int64, error) {
+	query := `
+		UPDATE vote_flags
+		SET status = $2, resolved_by = $3, resolved_at = NOW()
+		WHERE vote_id = $1 AND status = 'open'
+	`
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, voteID, status, resolvedBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve vote flags: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func scanVoteFlag(row interface{ Scan(dest ...any) error }) (*domain.VoteFlag, error) {
+	flag := &domain.VoteFlag{}
+	var voterIP sql.NullString
+	var details []byte
+	err := row.Scan(
+		&flag.ID,
+		&flag.VoteID,
+		&flag.PollID,
+		&flag.UserID,
+		&flag.DeviceID,
+		&voterIP,
+		&flag.Rule,
+		&details,
+		&flag.Status,
+		&flag.ResolvedBy,
+		&flag.ResolvedAt,
+		&flag.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	flag.VoterIP = voterIP.String
+	if err := json.Unmarshal(details, &flag.Details); err != nil {
+		return nil, fmt.Errorf("failed to decode vote flag details: %w", err)
+	}
+	return flag, nil
+}
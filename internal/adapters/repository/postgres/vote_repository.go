@@ -22,31 +22,77 @@ func NewVoteRepository(db *sql.DB) ports.VoteRepository {
 
 func (r *voteRepository) SaveVote(ctx context.Context, vote *domain.Vote) error {
 	query := `
-		INSERT INTO votes (id, poll_id, option_id, user_id, voter_ip)
-		VALUES ($1, $2, $3, $4, $5);
+		INSERT INTO votes (id, poll_id, option_id, user_id, device_id, voter_ip, voter_ip_hash, voter_subnet_hash, receipt_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
 	`
-	_, err := r.db.ExecContext(ctx, query, vote.ID, vote.PollID, vote.OptionID, vote.UserID, vote.VoterIP)
+	_, err := conn(ctx, r.db).ExecContext(ctx, query, vote.ID, vote.PollID, vote.OptionID, vote.UserID, vote.DeviceID,
+		nullIfEmpty(vote.VoterIP), nullIfEmpty(vote.VoterIPHash), nullIfEmpty(vote.VoterSubnetHash), vote.ReceiptHash)
 	if err != nil {
 		return fmt.Errorf("failed to save vote: %w", err)
 	}
 	return nil
 }
 
-func (r *voteRepository) DeleteVote(ctx context.Context, pollID, userID uuid.UUID) error {
+// voterColumn returns the column identifying the voter's votes and the
+// value to match it against.
+func voterColumn(voter domain.Voter) (string, uuid.UUID) {
+	if voter.UserID != nil {
+		return "user_id", *voter.UserID
+	}
+	if voter.DeviceID != nil {
+		return "device_id", *voter.DeviceID
+	}
+	return "user_id", uuid.Nil
+}
+
+func (r *voteRepository) DeleteVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter) error {
+	column, id := voterColumn(voter)
 	query := `
-		UPDATE votes SET deleted_at = NOW() WHERE poll_id = $1 and user_id = $2
+		UPDATE votes SET deleted_at = NOW()
+		WHERE poll_id = $1 AND ` + column + ` = $2 AND deleted_at IS NULL AND status IN ('pending', 'valid')
 	`
-	_, err := r.db.ExecContext(ctx, query, pollID, userID)
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, pollID, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete vote: %w", err)
 	}
-	return nil
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete vote: %w", err)
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	// The vote left is one an admin invalidated, if any.
+	var invalidated bool
+	query = `SELECT EXISTS (SELECT 1 FROM votes WHERE poll_id = $1 AND ` + column + ` = $2 AND deleted_at IS NULL)`
+	if err := conn(ctx, r.db).QueryRowContext(ctx, query, pollID, id).Scan(&invalidated); err != nil {
+		return fmt.Errorf("failed to delete vote: %w", err)
+	}
+	if invalidated {
+		return domain.ErrVoteInvalidated
+	}
+	return domain.ErrUserNotVoted
+}
+
+func (r *voteRepository) HasVoted(ctx context.Context, pollID uuid.UUID, voter domain.Voter) (bool, error) {
+	column, id := voterColumn(voter)
+	query := `SELECT EXISTS (SELECT 1 FROM votes WHERE poll_id = $1 AND ` + column + ` = $2 AND deleted_at IS NULL)`
+	if voter.UserID != nil {
+		query += ` OR EXISTS (SELECT 1 FROM poll_participants WHERE poll_id = $1 AND user_id = $2)`
+	}
+	var exists bool
+	if err := conn(ctx, r.db).QueryRowContext(ctx, query, pollID, id).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check existing vote: %w", err)
+	}
+	return exists, nil
 }
 
-func (r *voteRepository) HasVoted(ctx context.Context, pollID uuid.UUID, userID uuid.UUID) (bool, error) {
-	query := `SELECT 1 FROM votes WHERE poll_id = $1 AND user_id = $2 AND deleted_at IS NULL LIMIT 1`
+func (r *voteRepository) HasVotedOnOption(ctx context.Context, optionID uuid.UUID, voter domain.Voter) (bool, error) {
+	column, id := voterColumn(voter)
+	query := `SELECT 1 FROM votes WHERE option_id = $1 AND ` + column + ` = $2 AND deleted_at IS NULL LIMIT 1`
 	var exists int
-	err := r.db.QueryRowContext(ctx, query, pollID, userID).Scan(&exists)
+	err := r.db.QueryRowContext(ctx, query, optionID, id).Scan(&exists)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return false, nil
@@ -56,35 +102,247 @@ func (r *voteRepository) HasVoted(ctx context.Context, pollID uuid.UUID, userID
 	return true, nil
 }
 
-func (r *voteRepository) HasVotedOnOption(ctx context.Context, optionID uuid.UUID, userID uuid.UUID) (bool, error) {
-	query := `SELECT 1 FROM votes WHERE option_id = $1 AND user_id = $2 AND deleted_at IS NULL LIMIT 1`
-	var exists int
-	err := r.db.QueryRowContext(ctx, query, optionID, userID).Scan(&exists)
+func (r *voteRepository) GetVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter) (*domain.Vote, error) {
+	column, id := voterColumn(voter)
+	query := `
+		SELECT id, poll_id, option_id, user_id, device_id, receipt_hash, voter_ip, created_at
+		FROM votes
+		WHERE poll_id = $1 AND ` + column + ` = $2 AND deleted_at IS NULL
+	`
+	vote, err := scanVote(r.db.QueryRowContext(ctx, query, pollID, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return false, nil
+			return nil, domain.ErrVoteNotFound
 		}
-		return false, fmt.Errorf("failed to check existing vote: %w", err)
+		return nil, fmt.Errorf("failed to get vote: %w", err)
 	}
-	return true, nil
+
+	return vote, nil
 }
 
-func (r *voteRepository) GetVote(ctx context.Context, pollID, userID uuid.UUID) (*domain.Vote, error) {
+func (r *voteRepository) CountAnonymousVotes(ctx context.Context, pollID uuid.UUID, voterIPHash string) (int, error) {
 	query := `
-		SELECT id, poll_id, option_id, user_id, voter_ip, created_at
+		SELECT COUNT(*) FROM votes
+		WHERE poll_id = $1 AND voter_ip_hash = $2 AND device_id IS NOT NULL AND deleted_at IS NULL
+	`
+	var count int
+	if err := conn(ctx, r.db).QueryRowContext(ctx, query, pollID, voterIPHash).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count anonymous votes: %w", err)
+	}
+	return count, nil
+}
+
+// LockAnonymousVotes takes a transaction-level advisory lock keyed by the
+// poll and the address hash, so it only works within Transactor.WithinTx.
+func (r *voteRepository) LockAnonymousVotes(ctx context.Context, pollID uuid.UUID, voterIPHash string) error {
+	_, err := conn(ctx, r.db).ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text || ':' || $2, 0))`, pollID, voterIPHash)
+	if err != nil {
+		return fmt.Errorf("failed to lock anonymous votes: %w", err)
+	}
+	return nil
+}
+
+// SaveBallot writes the participant and the ballot in one transaction, so
+// neither is left without the other. Nothing the API returns pairs them, but
+// the rows share a transaction id for whoever reads the database.
+func (r *voteRepository) SaveBallot(ctx context.Context, ballot *domain.Vote, userID uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO poll_participants (poll_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, ballot.PollID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to save participant: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to save participant: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrAlreadyVoted
+	}
+
+	if err := insertBallot(ctx, tx, ballot); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *voteRepository) GetBallot(ctx context.Context, pollID uuid.UUID, receiptHash string) (*domain.Vote, error) {
+	query := `
+		SELECT id, poll_id, option_id, receipt_hash, COALESCE(owner_hash, ''), created_at
 		FROM votes
-		WHERE poll_id = $1 AND user_id = $2 AND deleted_at IS NULL
+		WHERE poll_id = $1 AND receipt_hash = $2 AND user_id IS NULL AND device_id IS NULL
+		  AND deleted_at IS NULL AND status IN ('pending', 'valid')
 	`
-	var vote domain.Vote
-	err := r.db.QueryRowContext(ctx, query, pollID, userID).Scan(
-		&vote.ID, &vote.PollID, &vote.OptionID, &vote.UserID, &vote.VoterIP, &vote.CreatedAt,
+	var ballot domain.Vote
+	err := r.db.QueryRowContext(ctx, query, pollID, receiptHash).Scan(
+		&ballot.ID, &ballot.PollID, &ballot.OptionID, &ballot.ReceiptHash, &ballot.OwnerHash, &ballot.CreatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, domain.ErrUserNotVoted
+			return nil, domain.ErrInvalidReceipt
 		}
-		return nil, fmt.Errorf("failed to get vote: %w", err)
+		return nil, fmt.Errorf("failed to get ballot: %w", err)
+	}
+	return &ballot, nil
+}
+
+func (r *voteRepository) ReplaceBallot(ctx context.Context, receiptHash string, ballot *domain.Vote) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := retractBallot(ctx, tx, ballot.PollID, receiptHash); err != nil {
+		return err
+	}
+
+	if err := insertBallot(ctx, tx, ballot); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *voteRepository) RetractBallot(ctx context.Context, pollID uuid.UUID, receiptHash string, userID uuid.UUID) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := retractBallot(ctx, tx, pollID, receiptHash); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM poll_participants WHERE poll_id = $1 AND user_id = $2`, pollID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove participant: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func insertBallot(ctx context.Context, db dbtx, ballot *domain.Vote) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO votes (id, poll_id, option_id, receipt_hash, owner_hash)
+		VALUES ($1, $2, $3, $4, $5)
+	`, ballot.ID, ballot.PollID, ballot.OptionID, ballot.ReceiptHash, ballot.OwnerHash)
+	if err != nil {
+		return fmt.Errorf("failed to save ballot: %w", err)
+	}
+	return nil
+}
+
+func retractBallot(ctx context.Context, db dbtx, pollID uuid.UUID, receiptHash string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE votes SET deleted_at = NOW()
+		WHERE poll_id = $1 AND receipt_hash = $2 AND deleted_at IS NULL
+	`, pollID, receiptHash)
+	if err != nil {
+		return fmt.Errorf("failed to retract ballot: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to retract ballot: %w", err)
+	}
+	if affected == 0 {
+		return domain.ErrInvalidReceipt
 	}
+	return nil
+}
 
+func (r *voteRepository) StreamVotes(ctx context.Context, pollID uuid.UUID, fn func(*domain.Vote) error) error {
+	query := `
+		SELECT id, poll_id, option_id, user_id, device_id, receipt_hash, voter_ip, created_at
+		FROM votes
+		WHERE poll_id = $1 AND deleted_at IS NULL AND status IN ('pending', 'valid')
+		ORDER BY CASE WHEN user_id IS NULL AND device_id IS NULL THEN date_trunc('day', created_at, 'UTC') ELSE created_at END, receipt_hash
+	`
+	rows, err := r.db.QueryContext(ctx, query, pollID)
+	if err != nil {
+		return fmt.Errorf("failed to stream votes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		vote, err := scanVote(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan vote: %w", err)
+		}
+		if err := fn(vote); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating votes: %w", err)
+	}
+
+	return nil
+}
+
+func (r *voteRepository) InvalidateUserVotes(ctx context.Context, userID uuid.UUID) (int64, error) {
+	// Counted votes are left for ProcessVotes to decrement, while pending
+	// ones were never counted and are settled right away.
+	query := `
+		UPDATE votes
+		SET status = CASE WHEN status = 'pending' THEN 'invalid' ELSE 'invalidated' END::vote_status
+		WHERE user_id = $1 AND deleted_at IS NULL AND status IN ('pending', 'valid')
+	`
+	res, err := conn(ctx, r.db).ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to invalidate votes: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to invalidate votes: %w", err)
+	}
+	return affected, nil
+}
+
+func (r *voteRepository) InvalidateVote(ctx context.Context, voteID uuid.UUID) error {
+	query := `
+		UPDATE votes
+		SET status = CASE WHEN status = 'pending' THEN 'invalid' ELSE 'invalidated' END::vote_status
+		WHERE id = $1 AND deleted_at IS NULL AND status IN ('pending', 'valid')
+	`
+	_, err := conn(ctx, r.db).ExecContext(ctx, query, voteID)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate vote: %w", err)
+	}
+	return nil
+}
+
+func scanVote(row interface{ Scan(dest ...any) error }) (*domain.Vote, error) {
+	var vote domain.Vote
+	var voterIP sql.NullString
+	err := row.Scan(&vote.ID, &vote.PollID, &vote.OptionID, &vote.UserID, &vote.DeviceID, &vote.ReceiptHash, &voterIP, &vote.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	vote.VoterIP = voterIP.String
 	return &vote, nil
 }
+
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
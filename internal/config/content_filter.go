@@ -0,0 +1,55 @@
+// Package config reads from the environment the settings that several
+// commands share, so they cannot disagree on them.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/vncsmyrnk/poll/internal/adapters/contentfilter"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+// LoadContentFilter builds the built-in content filter with the terms listed
+// in CONTENT_BLOCKLIST_FILE, if set, and the limits of
+// CONTENT_{TITLE,DESCRIPTION,OPTION}_MAX_LENGTH, 0 for no limit, and
+// CONTENT_{TITLE,DESCRIPTION,OPTION}_MAX_URLS, negative for no limit. Unset
+// limits keep the values of contentfilter.DefaultConfig.
+func LoadContentFilter() (ports.ContentFilter, error) {
+	config := contentfilter.DefaultConfig()
+	if path := os.Getenv("CONTENT_BLOCKLIST_FILE"); path != "" {
+		blocklist, err := contentfilter.LoadBlocklist(path)
+		if err != nil {
+			return nil, err
+		}
+		config.Blocklist = blocklist
+	}
+
+	fields := map[string]*contentfilter.Limits{
+		"TITLE":       &config.Title,
+		"DESCRIPTION": &config.Description,
+		"OPTION":      &config.Option,
+	}
+	for field, limits := range fields {
+		name := "CONTENT_" + field + "_MAX_LENGTH"
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			limits.MaxLength = n
+		}
+
+		name = "CONTENT_" + field + "_MAX_URLS"
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an integer", name)
+			}
+			limits.MaxURLs = n
+		}
+	}
+
+	return contentfilter.New(config), nil
+}
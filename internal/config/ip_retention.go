@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+const defaultIPRetentionDays = 30
+
+// LoadIPRetentionPolicy reads what voters' addresses become,
+// IP_ANONYMIZATION (truncate or hash, empty keeping them as they are), after
+// IP_RETENTION_DAYS days, 0 anonymizing them before they are stored.
+// IP_HASH_SECRET keys the hashes abuse detection matches votes by. It must
+// be set along with IP_ANONYMIZATION, since unkeyed hashes of addresses are
+// easily reversed; without it no hashes are recorded. The server and the IP
+// retention job both read it from here.
+func LoadIPRetentionPolicy() (domain.IPRetentionPolicy, error) {
+	policy := domain.IPRetentionPolicy{
+		Anonymization: domain.IPAnonymization(os.Getenv("IP_ANONYMIZATION")),
+		RetainFor:     defaultIPRetentionDays * 24 * time.Hour,
+		HashKey:       []byte(os.Getenv("IP_HASH_SECRET")),
+	}
+	if !policy.Anonymization.Valid() {
+		return policy, fmt.Errorf("IP_ANONYMIZATION must be truncate, hash or empty, got %q", policy.Anonymization)
+	}
+
+	if value := os.Getenv("IP_RETENTION_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return policy, fmt.Errorf("IP_RETENTION_DAYS must be a non-negative integer")
+		}
+		policy.RetainFor = time.Duration(days) * 24 * time.Hour
+	}
+
+	if len(policy.HashKey) == 0 && policy.Anonymization != domain.IPKeep {
+		return policy, errors.New("IP_HASH_SECRET must be set along with IP_ANONYMIZATION")
+	}
+	return policy, nil
+}
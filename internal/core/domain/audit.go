@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AuditAction string
+
+const (
+	AuditPollHide            AuditAction = "poll.hide"
+	AuditPollRestore         AuditAction = "poll.restore"
+	AuditUserBan             AuditAction = "user.ban"
+	AuditUserUnban           AuditAction = "user.unban"
+	AuditUserVotesInvalidate AuditAction = "user.votes_invalidate"
+	AuditUserTokensView      AuditAction = "user.refresh_tokens_view"
+	AuditReportDismiss       AuditAction = "report.dismiss"
+	AuditReportAction        AuditAction = "report.action"
+	AuditVoteFlagDismiss     AuditAction = "vote_flag.dismiss"
+	AuditVoteFlagInvalidate  AuditAction = "vote_flag.invalidate"
+)
+
+const (
+	AuditTargetPoll     = "poll"
+	AuditTargetUser     = "user"
+	AuditTargetReport   = "report"
+	AuditTargetVoteFlag = "vote_flag"
+)
+
+// AuditEntry records an action taken through the admin API. Entries are
+// never updated nor deleted.
+type AuditEntry struct {
+	ID         uuid.UUID      `json:"id"`
+	ActorID    uuid.UUID      `json:"actor_id"`
+	Action     AuditAction    `json:"action"`
+	TargetType string         `json:"target_type"`
+	TargetID   uuid.UUID      `json:"target_id"`
+	Reason     string         `json:"reason,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package domain
+
+import "time"
+
+type ChallengeType string
+
+const (
+	// ChallengeCaptcha is solved by a Turnstile or hCaptcha widget
+	// configured with SiteKey.
+	ChallengeCaptcha ChallengeType = "captcha"
+	// ChallengeProofOfWork is solved by finding a counter such that the
+	// SHA-256 of "<token>:<counter>" starts with Difficulty zero bits.
+	ChallengeProofOfWork ChallengeType = "pow"
+)
+
+// Challenge tells a client what it has to solve before voting on a poll
+// that requires it. The solution is sent as the vote's challenge_response.
+type Challenge struct {
+	Type       ChallengeType `json:"type"`
+	SiteKey    string        `json:"site_key,omitempty"`
+	Token      string        `json:"token,omitempty"`
+	Difficulty int           `json:"difficulty,omitempty"`
+	ExpiresAt  *time.Time    `json:"expires_at,omitempty"`
+}
@@ -1,13 +1,96 @@
 package domain
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
-	ErrPollNotFound  = errors.New("poll not found")
-	ErrInvalidPollID = errors.New("invalid poll id")
-	ErrInvalidUserID = errors.New("invalid user id")
-	ErrInvalidOption = errors.New("invalid option for this poll")
-	ErrAlreadyVoted  = errors.New("user has already voted")
-	ErrUserNotVoted  = errors.New("user did not vote on this poll")
-	ErrInternal      = errors.New("internal server error")
+	ErrPollNotFound        = errors.New("poll not found")
+	ErrInvalidPollID       = errors.New("invalid poll id")
+	ErrInvalidUserID       = errors.New("invalid user id")
+	ErrInvalidOption       = errors.New("invalid option for this poll")
+	ErrAlreadyVoted        = errors.New("user has already voted")
+	ErrUserNotVoted        = errors.New("user did not vote on this poll")
+	ErrVoteNotFound        = errors.New("vote not found")
+	ErrVoteInvalidated     = errors.New("vote was invalidated and cannot be changed")
+	ErrLoginRequired       = errors.New("this poll does not accept anonymous votes, log in to vote")
+	ErrIPVoteLimit         = errors.New("too many anonymous votes on this poll from your network")
+	ErrReceiptRequired     = errors.New("this poll uses secret ballots, the ballot receipt is required")
+	ErrInvalidReceipt      = errors.New("ballot receipt is invalid or no longer current")
+	ErrReceiptNotCounted   = errors.New("receipt is not among the counted votes of this poll yet")
+	ErrInvalidReceiptHash  = errors.New("receipt hash must be the hex SHA-256 of the receipt")
+	ErrTallyNotPublished   = errors.New("this poll has no published tally yet")
+	ErrChallengeRequired   = errors.New("this poll requires a solved challenge to vote")
+	ErrChallengeFailed     = errors.New("challenge response is invalid, expired or already used")
+	ErrInvalidBucket       = errors.New("invalid timeline bucket")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrUserBanned          = errors.New("this account is banned")
+	ErrInvalidRole         = errors.New("invalid role")
+	ErrInsufficientRole    = errors.New("role does not allow this action")
+	ErrBanSelf             = errors.New("cannot ban yourself")
+	ErrAlreadyReported     = errors.New("user has already reported this poll")
+	ErrReportNotFound      = errors.New("report not found")
+	ErrInvalidReportID     = errors.New("invalid report id")
+	ErrInvalidReportStatus = errors.New("invalid report status")
+	ErrReportResolved      = errors.New("report was already resolved")
+	ErrVoteFlagNotFound    = errors.New("vote flag not found")
+	ErrInvalidVoteFlagID   = errors.New("invalid vote flag id")
+	ErrInvalidFlagStatus   = errors.New("invalid vote flag status")
+	ErrVoteFlagResolved    = errors.New("vote flag was already resolved")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrUnknownProvider     = errors.New("unknown login provider")
+	ErrUnsupportedFlow     = errors.New("login provider does not support this flow")
+	ErrInvalidLoginLink    = errors.New("login link is invalid, expired or already used")
+	ErrIdentityNotLinked   = errors.New("an account already uses this email, log in to it and link this provider")
+	ErrIdentityLinked      = errors.New("identity is already linked to another account")
+	ErrIdentityNotFound    = errors.New("identity not found")
+	ErrInvalidIdentityID   = errors.New("invalid identity id")
+	ErrLastIdentity        = errors.New("cannot unlink the only identity of the account")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, session revoked")
+	ErrInvalidSessionID    = errors.New("invalid session id")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrInvalidAPIToken     = errors.New("invalid api token")
+	ErrInvalidAPITokenID   = errors.New("invalid api token id")
+	ErrAPITokenNotFound    = errors.New("api token not found")
+	ErrInsufficientScope   = errors.New("api token lacks the scope required by this route")
+	ErrInternal            = errors.New("internal server error")
 )
+
+// FieldError describes why a single input field was rejected. Code is a
+// stable, machine readable reason such as "required" or "too_few".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError gathers every field error found while validating an input,
+// so clients can report them all at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		messages[i] = f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ValidationError) Add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Err returns nil when no field error was added, so callers can write
+// `return v.Err()` at the end of a validation.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,136 @@
+package domain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"time"
+)
+
+// IPAnonymization is what is left of a voter's address once it has been
+// kept for the retention period.
+type IPAnonymization string
+
+const (
+	// IPKeep keeps addresses as they are.
+	IPKeep IPAnonymization = ""
+	// IPTruncate keeps the /24 network of IPv4 addresses and the /48 one of
+	// IPv6 addresses, and drops the hash of the address along with it.
+	IPTruncate IPAnonymization = "truncate"
+	// IPHash removes addresses, leaving only their keyed hashes.
+	IPHash IPAnonymization = "hash"
+)
+
+func (a IPAnonymization) Valid() bool {
+	switch a {
+	case IPKeep, IPTruncate, IPHash:
+		return true
+	}
+	return false
+}
+
+// IPRetentionPolicy decides how long voters' addresses are stored. Votes
+// record keyed hashes of the address and of its /24 or /48 network, which
+// abuse detection matches votes by, so anonymizing the address does not keep
+// votes from the same one from being grouped. Truncation only leaves the
+// network's hash, so truncated votes are grouped by network alone. HashKey
+// must stay the same for hashes to keep matching, and without one no hashes
+// are made.
+type IPRetentionPolicy struct {
+	Anonymization IPAnonymization
+	// RetainFor is how long raw addresses are kept before they are
+	// anonymized. Zero anonymizes them before they are ever stored.
+	RetainFor time.Duration
+	HashKey   []byte
+}
+
+// StoresAddresses reports whether raw addresses are stored at all, if only
+// for the retention period.
+func (p IPRetentionPolicy) StoresAddresses() bool {
+	return p.RetainFor > 0 || p.Anonymization == IPKeep
+}
+
+// StoredIP returns the form of the address stored with a new vote, empty
+// when none is.
+func (p IPRetentionPolicy) StoredIP(ip string) string {
+	if p.StoresAddresses() {
+		return ip
+	}
+	switch p.Anonymization {
+	case IPTruncate:
+		return TruncateIP(ip)
+	case IPHash:
+		return ""
+	}
+	return ip
+}
+
+// StoredIPHash returns the hash of the address stored with a new vote,
+// empty when truncation applies before addresses are ever stored.
+func (p IPRetentionPolicy) StoredIPHash(ip string) string {
+	if p.Anonymization == IPTruncate && !p.StoresAddresses() {
+		return ""
+	}
+	return p.HashIP(ip)
+}
+
+// HashIP returns the keyed hash of the address, empty when it is not one or
+// there is no key.
+func (p IPRetentionPolicy) HashIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || len(p.HashKey) == 0 {
+		return ""
+	}
+	return p.hash(addr.Unmap().WithZone("").String())
+}
+
+// HashSubnet returns the keyed hash of the address's /24 or /48 network,
+// empty when it is not an address or there is no key.
+func (p IPRetentionPolicy) HashSubnet(ip string) string {
+	subnet, ok := subnetOf(ip)
+	if !ok || len(p.HashKey) == 0 {
+		return ""
+	}
+	return p.hash(subnet.String())
+}
+
+func (p IPRetentionPolicy) hash(value string) string {
+	mac := hmac.New(sha256.New, p.HashKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TruncateIP zeroes the host part of the address below its /24 or /48
+// network. It returns the input unchanged when it is not an address.
+func TruncateIP(ip string) string {
+	subnet, ok := subnetOf(ip)
+	if !ok {
+		return ip
+	}
+	return subnet.Addr().String()
+}
+
+func subnetOf(ip string) (netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	subnet, err := addr.Prefix(bits)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return subnet, true
+}
+
+// IPRetentionResult counts the votes whose address an IP retention run
+// hashed, for votes from before hashes were recorded, and anonymized.
+type IPRetentionResult struct {
+	Hashed     int64
+	Anonymized int64
+}
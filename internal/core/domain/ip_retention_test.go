@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPRetentionPolicy(t *testing.T) {
+	policy := IPRetentionPolicy{Anonymization: IPTruncate, RetainFor: time.Hour, HashKey: []byte("key")}
+
+	// 1. Truncation keeps the /24 or /48 network
+	assert.Equal(t, "203.0.113.0", TruncateIP("203.0.113.77"))
+	assert.Equal(t, "203.0.113.0", TruncateIP("::ffff:203.0.113.77"))
+	assert.Equal(t, "2001:db8:1::", TruncateIP("2001:db8:1:2:3:4:5:6"))
+	assert.Equal(t, "not an ip", TruncateIP("not an ip"))
+
+	// 2. Raw addresses and their hashes are stored unless the retention
+	// period is zero, when truncation drops the hash as well
+	assert.Equal(t, "203.0.113.77", policy.StoredIP("203.0.113.77"))
+	assert.Equal(t, policy.HashIP("203.0.113.77"), policy.StoredIPHash("203.0.113.77"))
+	assert.True(t, policy.StoresAddresses())
+	policy.RetainFor = 0
+	assert.False(t, policy.StoresAddresses())
+	assert.Equal(t, "203.0.113.0", policy.StoredIP("203.0.113.77"))
+	assert.Empty(t, policy.StoredIPHash("203.0.113.77"))
+	policy.Anonymization = IPHash
+	assert.Equal(t, "", policy.StoredIP("203.0.113.77"))
+	assert.Equal(t, policy.HashIP("203.0.113.77"), policy.StoredIPHash("203.0.113.77"))
+	policy.Anonymization = IPKeep
+	assert.Equal(t, "203.0.113.77", policy.StoredIP("203.0.113.77"))
+
+	// 3. Hashes match the same address, or network, in any notation
+	assert.Equal(t, policy.HashIP("203.0.113.77"), policy.HashIP("::ffff:203.0.113.77"))
+	assert.NotEqual(t, policy.HashIP("203.0.113.77"), policy.HashIP("203.0.113.78"))
+	assert.Equal(t, policy.HashSubnet("203.0.113.77"), policy.HashSubnet("203.0.113.78"))
+	assert.NotEqual(t, policy.HashSubnet("203.0.113.77"), policy.HashSubnet("203.0.114.77"))
+	assert.Empty(t, policy.HashIP("not an ip"))
+
+	// 4. Hashes depend on the key, and none are made without one
+	other := IPRetentionPolicy{HashKey: []byte("other key")}
+	assert.NotEqual(t, policy.HashIP("203.0.113.77"), other.HashIP("203.0.113.77"))
+	unkeyed := IPRetentionPolicy{}
+	assert.Empty(t, unkeyed.HashIP("203.0.113.77"))
+	assert.Empty(t, unkeyed.HashSubnet("203.0.113.77"))
+}
@@ -6,13 +6,31 @@ import (
 	"github.com/google/uuid"
 )
 
+type PollStatus string
+
+const (
+	PollStatusOpen    PollStatus = "open"
+	PollStatusClosed  PollStatus = "closed"
+	PollStatusDeleted PollStatus = "deleted"
+)
+
+// Poll is a question users vote on. DeletedAt is set on polls hidden by a
+// moderator, which only the admin API returns. Votes on a poll with
+// RequireChallenge must come with a solved challenge, AllowAnonymous lets
+// people vote without an account and SecretBallot keeps who voted apart from
+// what they voted.
 type Poll struct {
-	ID          uuid.UUID    `json:"id"`
-	Title       string       `json:"title"`
-	Description string       `json:"description,omitempty"`
-	Options     []PollOption `json:"options"`
-	CreatedAt   time.Time    `json:"created_at"`
-	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	ID               uuid.UUID    `json:"id"`
+	Title            string       `json:"title"`
+	Description      string       `json:"description,omitempty"`
+	Options          []PollOption `json:"options"`
+	RequireChallenge bool         `json:"require_challenge"`
+	AllowAnonymous   bool         `json:"allow_anonymous"`
+	SecretBallot     bool         `json:"secret_ballot"`
+	CreatedBy        *uuid.UUID   `json:"created_by,omitempty"`
+	CreatedAt        time.Time    `json:"created_at"`
+	ExpiresAt        *time.Time   `json:"expires_at,omitempty"`
+	DeletedAt        *time.Time   `json:"deleted_at,omitempty"`
 }
 
 type PollOption struct {
@@ -26,3 +44,15 @@ type PollOptionStats struct {
 	VoteCount  int64
 	Percentage float64
 }
+
+type PollAnalytics struct {
+	PollID       uuid.UUID  `json:"poll_id"`
+	Title        string     `json:"title"`
+	Status       PollStatus `json:"status"`
+	TotalVotes   int64      `json:"total_votes"`
+	UniqueVoters int64      `json:"unique_voters"`
+	PendingVotes int64      `json:"pending_votes"`
+	LastVoteAt   *time.Time `json:"last_vote_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
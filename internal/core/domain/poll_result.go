@@ -12,3 +12,48 @@ type PollResult struct {
 	VoteCount     int64
 	LastUpdatedAt time.Time
 }
+
+type TimelineBucket string
+
+const (
+	TimelineBucketMinute TimelineBucket = "minute"
+	TimelineBucketHour   TimelineBucket = "hour"
+	TimelineBucketDay    TimelineBucket = "day"
+	TimelineBucketWeek   TimelineBucket = "week"
+)
+
+func (b TimelineBucket) Valid() bool {
+	switch b {
+	case TimelineBucketMinute, TimelineBucketHour, TimelineBucketDay, TimelineBucketWeek:
+		return true
+	}
+	return false
+}
+
+// PollTimelinePoint holds the votes an option gained during a bucket (Votes,
+// negative when retractions outnumber new votes) and its running total at the
+// end of that bucket (Total).
+type PollTimelinePoint struct {
+	BucketStart time.Time `json:"bucket_start"`
+	OptionID    uuid.UUID `json:"option_id"`
+	Votes       int64     `json:"votes"`
+	Total       int64     `json:"total"`
+}
+
+type OptionResult struct {
+	OptionID   uuid.UUID `json:"option_id"`
+	OptionText string    `json:"option_text"`
+	VoteCount  int64     `json:"vote_count"`
+	Percentage float64   `json:"percentage"`
+}
+
+// Ballot is an anonymized vote: the voter is only identified by a keyed hash
+// that is stable within a poll but cannot be linked back to the user. Secret
+// ballots have no voter hash and are only dated to the day they were cast,
+// as the exact time could be matched against when each participant voted.
+type Ballot struct {
+	OptionID   uuid.UUID `json:"option_id"`
+	OptionText string    `json:"option_text"`
+	VoterHash  string    `json:"voter_hash"`
+	CastAt     time.Time `json:"cast_at"`
+}
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimit is a token bucket holding up to Requests tokens that refills
+// completely over Per, so a client can burst Requests at once and then
+// sustain Requests per Per. A zero RateLimit does not limit anything.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// RefillRate is the number of tokens added back per second.
+func (l RateLimit) RefillRate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// RateLimitDecision is the outcome of taking a token from a bucket.
+type RateLimitDecision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait for the next token, zero when the
+	// request was allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+// NewRateLimitDecision describes a bucket left with tokens after the request
+// was allowed or denied.
+func NewRateLimitDecision(limit RateLimit, tokens float64, allowed bool) RateLimitDecision {
+	rate := limit.RefillRate()
+	decision := RateLimitDecision{
+		Allowed:    allowed,
+		Limit:      limit.Requests,
+		Remaining:  int(math.Max(0, math.Floor(tokens))),
+		ResetAfter: secondsToDuration((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		decision.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return decision
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
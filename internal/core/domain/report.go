@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReportReason string
+
+const (
+	ReportReasonSpam       ReportReason = "spam"
+	ReportReasonOffensive  ReportReason = "offensive"
+	ReportReasonMisleading ReportReason = "misleading"
+	ReportReasonIllegal    ReportReason = "illegal"
+	ReportReasonOther      ReportReason = "other"
+)
+
+func (r ReportReason) Valid() bool {
+	switch r {
+	case ReportReasonSpam, ReportReasonOffensive, ReportReasonMisleading, ReportReasonIllegal, ReportReasonOther:
+		return true
+	}
+	return false
+}
+
+type ReportStatus string
+
+const (
+	ReportStatusOpen      ReportStatus = "open"
+	ReportStatusDismissed ReportStatus = "dismissed"
+	ReportStatusActioned  ReportStatus = "actioned"
+)
+
+func (s ReportStatus) Valid() bool {
+	switch s {
+	case ReportStatusOpen, ReportStatusDismissed, ReportStatusActioned:
+		return true
+	}
+	return false
+}
+
+// Report flags a poll for moderators. Open reports count towards hiding the
+// poll from listings; a dismissed report no longer does, and actioning one
+// hides the poll altogether.
+type Report struct {
+	ID         uuid.UUID    `json:"id"`
+	PollID     uuid.UUID    `json:"poll_id"`
+	ReporterID uuid.UUID    `json:"reporter_id"`
+	Reason     ReportReason `json:"reason"`
+	Details    string       `json:"details,omitempty"`
+	Status     ReportStatus `json:"status"`
+	ResolvedBy *uuid.UUID   `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time   `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
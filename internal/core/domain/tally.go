@@ -0,0 +1,202 @@
+package domain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VoteReceipt is handed to whoever casts a vote. Receipt is the SHA-256 of
+// the poll, the option and Nonce, so it commits to the ballot without
+// revealing it, and only a hash of it is stored. Voters keep the whole
+// receipt to check their vote was counted; on secret ballot polls it is also
+// the one way back to the ballot, to read, change or retract it.
+type VoteReceipt struct {
+	PollID   uuid.UUID `json:"poll_id"`
+	OptionID uuid.UUID `json:"option_id"`
+	Nonce    string    `json:"nonce"`
+	Receipt  string    `json:"receipt"`
+}
+
+func NewVoteReceipt(pollID, optionID uuid.UUID, nonce []byte) *VoteReceipt {
+	return &VoteReceipt{
+		PollID:   pollID,
+		OptionID: optionID,
+		Nonce:    hex.EncodeToString(nonce),
+		Receipt:  commitBallot(pollID, optionID, nonce),
+	}
+}
+
+// Valid reports whether Receipt commits to the receipt's poll and option.
+func (r *VoteReceipt) Valid() bool {
+	nonce, err := hex.DecodeString(r.Nonce)
+	if err != nil {
+		return false
+	}
+	return r.Receipt == commitBallot(r.PollID, r.OptionID, nonce)
+}
+
+func commitBallot(pollID, optionID uuid.UUID, nonce []byte) string {
+	h := sha256.New()
+	h.Write(pollID[:])
+	h.Write(optionID[:])
+	h.Write(nonce)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ReceiptHash is what is stored of a receipt, so the votes table alone
+// does not hold anything that reads, changes or retracts a secret ballot.
+func ReceiptHash(receipt string) string {
+	sum := sha256.Sum256([]byte(receipt))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidReceiptHash reports whether s has the form of a ReceiptHash,
+// in either case.
+func ValidReceiptHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// BallotOwner ties a secret ballot to the user who cast it. It takes the
+// receipt to compute, so it does not tell whose ballot a row is to anyone
+// without it, yet a receipt passed to another user is of no use to them.
+func BallotOwner(receipt string, userID uuid.UUID) string {
+	h := sha256.New()
+	h.Write([]byte(receipt))
+	h.Write(userID[:])
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Tally is the published Merkle root over the counted votes of a poll. Its
+// leaves are TallyLeaf of each vote. Each run counting votes appends its own
+// ordered by receipt hash, and a retracted vote's leaf is replaced by the
+// last one, so a position tells at most which run counted a vote.
+type Tally struct {
+	PollID     uuid.UUID `json:"poll_id"`
+	MerkleRoot string    `json:"merkle_root"`
+	Ballots    int       `json:"ballots"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// TallyProof shows that the vote with a given receipt is a leaf of the tree
+// whose root is MerkleRoot. Path lists the sibling hashes from the leaf up.
+type TallyProof struct {
+	PollID     uuid.UUID `json:"poll_id"`
+	OptionID   uuid.UUID `json:"option_id"`
+	LeafIndex  int       `json:"leaf_index"`
+	Ballots    int       `json:"ballots"`
+	Path       []string  `json:"path"`
+	MerkleRoot string    `json:"merkle_root"`
+}
+
+// TallyLeaf hashes a counted vote into the tally tree. Leaves and inner
+// nodes are hashed with different prefixes, as in RFC 6962, so one cannot
+// pass for the other. The option is part of the leaf, so a proof also shows
+// which option the vote was counted for.
+func TallyLeaf(receiptHash string, optionID uuid.UUID) ([]byte, error) {
+	b, err := hex.DecodeString(receiptHash)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(b)
+	h.Write(optionID[:])
+	return h.Sum(nil), nil
+}
+
+// TallyNode hashes two sibling nodes into their parent.
+func TallyNode(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// nextLevel pairs up the nodes of a level. The last node of an odd level
+// moves up unchanged.
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, TallyNode(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+// MerkleRoot returns the root of the tree over leaves, in hex. The root of
+// an empty tree is the hash of nothing.
+func MerkleRoot(leaves [][]byte) string {
+	if len(leaves) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// MerklePath returns the sibling hashes from the leaf at index up to the
+// root, skipping the levels where the node has no sibling.
+func MerklePath(leaves [][]byte, index int) []string {
+	path := []string{}
+	level := leaves
+	for len(level) > 1 {
+		if sibling := index ^ 1; sibling < len(level) {
+			path = append(path, hex.EncodeToString(level[sibling]))
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return path
+}
+
+// Verify reports whether the proof leads from the vote with the given
+// receipt hash up to MerkleRoot.
+func (p *TallyProof) Verify(receiptHash string) bool {
+	if p.LeafIndex < 0 || p.LeafIndex >= p.Ballots {
+		return false
+	}
+	node, err := TallyLeaf(receiptHash, p.OptionID)
+	if err != nil {
+		return false
+	}
+
+	path := p.Path
+	index, count := p.LeafIndex, p.Ballots
+	for count > 1 {
+		if index%2 == 1 || index+1 < count {
+			if len(path) == 0 {
+				return false
+			}
+			sibling, err := hex.DecodeString(path[0])
+			if err != nil {
+				return false
+			}
+			path = path[1:]
+			if index%2 == 1 {
+				node = TallyNode(sibling, node)
+			} else {
+				node = TallyNode(node, sibling)
+			}
+		}
+		index /= 2
+		count = (count + 1) / 2
+	}
+
+	root, err := hex.DecodeString(p.MerkleRoot)
+	if err != nil {
+		return false
+	}
+	return len(path) == 0 && bytes.Equal(node, root)
+}
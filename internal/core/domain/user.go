@@ -1,24 +1,156 @@
 package domain
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Role grants access to moderation and administration. Each role includes
+// the permissions of the ones before it: user, moderator, admin.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// Includes reports whether r grants at least the permissions of other.
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && r.rank() >= other.rank()
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleUser:
+		return 1
+	case RoleModerator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
 type User struct {
 	ID        uuid.UUID  `json:"id"`
 	Email     string     `json:"email"`
 	Name      string     `json:"name"`
+	Role      Role       `json:"role"`
 	CreatedAt time.Time  `json:"created_at"`
 	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+// NormalizeEmail is the form emails are stored and looked up in, whichever
+// way they reached the server.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// UserIdentity is an account at a login provider, identified by the
+// provider's stable subject rather than by email. A user can have several.
+type UserIdentity struct {
+	ID            uuid.UUID `json:"id"`
+	UserID        uuid.UUID `json:"user_id"`
+	Provider      string    `json:"provider"`
+	Subject       string    `json:"subject"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// RefreshToken is one link of a rotation chain. Every token issued from the
+// same login shares its FamilyID, and ReplacedBy points to the token that
+// superseded it on refresh.
 type RefreshToken struct {
-	ID        uuid.UUID `json:"id"`
-	UserID    uuid.UUID `json:"user_id"`
-	TokenHash string    `json:"-"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Revoked   bool      `json:"revoked"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	FamilyID   uuid.UUID  `json:"family_id"`
+	TokenHash  string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Revoked    bool       `json:"revoked"`
+	ReplacedBy *uuid.UUID `json:"replaced_by,omitempty"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// EmailLoginToken backs a magic link. Only the hash of the token sent by
+// email is stored, and UsedAt makes the link single-use.
+type EmailLoginToken struct {
+	ID        uuid.UUID  `json:"id"`
+	Email     string     `json:"email"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// APITokenScope is a permission granted to a personal API token.
+type APITokenScope string
+
+const (
+	ScopePollsWrite  APITokenScope = "polls:write"
+	ScopeVotesWrite  APITokenScope = "votes:write"
+	ScopeResultsRead APITokenScope = "results:read"
+)
+
+func (s APITokenScope) Valid() bool {
+	switch s {
+	case ScopePollsWrite, ScopeVotesWrite, ScopeResultsRead:
+		return true
+	}
+	return false
+}
+
+// APITokenPrefix starts every personal API token, which tells them apart
+// from access tokens and makes leaked ones easy to scan for.
+const APITokenPrefix = "poll_pat_"
+
+// APIToken is a long-lived personal access token used by bots and scripts.
+// Like refresh tokens, only its hash is stored.
+type APIToken struct {
+	ID         uuid.UUID       `json:"id"`
+	UserID     uuid.UUID       `json:"user_id"`
+	Name       string          `json:"name"`
+	TokenHash  string          `json:"-"`
+	Scopes     []APITokenScope `json:"scopes"`
+	ExpiresAt  *time.Time      `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time      `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *APIToken) HasScope(scope APITokenScope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientInfo describes the client a refresh token is issued to.
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
+
+// Session is a login as seen by the user. It is identified by the refresh
+// token family, so it keeps its ID across rotations; LastUsedAt, UserAgent
+// and IPAddress come from the most recent refresh.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
 }
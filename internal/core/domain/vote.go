@@ -6,11 +6,64 @@ import (
 	"github.com/google/uuid"
 )
 
+// Vote is cast either by a user or, on polls that allow anonymous votes, by
+// a device. On secret ballot polls it is a ballot instead, which records
+// neither voter nor address and is only found by its ReceiptHash.
 type Vote struct {
-	ID        uuid.UUID `json:"id"`
-	PollID    uuid.UUID `json:"poll_id"`
-	OptionID  uuid.UUID `json:"option_id"`
-	UserID    uuid.UUID `json:"user_id"`
-	VoterIP   string    `json:"voter_ip"`
-	CreatedAt time.Time `json:"created_at"`
+	ID          uuid.UUID  `json:"id"`
+	PollID      uuid.UUID  `json:"poll_id"`
+	OptionID    uuid.UUID  `json:"option_id"`
+	UserID      *uuid.UUID `json:"user_id,omitempty"`
+	DeviceID    *uuid.UUID `json:"device_id,omitempty"`
+	ReceiptHash string     `json:"-"`
+	// OwnerHash is BallotOwner of a secret ballot, empty otherwise.
+	OwnerHash string `json:"-"`
+	VoterIP   string `json:"voter_ip,omitempty"`
+	// VoterIPHash and VoterSubnetHash outlive VoterIP, see
+	// IPRetentionPolicy.
+	VoterIPHash     string    `json:"-"`
+	VoterSubnetHash string    `json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Secret reports whether the vote is a secret ballot.
+func (v *Vote) Secret() bool {
+	return v.UserID == nil && v.DeviceID == nil
+}
+
+// VoterID returns the user or device that cast the vote. Both are random
+// UUIDs, so they do not collide. It is uuid.Nil for secret ballots.
+func (v *Vote) VoterID() uuid.UUID {
+	if v.UserID != nil {
+		return *v.UserID
+	}
+	if v.DeviceID != nil {
+		return *v.DeviceID
+	}
+	return uuid.Nil
+}
+
+// Voter identifies who is voting: a signed-in user, or the device of an
+// anonymous voter as recorded in its signed device cookie.
+type Voter struct {
+	UserID   *uuid.UUID
+	DeviceID *uuid.UUID
+}
+
+func UserVoter(userID uuid.UUID) Voter {
+	return Voter{UserID: &userID}
+}
+
+func DeviceVoter(deviceID uuid.UUID) Voter {
+	return Voter{DeviceID: &deviceID}
+}
+
+// Anonymous reports whether the voter is not signed in.
+func (v Voter) Anonymous() bool {
+	return v.UserID == nil
+}
+
+// Valid reports whether exactly one of UserID and DeviceID is set.
+func (v Voter) Valid() bool {
+	return (v.UserID == nil) != (v.DeviceID == nil)
 }
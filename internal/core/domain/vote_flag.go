@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VoteFlagRule names the anomaly a vote was flagged for.
+type VoteFlagRule string
+
+const (
+	// VoteFlagBurst marks votes cast on one option in a sudden burst.
+	VoteFlagBurst VoteFlagRule = "burst"
+	// VoteFlagSharedIP marks votes on a poll from an address many accounts
+	// voted from.
+	VoteFlagSharedIP VoteFlagRule = "shared_ip"
+	// VoteFlagSharedSubnet is VoteFlagSharedIP over a /24 or /48 network.
+	VoteFlagSharedSubnet VoteFlagRule = "shared_subnet"
+	// VoteFlagNewAccount marks votes cast right after the account was made.
+	VoteFlagNewAccount VoteFlagRule = "new_account"
+	// VoteFlagFlipping marks the latest vote of a user who kept changing
+	// their vote on a poll.
+	VoteFlagFlipping VoteFlagRule = "flipping"
+)
+
+var VoteFlagRules = []VoteFlagRule{VoteFlagBurst, VoteFlagSharedIP, VoteFlagSharedSubnet, VoteFlagNewAccount, VoteFlagFlipping}
+
+type VoteFlagStatus string
+
+const (
+	VoteFlagStatusOpen        VoteFlagStatus = "open"
+	VoteFlagStatusDismissed   VoteFlagStatus = "dismissed"
+	VoteFlagStatusInvalidated VoteFlagStatus = "invalidated"
+)
+
+func (s VoteFlagStatus) Valid() bool {
+	switch s {
+	case VoteFlagStatusOpen, VoteFlagStatusDismissed, VoteFlagStatusInvalidated:
+		return true
+	}
+	return false
+}
+
+// VoteFlag is a vote the analysis job found suspicious, waiting for an
+// admin to dismiss it or invalidate the vote. Details holds the figures
+// that triggered the rule.
+type VoteFlag struct {
+	ID         uuid.UUID      `json:"id"`
+	VoteID     uuid.UUID      `json:"vote_id"`
+	PollID     uuid.UUID      `json:"poll_id"`
+	UserID     *uuid.UUID     `json:"user_id,omitempty"`
+	DeviceID   *uuid.UUID     `json:"device_id,omitempty"`
+	VoterIP    string         `json:"voter_ip,omitempty"`
+	Rule       VoteFlagRule   `json:"rule"`
+	Details    map[string]any `json:"details,omitempty"`
+	Status     VoteFlagStatus `json:"status"`
+	ResolvedBy *uuid.UUID     `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time     `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// VoteAnalysisConfig holds the thresholds of each rule. A rule whose
+// threshold is zero is skipped.
+type VoteAnalysisConfig struct {
+	// BurstVotes votes on one option within BurstWindow make a burst.
+	BurstVotes  int
+	BurstWindow time.Duration
+	// AccountsPerIP and AccountsPerSubnet are how many accounts may vote on
+	// a poll from one address or network before their votes are flagged.
+	// Each anonymous device counts as an account.
+	AccountsPerIP     int
+	AccountsPerSubnet int
+	// NewAccountAge flags votes cast sooner than this after signing up.
+	NewAccountAge time.Duration
+	// FlipVotes votes by one user on a poll within FlipWindow, retracted
+	// ones included, count as flipping.
+	FlipVotes  int
+	FlipWindow time.Duration
+}
+
+func DefaultVoteAnalysisConfig() VoteAnalysisConfig {
+	return VoteAnalysisConfig{
+		BurstVotes:        50,
+		BurstWindow:       5 * time.Minute,
+		AccountsPerIP:     5,
+		AccountsPerSubnet: 20,
+		NewAccountAge:     10 * time.Minute,
+		FlipVotes:         5,
+		FlipWindow:        time.Hour,
+	}
+}
+
+// Enabled reports whether the thresholds of rule are set.
+func (c VoteAnalysisConfig) Enabled(rule VoteFlagRule) bool {
+	switch rule {
+	case VoteFlagBurst:
+		return c.BurstVotes > 0 && c.BurstWindow > 0
+	case VoteFlagSharedIP:
+		return c.AccountsPerIP > 0
+	case VoteFlagSharedSubnet:
+		return c.AccountsPerSubnet > 0
+	case VoteFlagNewAccount:
+		return c.NewAccountAge > 0
+	case VoteFlagFlipping:
+		return c.FlipVotes > 0 && c.FlipWindow > 0
+	}
+	return false
+}
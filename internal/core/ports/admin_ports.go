@@ -0,0 +1,49 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+type AuditRepository interface {
+	Append(ctx context.Context, entry *domain.AuditEntry) error
+	List(ctx context.Context, limit, offset int) ([]*domain.AuditEntry, error)
+}
+
+// Transactor runs fn in a single transaction, which the repository calls
+// made with the context passed to fn take part in.
+type Transactor interface {
+	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// ModerationInput identifies who takes an admin action, and why.
+type ModerationInput struct {
+	ActorID uuid.UUID
+	Reason  string
+}
+
+// AdminService backs the admin API. Every action taking a ModerationInput
+// is recorded in the audit log, in the same transaction as the action.
+type AdminService interface {
+	ListPolls(ctx context.Context, input ListPollsInput) ([]*domain.Poll, error)
+	HidePoll(ctx context.Context, pollID string, input ModerationInput) error
+	RestorePoll(ctx context.Context, pollID string, input ModerationInput) error
+	// BanUser also ends every session of the user.
+	BanUser(ctx context.Context, userID string, input ModerationInput) error
+	UnbanUser(ctx context.Context, userID string, input ModerationInput) error
+	InvalidateUserVotes(ctx context.Context, userID string, input ModerationInput) (int64, error)
+	ListRefreshTokens(ctx context.Context, userID string, input ModerationInput) ([]*domain.RefreshToken, error)
+	ListAuditLog(ctx context.Context, page int) ([]*domain.AuditEntry, error)
+	ListReports(ctx context.Context, status domain.ReportStatus, page int) ([]*domain.Report, error)
+	DismissReport(ctx context.Context, reportID string, input ModerationInput) error
+	// ActionReport hides the reported poll and resolves every open report
+	// on it.
+	ActionReport(ctx context.Context, reportID string, input ModerationInput) error
+	ListVoteFlags(ctx context.Context, status domain.VoteFlagStatus, page int) ([]*domain.VoteFlag, error)
+	DismissVoteFlag(ctx context.Context, flagID string, input ModerationInput) error
+	// InvalidateFlaggedVote retracts the flagged vote and resolves every
+	// open flag on it.
+	InvalidateFlaggedVote(ctx context.Context, flagID string, input ModerationInput) error
+}
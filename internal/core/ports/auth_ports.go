@@ -2,7 +2,9 @@ package ports
 
 import (
 	"context"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
 )
 
@@ -10,19 +12,106 @@ type TokenVerifier interface {
 	Verify(ctx context.Context, token string, clientID string) (*TokenPayload, error)
 }
 
+// TokenPayload is the profile a provider vouches for. Subject is the
+// provider's stable user ID, which unlike the email never changes hands.
 type TokenPayload struct {
-	Email string
-	Name  string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// AuthCodeFlow is implemented by providers users log in with through the
+// authorization-code flow with PKCE.
+type AuthCodeFlow interface {
+	AuthCodeURL(ctx context.Context, state, codeChallenge, redirectURI string) (string, error)
+	// Exchange redeems code and returns the profile of the user who granted
+	// it.
+	Exchange(ctx context.Context, code, codeVerifier, redirectURI string) (*TokenPayload, error)
+}
+
+// LoginProvider is an identity provider users can log in with. Providers
+// issuing ID tokens set Verifier, with ClientID the audience those tokens
+// must be issued to; the others set AuthCode.
+type LoginProvider struct {
+	Name     string
+	ClientID string
+	Verifier TokenVerifier
+	AuthCode AuthCodeFlow
+}
+
+type ProviderRegistry interface {
+	Get(name string) (*LoginProvider, bool)
+}
+
+// TokenSigner signs the claims of the access tokens handed out on login.
+type TokenSigner interface {
+	Sign(claims map[string]any) (string, error)
 }
 
 type AuthRepository interface {
 	StoreRefreshToken(ctx context.Context, token *domain.RefreshToken) error
 	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (*domain.RefreshToken, error)
 	RevokeRefreshToken(ctx context.Context, id string) error
+	// RotateRefreshToken revokes the token identified by oldID and stores
+	// next as its replacement. It returns domain.ErrRefreshTokenRevoked when
+	// oldID was already revoked, e.g. by a concurrent refresh.
+	RotateRefreshToken(ctx context.Context, oldID string, next *domain.RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	// ListSessions returns the active token of every session the user still
+	// has, one per refresh token family.
+	ListSessions(ctx context.Context, userID string) ([]*domain.Session, error)
+	// RevokeSession returns domain.ErrSessionNotFound when the user has no
+	// active session with that ID.
+	RevokeSession(ctx context.Context, userID string, sessionID string) error
+	RevokeAllSessions(ctx context.Context, userID string) error
+	// ListRefreshTokens returns every refresh token of the user, revoked
+	// ones included, newest first.
+	ListRefreshTokens(ctx context.Context, userID string) ([]*domain.RefreshToken, error)
+	StoreEmailLoginToken(ctx context.Context, token *domain.EmailLoginToken) error
+	// ConsumeEmailLoginToken marks the token as used and returns it, or nil
+	// when it does not exist, expired or was already used.
+	ConsumeEmailLoginToken(ctx context.Context, tokenHash string) (*domain.EmailLoginToken, error)
+	StoreAPIToken(ctx context.Context, token *domain.APIToken) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*domain.APIToken, error)
+	ListAPITokens(ctx context.Context, userID string) ([]*domain.APIToken, error)
+	// DeleteAPIToken returns domain.ErrAPITokenNotFound when the user has no
+	// token with that ID.
+	DeleteAPIToken(ctx context.Context, userID, tokenID string) error
+	TouchAPIToken(ctx context.Context, id string) error
+}
+
+type CreateAPITokenInput struct {
+	Name      string
+	Scopes    []domain.APITokenScope
+	ExpiresAt *time.Time
+}
+
+// APITokenAuthenticator resolves the personal API token sent by a client.
+// It returns domain.ErrInvalidAPIToken when the token is unknown or expired.
+type APITokenAuthenticator interface {
+	AuthenticateAPIToken(ctx context.Context, token string) (*domain.APIToken, error)
 }
 
 type AuthService interface {
-	LoginWithGoogle(ctx context.Context, googleToken string) (string, string, error)
-	RefreshAccessToken(ctx context.Context, refreshToken string) (string, string, error)
+	LoginWithIDToken(ctx context.Context, provider string, idToken string, client domain.ClientInfo) (string, string, error)
+	AuthCodeURL(ctx context.Context, provider, state, codeChallenge, redirectURI string) (string, error)
+	LoginWithAuthCode(ctx context.Context, provider, code, codeVerifier, redirectURI string, client domain.ClientInfo) (string, string, error)
+	StartEmailLogin(ctx context.Context, email string, verifyURL string) error
+	LoginWithEmailLink(ctx context.Context, token string, client domain.ClientInfo) (string, string, error)
+	LinkWithIDToken(ctx context.Context, userID uuid.UUID, provider string, idToken string) (*domain.UserIdentity, error)
+	LinkWithAuthCode(ctx context.Context, userID uuid.UUID, provider, code, codeVerifier, redirectURI string) (*domain.UserIdentity, error)
+	ListIdentities(ctx context.Context, userID uuid.UUID) ([]*domain.UserIdentity, error)
+	UnlinkIdentity(ctx context.Context, userID uuid.UUID, identityID string) error
+	RefreshAccessToken(ctx context.Context, refreshToken string, client domain.ClientInfo) (string, string, error)
 	Logout(ctx context.Context, refreshToken string) error
+	ListSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) ([]*domain.Session, error)
+	RevokeSession(ctx context.Context, userID uuid.UUID, sessionID string) error
+	RevokeAllSessions(ctx context.Context, userID uuid.UUID) error
+	// CreateAPIToken returns the token along with its secret, which is only
+	// ever available at creation.
+	CreateAPIToken(ctx context.Context, userID uuid.UUID, input CreateAPITokenInput) (*domain.APIToken, string, error)
+	ListAPITokens(ctx context.Context, userID uuid.UUID) ([]*domain.APIToken, error)
+	RevokeAPIToken(ctx context.Context, userID uuid.UUID, tokenID string) error
+	APITokenAuthenticator
 }
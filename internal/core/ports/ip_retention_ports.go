@@ -0,0 +1,26 @@
+package ports
+
+import (
+	"context"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+type IPRetentionRepository interface {
+	// ListUnhashedVoterIPs returns up to limit votes, with only their id and
+	// address, that were stored before votes recorded the hashes of their
+	// address. Votes keeping only their network's hash count as hashed.
+	ListUnhashedVoterIPs(ctx context.Context, limit int) ([]*domain.Vote, error)
+	SetVoterIPHashes(ctx context.Context, votes []*domain.Vote) error
+	// AnonymizeVoterIPs anonymizes the stored address of the hashed votes
+	// cast before the given time, and of their flags. It returns how many
+	// votes it changed.
+	AnonymizeVoterIPs(ctx context.Context, anonymization domain.IPAnonymization, before time.Time) (int64, error)
+}
+
+// IPRetentionService applies the IP retention policy to the votes already
+// stored, on a schedule.
+type IPRetentionService interface {
+	Apply(ctx context.Context, now time.Time) (*domain.IPRetentionResult, error)
+}
@@ -9,16 +9,56 @@ import (
 
 type PollRepository interface {
 	Save(ctx context.Context, poll *domain.Poll) error
+	SaveBatch(ctx context.Context, polls []*domain.Poll) error
 	GetByID(ctx context.Context, id uuid.UUID) (*domain.Poll, error)
 	GetAll(ctx context.Context) ([]*domain.Poll, error)
-	List(ctx context.Context, limit, offset int) ([]*domain.Poll, error)
-	Search(ctx context.Context, limit, offset int, query string) ([]*domain.Poll, error)
+	// List and Search leave out polls with reportThreshold or more open
+	// reports. A reportThreshold of 0 disables the filter.
+	List(ctx context.Context, limit, offset, reportThreshold int) ([]*domain.Poll, error)
+	Search(ctx context.Context, limit, offset, reportThreshold int, query string) ([]*domain.Poll, error)
+	ListAnalyticsByCreator(ctx context.Context, creatorID uuid.UUID) ([]*domain.PollAnalytics, error)
+	// ListAll returns hidden polls too, newest first. An empty query
+	// matches every poll.
+	ListAll(ctx context.Context, limit, offset int, query string) ([]*domain.Poll, error)
+	// SetHidden returns domain.ErrPollNotFound when there is no such poll.
+	SetHidden(ctx context.Context, id uuid.UUID, hidden bool) error
 }
 
 type CreatePollInput struct {
-	Title       string
-	Description string
-	Options     []string
+	Title            string
+	Description      string
+	Options          []string
+	RequireChallenge bool
+	AllowAnonymous   bool
+	SecretBallot     bool
+	CreatedBy        *uuid.UUID `swaggerignore:"true"`
+}
+
+// ContentFilter screens what users write in a poll before it is stored.
+// Rejected content is reported as a *domain.ValidationError naming each
+// offending field; any other error aborts the request.
+type ContentFilter interface {
+	Check(ctx context.Context, input CreatePollInput) error
+}
+
+// ImportPollRow is a poll read from an import file. Row is the position in
+// the source file and Err is set when the row could not be parsed.
+type ImportPollRow struct {
+	Row   int
+	Input CreatePollInput
+	Err   error
+}
+
+type ImportRowResult struct {
+	Row    int        `json:"row"`
+	PollID *uuid.UUID `json:"poll_id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
 }
 
 type ListPollsInput struct {
@@ -28,7 +68,16 @@ type ListPollsInput struct {
 
 type PollService interface {
 	Create(ctx context.Context, input CreatePollInput) (*domain.Poll, error)
+	Import(ctx context.Context, rows []ImportPollRow) (*ImportReport, error)
 	GetPoll(ctx context.Context, id string) (*domain.Poll, error)
 	ListPolls(ctx context.Context, input ListPollsInput) ([]*domain.Poll, error)
-	GetPollStats(ctx context.Context, pollID string, userID uuid.UUID) (map[uuid.UUID]domain.PollOptionStats, error)
+	GetPollStats(ctx context.Context, pollID string, voter domain.Voter) (map[uuid.UUID]domain.PollOptionStats, error)
+	GetPollTimeline(ctx context.Context, pollID string, userID uuid.UUID, bucket domain.TimelineBucket) ([]domain.PollTimelinePoint, error)
+	// GetTally and GetTallyProof are public: the tally reveals no vote, and
+	// a proof is only found through the ReceiptHash of the vote it is
+	// about, which voters compute from their receipt so it never leaves
+	// their hands.
+	GetTally(ctx context.Context, pollID string) (*domain.Tally, error)
+	GetTallyProof(ctx context.Context, pollID string, receiptHash string) (*domain.TallyProof, error)
+	ListCreatedPolls(ctx context.Context, userID uuid.UUID) ([]*domain.PollAnalytics, error)
 }
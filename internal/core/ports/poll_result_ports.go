@@ -8,11 +8,30 @@ import (
 )
 
 type PollResultRepository interface {
+	// ProcessVotes also publishes the tally of the votes it leaves counted.
 	ProcessVotes(ctx context.Context, pollID uuid.UUID) error
 	GetPollsWithUnprocessedVotes(ctx context.Context) ([]uuid.UUID, error)
 	GetPollOptionStats(ctx context.Context, pollID uuid.UUID) (map[uuid.UUID]domain.PollOptionStats, error)
+	GetPollTimeline(ctx context.Context, pollID uuid.UUID, bucket domain.TimelineBucket) ([]domain.PollTimelinePoint, error)
+	// GetTally returns domain.ErrTallyNotPublished before the poll's votes
+	// were first processed.
+	GetTally(ctx context.Context, pollID uuid.UUID) (*domain.Tally, error)
+	// GetTallyProof returns domain.ErrReceiptNotCounted when no counted
+	// vote has the receipt hash.
+	GetTallyProof(ctx context.Context, pollID uuid.UUID, receiptHash string) (*domain.TallyProof, error)
 }
 
 type SummaryService interface {
 	SummarizeAllVotes(ctx context.Context) error
 }
+
+// ExportWriter receives the records of a poll export one at a time, so the
+// adapter can stream them out without holding the whole export in memory.
+type ExportWriter interface {
+	WriteResult(result domain.OptionResult) error
+	WriteBallot(ballot domain.Ballot) error
+}
+
+type ExportService interface {
+	Export(ctx context.Context, pollID string, userID uuid.UUID, w ExportWriter) error
+}
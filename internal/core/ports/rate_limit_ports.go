@@ -0,0 +1,14 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+// RateLimitStore keeps token buckets. Take refills the bucket named key for
+// the time elapsed since it was last used and removes a token if one is
+// left. A key that was never seen starts with a full bucket.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit domain.RateLimit) (domain.RateLimitDecision, error)
+}
@@ -0,0 +1,32 @@
+package ports
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+type ReportRepository interface {
+	// Create returns domain.ErrAlreadyReported when the reporter already
+	// reported the poll.
+	Create(ctx context.Context, report *domain.Report) error
+	GetByID(ctx context.Context, id uuid.UUID) (*domain.Report, error)
+	// List returns the reports with the given status, oldest first.
+	List(ctx context.Context, status domain.ReportStatus, limit, offset int) ([]*domain.Report, error)
+	// Resolve returns domain.ErrReportResolved when the report is no longer
+	// open.
+	Resolve(ctx context.Context, id uuid.UUID, status domain.ReportStatus, resolvedBy uuid.UUID) error
+	ResolveOpenForPoll(ctx context.Context, pollID uuid.UUID, status domain.ReportStatus, resolvedBy uuid.UUID) (int64, error)
+}
+
+type ReportPollInput struct {
+	PollID     string
+	ReporterID uuid.UUID
+	Reason     domain.ReportReason
+	Details    string
+}
+
+type ReportService interface {
+	ReportPoll(ctx context.Context, input ReportPollInput) (*domain.Report, error)
+}
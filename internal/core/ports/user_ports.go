@@ -11,8 +11,28 @@ type UserRepository interface {
 	GetByEmail(ctx context.Context, email string) (*domain.User, error)
 	GetByID(ctx context.Context, id string) (*domain.User, error)
 	Create(ctx context.Context, user *domain.User) error
+	// SetRole returns domain.ErrUserNotFound when there is no such user.
+	SetRole(ctx context.Context, id string, role domain.Role) error
+	// SetBanned returns domain.ErrUserNotFound when there is no such user.
+	// Banned users are left out by every other lookup.
+	SetBanned(ctx context.Context, id string, banned bool) error
+	// IsBanned reports whether the user with that email is banned.
+	IsBanned(ctx context.Context, email string) (bool, error)
+	// CreateWithIdentity creates the user and its first identity together.
+	CreateWithIdentity(ctx context.Context, user *domain.User, identity *domain.UserIdentity) error
+	GetIdentity(ctx context.Context, provider, subject string) (*domain.UserIdentity, error)
+	ListIdentities(ctx context.Context, userID string) ([]*domain.UserIdentity, error)
+	// CreateIdentity returns domain.ErrIdentityLinked when the provider
+	// subject already belongs to a user.
+	CreateIdentity(ctx context.Context, identity *domain.UserIdentity) error
+	// DeleteIdentity returns domain.ErrLastIdentity rather than leave the
+	// user without any identity.
+	DeleteIdentity(ctx context.Context, userID, identityID string) error
 }
 
 type UserService interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error)
+	// SetRoleByEmail gives the user with that email the role and returns
+	// the updated user.
+	SetRoleByEmail(ctx context.Context, email string, role domain.Role) (*domain.User, error)
 }
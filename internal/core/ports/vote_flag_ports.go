@@ -0,0 +1,31 @@
+package ports
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+type VoteFlagRepository interface {
+	// FlagVotes flags the active votes cast since the given time that match
+	// rule, skipping votes already flagged for it. It returns how many flags
+	// were added.
+	FlagVotes(ctx context.Context, rule domain.VoteFlagRule, since time.Time, config domain.VoteAnalysisConfig) (int64, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*domain.VoteFlag, error)
+	// List returns the flags with the given status, oldest first.
+	List(ctx context.Context, status domain.VoteFlagStatus, limit, offset int) ([]*domain.VoteFlag, error)
+	// Resolve returns domain.ErrVoteFlagResolved when the flag is no longer
+	// open.
+	Resolve(ctx context.Context, id uuid.UUID, status domain.VoteFlagStatus, resolvedBy uuid.UUID) error
+	ResolveOpenForVote(ctx context.Context, voteID uuid.UUID, status domain.VoteFlagStatus, resolvedBy uuid.UUID) (int64, error)
+}
+
+// VoteAnalysisService looks for brigading in recent votes and flags what it
+// finds for admins to review.
+type VoteAnalysisService interface {
+	// Analyze runs every enabled rule over the votes cast since the given
+	// time and returns how many new flags each rule raised.
+	Analyze(ctx context.Context, since time.Time) (map[domain.VoteFlagRule]int64, error)
+}
@@ -2,6 +2,7 @@ package ports
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
@@ -9,20 +10,86 @@ import (
 
 type VoteRepository interface {
 	SaveVote(ctx context.Context, vote *domain.Vote) error
-	DeleteVote(ctx context.Context, pollID, userID uuid.UUID) error
-	HasVoted(ctx context.Context, pollID, userID uuid.UUID) (bool, error)
-	HasVotedOnOption(ctx context.Context, optionID, userID uuid.UUID) (bool, error)
-	GetVote(ctx context.Context, pollID, userID uuid.UUID) (*domain.Vote, error)
+	// DeleteVote retracts the voter's vote. It returns
+	// domain.ErrVoteInvalidated when an admin invalidated it instead.
+	DeleteVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter) error
+	// HasVoted also reports users who took part in a secret ballot.
+	HasVoted(ctx context.Context, pollID uuid.UUID, voter domain.Voter) (bool, error)
+	HasVotedOnOption(ctx context.Context, optionID uuid.UUID, voter domain.Voter) (bool, error)
+	GetVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter) (*domain.Vote, error)
+	// CountAnonymousVotes counts the active anonymous votes on the poll cast
+	// from the address with the hash.
+	CountAnonymousVotes(ctx context.Context, pollID uuid.UUID, voterIPHash string) (int, error)
+	// LockAnonymousVotes locks the anonymous votes on the poll from the
+	// address with the hash until the transaction it runs in ends.
+	LockAnonymousVotes(ctx context.Context, pollID uuid.UUID, voterIPHash string) error
+	// SaveBallot records that the user took part in the poll and stores
+	// their secret ballot. It returns domain.ErrAlreadyVoted when the user
+	// already took part.
+	SaveBallot(ctx context.Context, ballot *domain.Vote, userID uuid.UUID) error
+	// GetBallot returns domain.ErrInvalidReceipt when no current secret
+	// ballot of the poll has the receipt hash.
+	GetBallot(ctx context.Context, pollID uuid.UUID, receiptHash string) (*domain.Vote, error)
+	// ReplaceBallot retracts the ballot with the receipt hash and stores
+	// ballot in its place.
+	ReplaceBallot(ctx context.Context, receiptHash string, ballot *domain.Vote) error
+	// RetractBallot retracts the ballot with the receipt hash and forgets
+	// that the user took part, so they may vote again.
+	RetractBallot(ctx context.Context, pollID uuid.UUID, receiptHash string, userID uuid.UUID) error
+	// StreamVotes calls fn with the active votes of the poll in the order
+	// they were cast. Secret ballots are only ordered by day, and by receipt
+	// hash within one, so their order does not follow the participants'.
+	StreamVotes(ctx context.Context, pollID uuid.UUID, fn func(*domain.Vote) error) error
+	// InvalidateUserVotes invalidates every active vote of the user, so the
+	// next ProcessVotes removes the counted ones from the results. Unlike a
+	// retracted vote, an invalidated one still stands for its voter on the
+	// poll and cannot be retracted. It returns how many votes were
+	// invalidated.
+	InvalidateUserVotes(ctx context.Context, userID uuid.UUID) (int64, error)
+	// InvalidateVote invalidates a single vote the same way. A vote that was
+	// already retracted or invalidated is left alone.
+	InvalidateVote(ctx context.Context, voteID uuid.UUID) error
 }
 
+// ChallengeVerifier checks that a voter solved a captcha or similar
+// challenge. Scope ties a challenge to what it unlocks, the poll id for
+// votes, so a solution cannot be spent elsewhere.
+type ChallengeVerifier interface {
+	NewChallenge(ctx context.Context, scope string) (*domain.Challenge, error)
+	// Verify returns domain.ErrChallengeFailed when the response is wrong,
+	// expired or replayed. Any other error means it could not be checked.
+	Verify(ctx context.Context, scope, response, remoteIP string) error
+}
+
+// ChallengeNonceStore remembers the proof of work challenges already solved,
+// so that each one is accepted once across every replica.
+type ChallengeNonceStore interface {
+	// Spend marks nonce as used until expiresAt and reports whether it was
+	// still unused.
+	Spend(ctx context.Context, nonce string, expiresAt time.Time) (bool, error)
+}
+
+// VoteInput carries the solved challenge in ChallengeResponse, which is
+// only checked on polls that require one. On secret ballot polls, Receipt
+// names the ballot being changed.
 type VoteInput struct {
-	PollID   uuid.UUID
-	OptionID uuid.UUID
-	UserID   uuid.UUID
-	VoterIP  string
+	PollID            uuid.UUID
+	OptionID          uuid.UUID
+	Voter             domain.Voter
+	VoterIP           string
+	ChallengeResponse string
+	Receipt           string
 }
 
 type VoteService interface {
-	Vote(ctx context.Context, input VoteInput) error
-	GetUserVote(ctx context.Context, pollID, userID uuid.UUID) (*domain.Vote, error)
+	// Vote only accepts anonymous voters on polls that allow them. It
+	// returns the receipt of the new vote.
+	Vote(ctx context.Context, input VoteInput) (*domain.VoteReceipt, error)
+	// GetVote and RetractVote find secret ballots by their receipt, which
+	// is ignored on other polls.
+	GetVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter, receipt string) (*domain.Vote, error)
+	RetractVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter, receipt string) error
+	// GetChallenge returns what to solve before voting on the poll. It
+	// returns nil when the poll does not require a challenge.
+	GetChallenge(ctx context.Context, pollID uuid.UUID) (*domain.Challenge, error)
 }
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type adminService struct {
+	pollRepo     ports.PollRepository
+	userRepo     ports.UserRepository
+	voteRepo     ports.VoteRepository
+	authRepo     ports.AuthRepository
+	reportRepo   ports.ReportRepository
+	voteFlagRepo ports.VoteFlagRepository
+	auditRepo    ports.AuditRepository
+	transactor   ports.Transactor
+}
+
+func NewAdminService(pollRepo ports.PollRepository, userRepo ports.UserRepository, voteRepo ports.VoteRepository, authRepo ports.AuthRepository, reportRepo ports.ReportRepository, voteFlagRepo ports.VoteFlagRepository, auditRepo ports.AuditRepository, transactor ports.Transactor) ports.AdminService {
+	return &adminService{
+		pollRepo:     pollRepo,
+		userRepo:     userRepo,
+		voteRepo:     voteRepo,
+		authRepo:     authRepo,
+		reportRepo:   reportRepo,
+		voteFlagRepo: voteFlagRepo,
+		auditRepo:    auditRepo,
+		transactor:   transactor,
+	}
+}
+
+// ListPolls pages through every poll, hidden ones included, newest first.
+func (s *adminService) ListPolls(ctx context.Context, input ports.ListPollsInput) ([]*domain.Poll, error) {
+	const pageSize = 20
+
+	page := input.Page
+	if page < 1 {
+		page = 1
+	}
+
+	return s.pollRepo.ListAll(ctx, pageSize, (page-1)*pageSize, input.Query)
+}
+
+func (s *adminService) HidePoll(ctx context.Context, pollID string, input ports.ModerationInput) error {
+	return s.setPollHidden(ctx, pollID, true, domain.AuditPollHide, input)
+}
+
+func (s *adminService) RestorePoll(ctx context.Context, pollID string, input ports.ModerationInput) error {
+	return s.setPollHidden(ctx, pollID, false, domain.AuditPollRestore, input)
+}
+
+func (s *adminService) setPollHidden(ctx context.Context, pollID string, hidden bool, action domain.AuditAction, input ports.ModerationInput) error {
+	id, err := uuid.Parse(pollID)
+	if err != nil {
+		return domain.ErrInvalidPollID
+	}
+
+	return s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.pollRepo.SetHidden(ctx, id, hidden); err != nil {
+			return err
+		}
+		return s.audit(ctx, action, domain.AuditTargetPoll, id, input, nil)
+	})
+}
+
+func (s *adminService) BanUser(ctx context.Context, userID string, input ports.ModerationInput) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return domain.ErrInvalidUserID
+	}
+	if id == input.ActorID {
+		return domain.ErrBanSelf
+	}
+
+	return s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.SetBanned(ctx, id.String(), true); err != nil {
+			return err
+		}
+		// Access tokens already handed out stay valid until they expire, but
+		// they can no longer be refreshed.
+		if err := s.authRepo.RevokeAllSessions(ctx, id.String()); err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+		return s.audit(ctx, domain.AuditUserBan, domain.AuditTargetUser, id, input, nil)
+	})
+}
+
+func (s *adminService) UnbanUser(ctx context.Context, userID string, input ports.ModerationInput) error {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return domain.ErrInvalidUserID
+	}
+
+	return s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.SetBanned(ctx, id.String(), false); err != nil {
+			return err
+		}
+		return s.audit(ctx, domain.AuditUserUnban, domain.AuditTargetUser, id, input, nil)
+	})
+}
+
+// InvalidateUserVotes invalidates every vote of the user, which still keep
+// them from voting again on those polls. Results catch up on the next vote
+// summarization.
+func (s *adminService) InvalidateUserVotes(ctx context.Context, userID string, input ports.ModerationInput) (int64, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return 0, domain.ErrInvalidUserID
+	}
+
+	var count int64
+	err = s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		count, err = s.voteRepo.InvalidateUserVotes(ctx, id)
+		if err != nil {
+			return err
+		}
+		details := map[string]any{"votes": count}
+		return s.audit(ctx, domain.AuditUserVotesInvalidate, domain.AuditTargetUser, id, input, details)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (s *adminService) ListRefreshTokens(ctx context.Context, userID string, input ports.ModerationInput) ([]*domain.RefreshToken, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, domain.ErrInvalidUserID
+	}
+
+	tokens, err := s.authRepo.ListRefreshTokens(ctx, id.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refresh tokens: %w", err)
+	}
+
+	// They reveal where and when the user logged in, so looking is audited
+	// as well.
+	if err := s.audit(ctx, domain.AuditUserTokensView, domain.AuditTargetUser, id, input, nil); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (s *adminService) ListAuditLog(ctx context.Context, page int) ([]*domain.AuditEntry, error) {
+	const pageSize = 50
+
+	if page < 1 {
+		page = 1
+	}
+
+	entries, err := s.auditRepo.List(ctx, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// ListReports is the moderation queue: reports with the given status,
+// oldest first.
+func (s *adminService) ListReports(ctx context.Context, status domain.ReportStatus, page int) ([]*domain.Report, error) {
+	const pageSize = 50
+
+	if !status.Valid() {
+		return nil, domain.ErrInvalidReportStatus
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	return s.reportRepo.List(ctx, status, pageSize, (page-1)*pageSize)
+}
+
+// DismissReport closes the report without acting on the poll, which stops
+// counting it towards hiding the poll from listings.
+func (s *adminService) DismissReport(ctx context.Context, reportID string, input ports.ModerationInput) error {
+	report, err := s.openReport(ctx, reportID)
+	if err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.reportRepo.Resolve(ctx, report.ID, domain.ReportStatusDismissed, input.ActorID); err != nil {
+			return err
+		}
+		return s.audit(ctx, domain.AuditReportDismiss, domain.AuditTargetReport, report.ID, input, nil)
+	})
+}
+
+func (s *adminService) ActionReport(ctx context.Context, reportID string, input ports.ModerationInput) error {
+	report, err := s.openReport(ctx, reportID)
+	if err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.pollRepo.SetHidden(ctx, report.PollID, true); err != nil {
+			return err
+		}
+		resolved, err := s.reportRepo.ResolveOpenForPoll(ctx, report.PollID, domain.ReportStatusActioned, input.ActorID)
+		if err != nil {
+			return err
+		}
+		details := map[string]any{"poll_id": report.PollID, "reports": resolved}
+		return s.audit(ctx, domain.AuditReportAction, domain.AuditTargetReport, report.ID, input, details)
+	})
+}
+
+func (s *adminService) openReport(ctx context.Context, reportID string) (*domain.Report, error) {
+	id, err := uuid.Parse(reportID)
+	if err != nil {
+		return nil, domain.ErrInvalidReportID
+	}
+
+	report, err := s.reportRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, domain.ErrReportNotFound
+	}
+	if report.Status != domain.ReportStatusOpen {
+		return nil, domain.ErrReportResolved
+	}
+
+	return report, nil
+}
+
+// ListVoteFlags is the review queue of the vote analysis job: flags with the
+// given status, oldest first.
+func (s *adminService) ListVoteFlags(ctx context.Context, status domain.VoteFlagStatus, page int) ([]*domain.VoteFlag, error) {
+	const pageSize = 50
+
+	if !status.Valid() {
+		return nil, domain.ErrInvalidFlagStatus
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	return s.voteFlagRepo.List(ctx, status, pageSize, (page-1)*pageSize)
+}
+
+// DismissVoteFlag closes the flag and leaves the vote counted. Other flags
+// raised on the same vote stay open.
+func (s *adminService) DismissVoteFlag(ctx context.Context, flagID string, input ports.ModerationInput) error {
+	flag, err := s.openVoteFlag(ctx, flagID)
+	if err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.voteFlagRepo.Resolve(ctx, flag.ID, domain.VoteFlagStatusDismissed, input.ActorID); err != nil {
+			return err
+		}
+		return s.audit(ctx, domain.AuditVoteFlagDismiss, domain.AuditTargetVoteFlag, flag.ID, input, nil)
+	})
+}
+
+// InvalidateFlaggedVote invalidates the vote so the next vote summarization
+// leaves it out of the results. Its voter cannot retract it or vote again.
+func (s *adminService) InvalidateFlaggedVote(ctx context.Context, flagID string, input ports.ModerationInput) error {
+	flag, err := s.openVoteFlag(ctx, flagID)
+	if err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.voteRepo.InvalidateVote(ctx, flag.VoteID); err != nil {
+			return err
+		}
+		resolved, err := s.voteFlagRepo.ResolveOpenForVote(ctx, flag.VoteID, domain.VoteFlagStatusInvalidated, input.ActorID)
+		if err != nil {
+			return err
+		}
+		details := map[string]any{"vote_id": flag.VoteID, "user_id": flag.UserID, "flags": resolved}
+		return s.audit(ctx, domain.AuditVoteFlagInvalidate, domain.AuditTargetVoteFlag, flag.ID, input, details)
+	})
+}
+
+func (s *adminService) openVoteFlag(ctx context.Context, flagID string) (*domain.VoteFlag, error) {
+	id, err := uuid.Parse(flagID)
+	if err != nil {
+		return nil, domain.ErrInvalidVoteFlagID
+	}
+
+	flag, err := s.voteFlagRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if flag == nil {
+		return nil, domain.ErrVoteFlagNotFound
+	}
+	if flag.Status != domain.VoteFlagStatusOpen {
+		return nil, domain.ErrVoteFlagResolved
+	}
+
+	return flag, nil
+}
+
+func (s *adminService) audit(ctx context.Context, action domain.AuditAction, targetType string, targetID uuid.UUID, input ports.ModerationInput, details map[string]any) error {
+	entry := &domain.AuditEntry{
+		ActorID:    input.ActorID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Reason:     input.Reason,
+		Details:    details,
+	}
+	if err := s.auditRepo.Append(ctx, entry); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
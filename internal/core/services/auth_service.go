@@ -8,48 +8,243 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
+	"net/mail"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 )
 
+const (
+	refreshTokenTTL = 7 * 24 * time.Hour
+	emailLinkTTL    = 15 * time.Minute
+
+	maxAPITokenNameLength = 100
+
+	// emailProvider is the identity provider name of magic-link logins.
+	emailProvider = "email"
+)
+
 type AuthService struct {
-	userRepo            ports.UserRepository
-	authRepo            ports.AuthRepository
-	googleTokenVerifier ports.TokenVerifier
-	jwtSecret           []byte
-	googleClientID      string
+	userRepo    ports.UserRepository
+	authRepo    ports.AuthRepository
+	providers   ports.ProviderRegistry
+	tokenSigner ports.TokenSigner
+	mailer      ports.Mailer
+}
+
+func NewAuthService(userRepo ports.UserRepository, authRepo ports.AuthRepository, providers ports.ProviderRegistry, tokenSigner ports.TokenSigner, mailer ports.Mailer) *AuthService {
+	return &AuthService{
+		userRepo:    userRepo,
+		authRepo:    authRepo,
+		providers:   providers,
+		tokenSigner: tokenSigner,
+		mailer:      mailer,
+	}
 }
 
-func NewAuthService(userRepo ports.UserRepository, authRepo ports.AuthRepository, googleTokenVerifier ports.TokenVerifier) *AuthService {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		fmt.Println("Warning: JWT_SECRET not set")
+// LoginWithIDToken logs in with an ID token issued by the named provider.
+func (s *AuthService) LoginWithIDToken(ctx context.Context, provider string, idToken string, client domain.ClientInfo) (string, string, error) {
+	p, ok := s.providers.Get(provider)
+	if !ok {
+		return "", "", domain.ErrUnknownProvider
+	}
+	if p.Verifier == nil {
+		return "", "", domain.ErrUnsupportedFlow
 	}
 
-	clientID := os.Getenv("GOOGLE_CLIENT_ID")
-	return &AuthService{
-		userRepo:            userRepo,
-		authRepo:            authRepo,
-		googleTokenVerifier: googleTokenVerifier,
-		jwtSecret:           []byte(secret),
-		googleClientID:      clientID,
+	payload, err := p.Verifier.Verify(ctx, idToken, p.ClientID)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", domain.ErrInvalidCredentials, err)
+	}
+
+	return s.login(ctx, provider, payload, client)
+}
+
+// AuthCodeURL returns where to send the user to start an authorization-code
+// login with the named provider.
+func (s *AuthService) AuthCodeURL(ctx context.Context, provider, state, codeChallenge, redirectURI string) (string, error) {
+	flow, err := s.authCodeFlow(provider)
+	if err != nil {
+		return "", err
 	}
+
+	return flow.AuthCodeURL(ctx, state, codeChallenge, redirectURI)
 }
 
-func (s *AuthService) LoginWithGoogle(ctx context.Context, googleToken string) (string, string, error) {
-	payload, err := s.googleTokenVerifier.Verify(ctx, googleToken, s.googleClientID)
+// LoginWithAuthCode redeems the code the provider redirected back with and
+// logs in the user it belongs to.
+func (s *AuthService) LoginWithAuthCode(ctx context.Context, provider, code, codeVerifier, redirectURI string, client domain.ClientInfo) (string, string, error) {
+	flow, err := s.authCodeFlow(provider)
 	if err != nil {
-		return "", "", fmt.Errorf("invalid google token: %w", err)
+		return "", "", err
 	}
 
-	return s.login(ctx, payload.Email, payload.Name)
+	payload, err := flow.Exchange(ctx, code, codeVerifier, redirectURI)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", domain.ErrInvalidCredentials, err)
+	}
+
+	return s.login(ctx, provider, payload, client)
 }
 
-func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshToken string) (string, string, error) {
+// StartEmailLogin mails a single-use login link to email. The link is
+// verifyURL with the token appended as a query parameter. It is sent whether
+// or not an account exists, so the endpoint does not reveal who signed up.
+func (s *AuthService) StartEmailLogin(ctx context.Context, email string, verifyURL string) error {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		verr := &domain.ValidationError{}
+		verr.Add("email", "invalid", "email must be a valid address")
+		return verr
+	}
+	email = domain.NormalizeEmail(addr.Address)
+
+	token, err := s.generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate login token: %w", err)
+	}
+
+	loginToken := &domain.EmailLoginToken{
+		Email:     email,
+		TokenHash: s.hashToken(token),
+		ExpiresAt: time.Now().Add(emailLinkTTL),
+	}
+	if err := s.authRepo.StoreEmailLoginToken(ctx, loginToken); err != nil {
+		return fmt.Errorf("failed to store login token: %w", err)
+	}
+
+	link := verifyURL + "?" + url.Values{"token": {token}}.Encode()
+	err = s.mailer.Send(ctx, ports.Email{
+		To:      email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf("Use the link below to log in. It expires in %d minutes and works only once.\n\n%s\n\n"+
+			"If you did not ask for it, you can ignore this email.\n", int(emailLinkTTL.Minutes()), link),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send login link: %w", err)
+	}
+
+	return nil
+}
+
+// LoginWithEmailLink consumes a token sent by StartEmailLogin and logs in
+// the owner of the email address, creating the user on first login.
+func (s *AuthService) LoginWithEmailLink(ctx context.Context, token string, client domain.ClientInfo) (string, string, error) {
+	loginToken, err := s.authRepo.ConsumeEmailLoginToken(ctx, s.hashToken(token))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to consume login token: %w", err)
+	}
+	if loginToken == nil {
+		return "", "", domain.ErrInvalidLoginLink
+	}
+
+	// The address is the subject: owning the mailbox is what the link proves.
+	name, _, _ := strings.Cut(loginToken.Email, "@")
+	return s.login(ctx, emailProvider, &ports.TokenPayload{
+		Subject:       loginToken.Email,
+		Email:         loginToken.Email,
+		EmailVerified: true,
+		Name:          name,
+	}, client)
+}
+
+// LinkWithIDToken adds the identity behind an ID token to the user's
+// account, so they can log in with that provider too.
+func (s *AuthService) LinkWithIDToken(ctx context.Context, userID uuid.UUID, provider string, idToken string) (*domain.UserIdentity, error) {
+	p, ok := s.providers.Get(provider)
+	if !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+	if p.Verifier == nil {
+		return nil, domain.ErrUnsupportedFlow
+	}
+
+	payload, err := p.Verifier.Verify(ctx, idToken, p.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidCredentials, err)
+	}
+
+	return s.link(ctx, userID, provider, payload)
+}
+
+// LinkWithAuthCode is LinkWithIDToken for authorization-code providers.
+func (s *AuthService) LinkWithAuthCode(ctx context.Context, userID uuid.UUID, provider, code, codeVerifier, redirectURI string) (*domain.UserIdentity, error) {
+	flow, err := s.authCodeFlow(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := flow.Exchange(ctx, code, codeVerifier, redirectURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidCredentials, err)
+	}
+
+	return s.link(ctx, userID, provider, payload)
+}
+
+func (s *AuthService) ListIdentities(ctx context.Context, userID uuid.UUID) ([]*domain.UserIdentity, error) {
+	identities, err := s.userRepo.ListIdentities(ctx, userID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity removes one of the user's identities. The last one cannot
+// be removed, since the account would be left without a way to log in.
+func (s *AuthService) UnlinkIdentity(ctx context.Context, userID uuid.UUID, identityID string) error {
+	id, err := uuid.Parse(identityID)
+	if err != nil {
+		return domain.ErrInvalidIdentityID
+	}
+
+	return s.userRepo.DeleteIdentity(ctx, userID.String(), id.String())
+}
+
+func (s *AuthService) link(ctx context.Context, userID uuid.UUID, provider string, payload *ports.TokenPayload) (*domain.UserIdentity, error) {
+	if payload.Subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", domain.ErrInvalidCredentials)
+	}
+	payload.Email = domain.NormalizeEmail(payload.Email)
+
+	existing, err := s.userRepo.GetIdentity(ctx, provider, payload.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+	if existing != nil {
+		if existing.UserID != userID {
+			return nil, domain.ErrIdentityLinked
+		}
+		return existing, nil
+	}
+
+	identity := newIdentity(userID, provider, payload)
+	if err := s.userRepo.CreateIdentity(ctx, identity); err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+func (s *AuthService) authCodeFlow(provider string) (ports.AuthCodeFlow, error) {
+	p, ok := s.providers.Get(provider)
+	if !ok {
+		return nil, domain.ErrUnknownProvider
+	}
+	if p.AuthCode == nil {
+		return nil, domain.ErrUnsupportedFlow
+	}
+	return p.AuthCode, nil
+}
+
+// RefreshAccessToken issues a new access token and rotates the refresh
+// token. Presenting a token that was already rotated means it leaked, so the
+// whole family is revoked and the user has to log in again.
+func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshToken string, client domain.ClientInfo) (string, string, error) {
 	tokenHash := s.hashToken(refreshToken)
 
 	rtEntity, err := s.authRepo.GetRefreshTokenByHash(ctx, tokenHash)
@@ -57,14 +252,14 @@ func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshToken strin
 		return "", "", fmt.Errorf("failed to get refresh token: %w", err)
 	}
 	if rtEntity == nil {
-		return "", "", errors.New("refresh token not found")
+		return "", "", domain.ErrInvalidRefreshToken
 	}
 
 	if rtEntity.Revoked {
-		return "", "", errors.New("refresh token revoked")
+		return "", "", s.revokeFamily(ctx, rtEntity)
 	}
 	if rtEntity.ExpiresAt.Before(time.Now()) {
-		return "", "", errors.New("refresh token expired")
+		return "", "", domain.ErrRefreshTokenExpired
 	}
 
 	user, err := s.userRepo.GetByID(ctx, rtEntity.UserID.String())
@@ -72,7 +267,7 @@ func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshToken strin
 		return "", "", fmt.Errorf("failed to get user: %w", err)
 	}
 	if user == nil {
-		return "", "", errors.New("user not found")
+		return "", "", domain.ErrInvalidRefreshToken
 	}
 
 	accessToken, err := s.generateAccessToken(user)
@@ -80,11 +275,37 @@ func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshToken strin
 		return "", "", fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	// Optional: Rotate Refresh Token
-	// For simplicity, keep the same refresh token until expiry, or we can rotate it.
-	// Let's keep it simple for now and return the same refresh token, or empty if we don't rotate.
+	newRefreshToken, err := s.generateToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
 
-	return accessToken, refreshToken, nil
+	next := &domain.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  rtEntity.FamilyID,
+		TokenHash: s.hashToken(newRefreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		UserAgent: client.UserAgent,
+		IPAddress: client.IPAddress,
+	}
+
+	err = s.authRepo.RotateRefreshToken(ctx, rtEntity.ID.String(), next)
+	if errors.Is(err, domain.ErrRefreshTokenRevoked) {
+		// Someone else refreshed with this very token in the meantime.
+		return "", "", s.revokeFamily(ctx, rtEntity)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return accessToken, newRefreshToken, nil
+}
+
+func (s *AuthService) revokeFamily(ctx context.Context, token *domain.RefreshToken) error {
+	if err := s.authRepo.RevokeRefreshTokenFamily(ctx, token.FamilyID.String()); err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return domain.ErrRefreshTokenReused
 }
 
 func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
@@ -101,28 +322,168 @@ func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
 	return s.authRepo.RevokeRefreshToken(ctx, rtEntity.ID.String())
 }
 
-func (s *AuthService) login(ctx context.Context, email, name string) (string, string, error) {
-	user, err := s.userRepo.GetByEmail(ctx, email)
+// ListSessions returns the user's active sessions, flagging the one the
+// given refresh token belongs to as current.
+func (s *AuthService) ListSessions(ctx context.Context, userID uuid.UUID, currentRefreshToken string) ([]*domain.Session, error) {
+	sessions, err := s.authRepo.ListSessions(ctx, userID.String())
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
 	}
 
-	if user == nil {
-		user = &domain.User{
-			Email: email,
-			Name:  name,
+	if currentRefreshToken == "" {
+		return sessions, nil
+	}
+
+	current, err := s.authRepo.GetRefreshTokenByHash(ctx, s.hashToken(currentRefreshToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if current == nil {
+		return sessions, nil
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == current.FamilyID
+	}
+
+	return sessions, nil
+}
+
+func (s *AuthService) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return domain.ErrInvalidSessionID
+	}
+
+	return s.authRepo.RevokeSession(ctx, userID.String(), id.String())
+}
+
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	if err := s.authRepo.RevokeAllSessions(ctx, userID.String()); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// CreateAPIToken issues a personal API token restricted to the given scopes.
+func (s *AuthService) CreateAPIToken(ctx context.Context, userID uuid.UUID, input ports.CreateAPITokenInput) (*domain.APIToken, string, error) {
+	name := strings.TrimSpace(input.Name)
+
+	v := &domain.ValidationError{}
+	if name == "" {
+		v.Add("name", "required", "name is required")
+	} else if len(name) > maxAPITokenNameLength {
+		v.Add("name", "too_long", fmt.Sprintf("name must be at most %d characters", maxAPITokenNameLength))
+	}
+	if len(input.Scopes) == 0 {
+		v.Add("scopes", "required", "at least one scope is required")
+	}
+	for _, scope := range input.Scopes {
+		if !scope.Valid() {
+			v.Add("scopes", "invalid", fmt.Sprintf("unknown scope %q", scope))
 		}
-		if err := s.userRepo.Create(ctx, user); err != nil {
-			return "", "", fmt.Errorf("failed to create user: %w", err)
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		v.Add("expires_at", "invalid", "expires_at must be in the future")
+	}
+	if err := v.Err(); err != nil {
+		return nil, "", err
+	}
+
+	secret, err := s.generateToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate api token: %w", err)
+	}
+	secret = domain.APITokenPrefix + secret
+
+	token := &domain.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: s.hashToken(secret),
+		Scopes:    uniqueScopes(input.Scopes),
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := s.authRepo.StoreAPIToken(ctx, token); err != nil {
+		return nil, "", fmt.Errorf("failed to store api token: %w", err)
+	}
+
+	return token, secret, nil
+}
+
+func (s *AuthService) ListAPITokens(ctx context.Context, userID uuid.UUID) ([]*domain.APIToken, error) {
+	tokens, err := s.authRepo.ListAPITokens(ctx, userID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *AuthService) RevokeAPIToken(ctx context.Context, userID uuid.UUID, tokenID string) error {
+	id, err := uuid.Parse(tokenID)
+	if err != nil {
+		return domain.ErrInvalidAPITokenID
+	}
+
+	return s.authRepo.DeleteAPIToken(ctx, userID.String(), id.String())
+}
+
+// AuthenticateAPIToken returns the token a client presented, recording when
+// it was last used.
+func (s *AuthService) AuthenticateAPIToken(ctx context.Context, token string) (*domain.APIToken, error) {
+	if !strings.HasPrefix(token, domain.APITokenPrefix) {
+		return nil, domain.ErrInvalidAPIToken
+	}
+
+	apiToken, err := s.authRepo.GetAPITokenByHash(ctx, s.hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api token: %w", err)
+	}
+	if apiToken == nil {
+		return nil, domain.ErrInvalidAPIToken
+	}
+	if apiToken.ExpiresAt != nil && time.Now().After(*apiToken.ExpiresAt) {
+		return nil, domain.ErrInvalidAPIToken
+	}
+
+	if err := s.authRepo.TouchAPIToken(ctx, apiToken.ID.String()); err != nil {
+		return nil, fmt.Errorf("failed to update api token: %w", err)
+	}
+
+	return apiToken, nil
+}
+
+func uniqueScopes(scopes []domain.APITokenScope) []domain.APITokenScope {
+	seen := map[domain.APITokenScope]bool{}
+	var unique []domain.APITokenScope
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			unique = append(unique, scope)
 		}
 	}
+	return unique
+}
+
+// login finds the user by the identity the provider vouched for, never by
+// email alone: otherwise whoever controls a matching address at any provider
+// would get into the account.
+func (s *AuthService) login(ctx context.Context, provider string, payload *ports.TokenPayload, client domain.ClientInfo) (string, string, error) {
+	if payload.Subject == "" {
+		return "", "", fmt.Errorf("%w: missing subject", domain.ErrInvalidCredentials)
+	}
+	payload.Email = domain.NormalizeEmail(payload.Email)
+
+	user, err := s.resolveUser(ctx, provider, payload)
+	if err != nil {
+		return "", "", err
+	}
 
 	accessToken, err := s.generateAccessToken(user)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	refreshToken, err := s.generateRefreshToken()
+	refreshToken, err := s.generateToken()
 	if err != nil {
 		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
 	}
@@ -131,9 +492,12 @@ func (s *AuthService) login(ctx context.Context, email, name string) (string, st
 
 	rtEntity := &domain.RefreshToken{
 		UserID:    user.ID,
+		FamilyID:  uuid.New(),
 		TokenHash: refreshTokenHash,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
 		Revoked:   false,
+		UserAgent: client.UserAgent,
+		IPAddress: client.IPAddress,
 	}
 
 	if err := s.authRepo.StoreRefreshToken(ctx, rtEntity); err != nil {
@@ -143,19 +507,91 @@ func (s *AuthService) login(ctx context.Context, email, name string) (string, st
 	return accessToken, refreshToken, nil
 }
 
+func (s *AuthService) resolveUser(ctx context.Context, provider string, payload *ports.TokenPayload) (*domain.User, error) {
+	identity, err := s.userRepo.GetIdentity(ctx, provider, payload.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identity: %w", err)
+	}
+	if identity != nil {
+		user, err := s.userRepo.GetByID(ctx, identity.UserID.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+		if user == nil {
+			// Users are only ever deleted by banning them.
+			return nil, domain.ErrUserBanned
+		}
+		return user, nil
+	}
+
+	if !payload.EmailVerified {
+		return nil, fmt.Errorf("%w: email not verified", domain.ErrInvalidCredentials)
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, payload.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user == nil {
+		banned, err := s.userRepo.IsBanned(ctx, payload.Email)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return nil, domain.ErrUserBanned
+		}
+
+		user = &domain.User{
+			Email: payload.Email,
+			Name:  payload.Name,
+		}
+		if err := s.userRepo.CreateWithIdentity(ctx, user, newIdentity(uuid.Nil, provider, payload)); err != nil {
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		return user, nil
+	}
+
+	// Accounts created before identities existed have none, and are claimed
+	// by the first verified login with their email. Any other account has to
+	// link the new provider explicitly.
+	identities, err := s.userRepo.ListIdentities(ctx, user.ID.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	if len(identities) > 0 {
+		return nil, domain.ErrIdentityNotLinked
+	}
+	if err := s.userRepo.CreateIdentity(ctx, newIdentity(user.ID, provider, payload)); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func newIdentity(userID uuid.UUID, provider string, payload *ports.TokenPayload) *domain.UserIdentity {
+	return &domain.UserIdentity{
+		UserID:        userID,
+		Provider:      provider,
+		Subject:       payload.Subject,
+		Email:         payload.Email,
+		EmailVerified: payload.EmailVerified,
+	}
+}
+
 func (s *AuthService) generateAccessToken(user *domain.User) (string, error) {
-	claims := jwt.MapClaims{
+	claims := map[string]any{
 		"sub":   user.ID.String(),
 		"email": user.Email,
+		"role":  string(user.Role),
 		"exp":   time.Now().Add(15 * time.Minute).Unix(),
 		"iat":   time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	return s.tokenSigner.Sign(claims)
 }
 
-func (s *AuthService) generateRefreshToken() (string, error) {
+func (s *AuthService) generateToken() (string, error) {
 	b := make([]byte, 32)
 	_, err := rand.Read(b)
 	if err != nil {
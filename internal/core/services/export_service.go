@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type exportService struct {
+	pollRepo       ports.PollRepository
+	pollResultRepo ports.PollResultRepository
+	voteRepo       ports.VoteRepository
+	voterHashKey   []byte
+}
+
+func NewExportService(pollRepo ports.PollRepository, pollResultRepo ports.PollResultRepository, voteRepo ports.VoteRepository, voterHashKey []byte) ports.ExportService {
+	return &exportService{
+		pollRepo:       pollRepo,
+		pollResultRepo: pollResultRepo,
+		voteRepo:       voteRepo,
+		voterHashKey:   voterHashKey,
+	}
+}
+
+// Export writes the aggregated results of a poll to w. Ballots are only
+// included when the caller created the poll.
+func (s *exportService) Export(ctx context.Context, id string, userID uuid.UUID, w ports.ExportWriter) error {
+	pollID, err := uuid.Parse(id)
+	if err != nil {
+		return domain.ErrInvalidPollID
+	}
+
+	poll, err := s.pollRepo.GetByID(ctx, pollID)
+	if err != nil {
+		return err
+	}
+
+	isCreator := poll.CreatedBy != nil && *poll.CreatedBy == userID
+	if !isCreator {
+		hasVoted, err := s.voteRepo.HasVoted(ctx, pollID, domain.UserVoter(userID))
+		if err != nil {
+			return err
+		}
+
+		if !hasVoted {
+			return domain.ErrUserNotVoted
+		}
+	}
+
+	stats, err := s.pollResultRepo.GetPollOptionStats(ctx, pollID)
+	if err != nil {
+		return err
+	}
+
+	optionTexts := make(map[uuid.UUID]string, len(poll.Options))
+	for _, opt := range poll.Options {
+		optionTexts[opt.ID] = opt.Text
+		result := domain.OptionResult{
+			OptionID:   opt.ID,
+			OptionText: opt.Text,
+			VoteCount:  stats[opt.ID].VoteCount,
+			Percentage: stats[opt.ID].Percentage,
+		}
+		if err := w.WriteResult(result); err != nil {
+			return err
+		}
+	}
+
+	if !isCreator {
+		return nil
+	}
+
+	return s.voteRepo.StreamVotes(ctx, pollID, func(vote *domain.Vote) error {
+		ballot := domain.Ballot{
+			OptionID:   vote.OptionID,
+			OptionText: optionTexts[vote.OptionID],
+			CastAt:     vote.CreatedAt,
+		}
+		if vote.Secret() {
+			ballot.CastAt = vote.CreatedAt.UTC().Truncate(24 * time.Hour)
+		} else if len(s.voterHashKey) > 0 {
+			// Secret ballots have no voter to hash, and without a key the
+			// hash would let the voter be found by hashing every user id.
+			ballot.VoterHash = s.hashVoter(pollID, vote.VoterID())
+		}
+		return w.WriteBallot(ballot)
+	})
+}
+
+// hashVoter is keyed by poll so the same voter gets unrelated hashes in
+// different polls.
+func (s *exportService) hashVoter(pollID, voterID uuid.UUID) string {
+	mac := hmac.New(sha256.New, s.voterHashKey)
+	mac.Write(pollID[:])
+	mac.Write(voterID[:])
+	return hex.EncodeToString(mac.Sum(nil))
+}
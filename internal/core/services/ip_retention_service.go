@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+const ipHashBatchSize = 1000
+
+type ipRetentionService struct {
+	repo   ports.IPRetentionRepository
+	policy domain.IPRetentionPolicy
+}
+
+func NewIPRetentionService(repo ports.IPRetentionRepository, policy domain.IPRetentionPolicy) ports.IPRetentionService {
+	return &ipRetentionService{
+		repo:   repo,
+		policy: policy,
+	}
+}
+
+// Apply first hashes the addresses of votes from before hashes were
+// recorded, as they are about to be the only trace of them, then
+// anonymizes the addresses kept past the retention period.
+func (s *ipRetentionService) Apply(ctx context.Context, now time.Time) (*domain.IPRetentionResult, error) {
+	result := &domain.IPRetentionResult{}
+
+	for {
+		votes, err := s.repo.ListUnhashedVoterIPs(ctx, ipHashBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		hashed := votes[:0]
+		for _, vote := range votes {
+			vote.VoterIPHash = s.policy.HashIP(vote.VoterIP)
+			vote.VoterSubnetHash = s.policy.HashSubnet(vote.VoterIP)
+			if vote.VoterIPHash != "" {
+				hashed = append(hashed, vote)
+			}
+		}
+		// Stored addresses always parse, but should a batch hold none that
+		// does, it would be listed again forever.
+		if len(hashed) == 0 {
+			break
+		}
+
+		if err := s.repo.SetVoterIPHashes(ctx, hashed); err != nil {
+			return nil, err
+		}
+		result.Hashed += int64(len(hashed))
+	}
+
+	if s.policy.Anonymization == domain.IPKeep {
+		return result, nil
+	}
+
+	anonymized, err := s.repo.AnonymizeVoterIPs(ctx, s.policy.Anonymization, now.Add(-s.policy.RetainFor))
+	if err != nil {
+		return nil, err
+	}
+	result.Anonymized = anonymized
+
+	return result, nil
+}
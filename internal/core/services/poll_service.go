@@ -3,6 +3,8 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,39 +13,117 @@ import (
 )
 
 type pollService struct {
-	pollRepo       ports.PollRepository
-	pollResultRepo ports.PollResultRepository
-	voteRepo       ports.VoteRepository
+	pollRepo        ports.PollRepository
+	pollResultRepo  ports.PollResultRepository
+	voteRepo        ports.VoteRepository
+	contentFilter   ports.ContentFilter
+	reportThreshold int
+	transactor      ports.Transactor
 }
 
-func NewPollService(pollRepo ports.PollRepository, pollResultRepo ports.PollResultRepository, voteRepo ports.VoteRepository) ports.PollService {
+// NewPollService creates the poll service. Every created or imported poll
+// goes through contentFilter, which may be nil to skip it. Polls with
+// reportThreshold or more open reports are left out of listings until a
+// moderator reviews them; 0 never leaves them out.
+func NewPollService(pollRepo ports.PollRepository, pollResultRepo ports.PollResultRepository, voteRepo ports.VoteRepository, contentFilter ports.ContentFilter, reportThreshold int, transactor ports.Transactor) ports.PollService {
 	return &pollService{
-		pollRepo:       pollRepo,
-		pollResultRepo: pollResultRepo,
-		voteRepo:       voteRepo,
+		pollRepo:        pollRepo,
+		pollResultRepo:  pollResultRepo,
+		voteRepo:        voteRepo,
+		contentFilter:   contentFilter,
+		reportThreshold: reportThreshold,
+		transactor:      transactor,
 	}
 }
 
 func (s *pollService) Create(ctx context.Context, input ports.CreatePollInput) (*domain.Poll, error) {
-	if input.Title == "" {
-		return nil, errors.New("title is required")
+	poll, err := s.newPoll(ctx, input)
+	if err != nil {
+		return nil, err
 	}
-	if len(input.Options) < 2 {
-		return nil, errors.New("at least two options are required")
+
+	err = s.pollRepo.Save(ctx, poll)
+	if err != nil {
+		return nil, err
+	}
+
+	return poll, nil
+}
+
+// Import reports rows that are invalid or rejected by the content filter and
+// saves the others in batches, all in one transaction. Failing to save a
+// batch stops the import with the error and leaves none of the polls saved,
+// so the same file can be imported again.
+func (s *pollService) Import(ctx context.Context, rows []ports.ImportPollRow) (*ports.ImportReport, error) {
+	const batchSize = 100
+
+	report := &ports.ImportReport{Rows: make([]ports.ImportRowResult, len(rows))}
+	var polls []*domain.Poll
+	var pollRows []int
+
+	for i, row := range rows {
+		report.Rows[i].Row = row.Row
+		if row.Err != nil {
+			report.Rows[i].Error = row.Err.Error()
+			report.Failed++
+			continue
+		}
+
+		poll, err := s.newPoll(ctx, row.Input)
+		if err != nil {
+			report.Rows[i].Error = err.Error()
+			report.Failed++
+			continue
+		}
+
+		polls = append(polls, poll)
+		pollRows = append(pollRows, i)
+	}
+
+	err := s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		for start := 0; start < len(polls); start += batchSize {
+			end := min(start+batchSize, len(polls))
+			if err := s.pollRepo.SaveBatch(ctx, polls[start:end]); err != nil {
+				return fmt.Errorf("failed to import polls from row %d: %w", report.Rows[pollRows[start]].Row, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, idx := range pollRows {
+		report.Rows[idx].PollID = &polls[i].ID
+		report.Imported++
+	}
+	return report, nil
+}
+
+// newPoll validates the input, runs it through the content filter and
+// builds the poll it describes.
+func (s *pollService) newPoll(ctx context.Context, input ports.CreatePollInput) (*domain.Poll, error) {
+	validation := &domain.ValidationError{}
+	if input.Title == "" {
+		validation.Add("title", "required", "title is required")
 	}
 
 	pollID := uuid.New()
 	now := time.Now()
 
 	poll := &domain.Poll{
-		ID:          pollID,
-		Title:       input.Title,
-		Description: input.Description,
-		CreatedAt:   now,
+		ID:               pollID,
+		Title:            input.Title,
+		Description:      input.Description,
+		RequireChallenge: input.RequireChallenge,
+		AllowAnonymous:   input.AllowAnonymous,
+		SecretBallot:     input.SecretBallot,
+		CreatedBy:        input.CreatedBy,
+		CreatedAt:        now,
 	}
 
 	for _, optText := range input.Options {
-		if optText == "" {
+		if strings.TrimSpace(optText) == "" {
 			continue
 		}
 		poll.Options = append(poll.Options, domain.PollOption{
@@ -54,12 +134,29 @@ func (s *pollService) Create(ctx context.Context, input ports.CreatePollInput) (
 		})
 	}
 
-	if len(poll.Options) < 2 {
-		return nil, errors.New("at least two valid options are required")
+	if len(input.Options) < 2 {
+		validation.Add("options", "too_few", "at least two options are required")
+	} else if len(poll.Options) < 2 {
+		validation.Add("options", "too_few", "at least two valid options are required")
 	}
 
-	err := s.pollRepo.Save(ctx, poll)
-	if err != nil {
+	// Participants of a secret ballot are tracked by account, which
+	// anonymous voters lack.
+	if input.SecretBallot && input.AllowAnonymous {
+		validation.Add("allow_anonymous", "conflict", "secret ballots cannot take anonymous votes")
+	}
+
+	if s.contentFilter != nil {
+		err := s.contentFilter.Check(ctx, input)
+		var rejected *domain.ValidationError
+		if errors.As(err, &rejected) {
+			validation.Fields = append(validation.Fields, rejected.Fields...)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validation.Err(); err != nil {
 		return nil, err
 	}
 
@@ -99,9 +196,9 @@ func (s *pollService) ListPolls(ctx context.Context, input ports.ListPollsInput)
 	var err error
 
 	if input.Query == "" {
-		polls, err = s.pollRepo.List(ctx, pageSize, offset)
+		polls, err = s.pollRepo.List(ctx, pageSize, offset, s.reportThreshold)
 	} else {
-		polls, err = s.pollRepo.Search(ctx, pageSize, offset, input.Query)
+		polls, err = s.pollRepo.Search(ctx, pageSize, offset, s.reportThreshold, input.Query)
 	}
 
 	if err != nil {
@@ -111,7 +208,7 @@ func (s *pollService) ListPolls(ctx context.Context, input ports.ListPollsInput)
 	return polls, nil
 }
 
-func (s *pollService) GetPollStats(ctx context.Context, id string, userID uuid.UUID) (map[uuid.UUID]domain.PollOptionStats, error) {
+func (s *pollService) GetPollStats(ctx context.Context, id string, voter domain.Voter) (map[uuid.UUID]domain.PollOptionStats, error) {
 	pollID, err := uuid.Parse(id)
 	if err != nil {
 		return nil, domain.ErrInvalidPollID
@@ -122,7 +219,7 @@ func (s *pollService) GetPollStats(ctx context.Context, id string, userID uuid.U
 		return nil, err
 	}
 
-	hasVoted, err := s.voteRepo.HasVoted(ctx, pollID, userID)
+	hasVoted, err := s.voteRepo.HasVoted(ctx, pollID, voter)
 	if err != nil {
 		return nil, err
 	}
@@ -133,3 +230,67 @@ func (s *pollService) GetPollStats(ctx context.Context, id string, userID uuid.U
 
 	return s.pollResultRepo.GetPollOptionStats(ctx, pollID)
 }
+
+func (s *pollService) GetPollTimeline(ctx context.Context, id string, userID uuid.UUID, bucket domain.TimelineBucket) ([]domain.PollTimelinePoint, error) {
+	pollID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, domain.ErrInvalidPollID
+	}
+
+	if !bucket.Valid() {
+		return nil, domain.ErrInvalidBucket
+	}
+
+	poll, err := s.pollRepo.GetByID(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	isCreator := poll.CreatedBy != nil && *poll.CreatedBy == userID
+	if !isCreator {
+		hasVoted, err := s.voteRepo.HasVoted(ctx, pollID, domain.UserVoter(userID))
+		if err != nil {
+			return nil, err
+		}
+
+		if !hasVoted {
+			return nil, domain.ErrUserNotVoted
+		}
+	}
+
+	return s.pollResultRepo.GetPollTimeline(ctx, pollID, bucket)
+}
+
+func (s *pollService) GetTally(ctx context.Context, id string) (*domain.Tally, error) {
+	pollID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, domain.ErrInvalidPollID
+	}
+
+	if _, err := s.pollRepo.GetByID(ctx, pollID); err != nil {
+		return nil, err
+	}
+
+	return s.pollResultRepo.GetTally(ctx, pollID)
+}
+
+func (s *pollService) GetTallyProof(ctx context.Context, id string, receiptHash string) (*domain.TallyProof, error) {
+	pollID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, domain.ErrInvalidPollID
+	}
+
+	if !domain.ValidReceiptHash(receiptHash) {
+		return nil, domain.ErrInvalidReceiptHash
+	}
+
+	if _, err := s.pollRepo.GetByID(ctx, pollID); err != nil {
+		return nil, err
+	}
+
+	return s.pollResultRepo.GetTallyProof(ctx, pollID, strings.ToLower(receiptHash))
+}
+
+func (s *pollService) ListCreatedPolls(ctx context.Context, userID uuid.UUID) ([]*domain.PollAnalytics, error) {
+	return s.pollRepo.ListAnalyticsByCreator(ctx, userID)
+}
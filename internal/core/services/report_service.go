@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+const maxReportDetailsLength = 1000
+
+type reportService struct {
+	pollRepo   ports.PollRepository
+	reportRepo ports.ReportRepository
+}
+
+func NewReportService(pollRepo ports.PollRepository, reportRepo ports.ReportRepository) ports.ReportService {
+	return &reportService{
+		pollRepo:   pollRepo,
+		reportRepo: reportRepo,
+	}
+}
+
+func (s *reportService) ReportPoll(ctx context.Context, input ports.ReportPollInput) (*domain.Report, error) {
+	pollID, err := uuid.Parse(input.PollID)
+	if err != nil {
+		return nil, domain.ErrInvalidPollID
+	}
+
+	details := strings.TrimSpace(input.Details)
+
+	v := &domain.ValidationError{}
+	if input.Reason == "" {
+		v.Add("reason", "required", "reason is required")
+	} else if !input.Reason.Valid() {
+		v.Add("reason", "invalid", fmt.Sprintf("unknown reason %q", input.Reason))
+	}
+	if len(details) > maxReportDetailsLength {
+		v.Add("details", "too_long", fmt.Sprintf("details must be at most %d characters", maxReportDetailsLength))
+	}
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.pollRepo.GetByID(ctx, pollID); err != nil {
+		return nil, err
+	}
+
+	report := &domain.Report{
+		PollID:     pollID,
+		ReporterID: input.ReporterID,
+		Reason:     input.Reason,
+		Details:    details,
+	}
+	if err := s.reportRepo.Create(ctx, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -24,5 +24,29 @@ func (s *UserService) GetByID(ctx context.Context, id uuid.UUID) (*domain.User,
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	if user == nil {
+		return nil, domain.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *UserService) SetRoleByEmail(ctx context.Context, email string, role domain.Role) (*domain.User, error) {
+	if !role.Valid() {
+		return nil, domain.ErrInvalidRole
+	}
+
+	user, err := s.repo.GetByEmail(ctx, domain.NormalizeEmail(email))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, domain.ErrUserNotFound
+	}
+
+	if err := s.repo.SetRole(ctx, user.ID.String(), role); err != nil {
+		return nil, err
+	}
+	user.Role = role
+
 	return user, nil
 }
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+type voteAnalysisService struct {
+	voteFlagRepo ports.VoteFlagRepository
+	config       domain.VoteAnalysisConfig
+}
+
+func NewVoteAnalysisService(voteFlagRepo ports.VoteFlagRepository, config domain.VoteAnalysisConfig) ports.VoteAnalysisService {
+	return &voteAnalysisService{
+		voteFlagRepo: voteFlagRepo,
+		config:       config,
+	}
+}
+
+func (s *voteAnalysisService) Analyze(ctx context.Context, since time.Time) (map[domain.VoteFlagRule]int64, error) {
+	flagged := make(map[domain.VoteFlagRule]int64)
+	for _, rule := range domain.VoteFlagRules {
+		if !s.config.Enabled(rule) {
+			continue
+		}
+
+		count, err := s.voteFlagRepo.FlagVotes(ctx, rule, since, s.config)
+		if err != nil {
+			return nil, err
+		}
+		flagged[rule] = count
+	}
+	return flagged, nil
+}
@@ -2,6 +2,9 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,19 +15,37 @@ import (
 type voteService struct {
 	pollRepo ports.PollRepository
 	voteRepo ports.VoteRepository
+	verifier ports.ChallengeVerifier
+	// anonymousPerIP caps the anonymous votes a poll takes from one address,
+	// since clearing cookies is all it takes to vote again. 0 disables it.
+	anonymousPerIP int
+	ipPolicy       domain.IPRetentionPolicy
+	transactor     ports.Transactor
 }
 
-func NewVoteService(pollRepo ports.PollRepository, voteRepo ports.VoteRepository) ports.VoteService {
+func NewVoteService(pollRepo ports.PollRepository, voteRepo ports.VoteRepository, verifier ports.ChallengeVerifier, anonymousPerIP int, ipPolicy domain.IPRetentionPolicy, transactor ports.Transactor) ports.VoteService {
 	return &voteService{
-		pollRepo: pollRepo,
-		voteRepo: voteRepo,
+		pollRepo:       pollRepo,
+		voteRepo:       voteRepo,
+		verifier:       verifier,
+		anonymousPerIP: anonymousPerIP,
+		ipPolicy:       ipPolicy,
+		transactor:     transactor,
 	}
 }
 
-func (s *voteService) Vote(ctx context.Context, input ports.VoteInput) error {
+func (s *voteService) Vote(ctx context.Context, input ports.VoteInput) (*domain.VoteReceipt, error) {
+	if !input.Voter.Valid() {
+		return nil, domain.ErrLoginRequired
+	}
+
 	poll, err := s.pollRepo.GetByID(ctx, input.PollID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if input.Voter.Anonymous() && !poll.AllowAnonymous {
+		return nil, domain.ErrLoginRequired
 	}
 
 	validOption := false
@@ -35,36 +56,173 @@ func (s *voteService) Vote(ctx context.Context, input ports.VoteInput) error {
 		}
 	}
 	if !validOption {
-		return domain.ErrInvalidOption
+		return nil, domain.ErrInvalidOption
 	}
 
-	hasVotedOnOption, err := s.voteRepo.HasVotedOnOption(ctx, input.OptionID, input.UserID)
+	if poll.RequireChallenge {
+		if input.ChallengeResponse == "" {
+			return nil, domain.ErrChallengeRequired
+		}
+		err := s.verifier.Verify(ctx, poll.ID.String(), input.ChallengeResponse, input.VoterIP)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if poll.SecretBallot {
+		return s.castBallot(ctx, input)
+	}
+
+	hasVotedOnOption, err := s.voteRepo.HasVotedOnOption(ctx, input.OptionID, input.Voter)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if hasVotedOnOption {
-		return domain.ErrAlreadyVoted
+		return nil, domain.ErrAlreadyVoted
 	}
 
-	err = s.unvote(ctx, input.PollID, input.UserID)
-	if err != nil && err != domain.ErrUserNotVoted {
-		return err
+	receipt, err := newReceipt(input)
+	if err != nil {
+		return nil, err
 	}
 
 	vote := &domain.Vote{
-		ID:        uuid.New(),
-		PollID:    input.PollID,
-		OptionID:  input.OptionID,
-		UserID:    input.UserID,
-		VoterIP:   input.VoterIP,
-		CreatedAt: time.Now(),
+		ID:              uuid.New(),
+		PollID:          input.PollID,
+		OptionID:        input.OptionID,
+		UserID:          input.Voter.UserID,
+		DeviceID:        input.Voter.DeviceID,
+		ReceiptHash:     domain.ReceiptHash(receipt.Receipt),
+		VoterIP:         s.ipPolicy.StoredIP(input.VoterIP),
+		VoterIPHash:     s.ipPolicy.StoredIPHash(input.VoterIP),
+		VoterSubnetHash: s.ipPolicy.HashSubnet(input.VoterIP),
+		CreatedAt:       time.Now(),
 	}
 
-	return s.voteRepo.SaveVote(ctx, vote)
+	// The previous vote is retracted and the new one saved together, so a
+	// failure cannot leave the voter with neither.
+	err = s.transactor.WithinTx(ctx, func(ctx context.Context) error {
+		if input.Voter.Anonymous() {
+			if err := s.checkAnonymousLimit(ctx, input); err != nil {
+				return err
+			}
+		}
+
+		err := s.unvote(ctx, input.PollID, input.Voter)
+		if err != nil && err != domain.ErrUserNotVoted {
+			return err
+		}
+		return s.voteRepo.SaveVote(ctx, vote)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipt, nil
 }
 
-func (s *voteService) unvote(ctx context.Context, pollID, userID uuid.UUID) error {
-	hasVoted, err := s.voteRepo.HasVoted(ctx, pollID, userID)
+// castBallot stores a secret ballot under a fresh receipt. Given the
+// receipt of the voter's current ballot, it replaces that one instead.
+func (s *voteService) castBallot(ctx context.Context, input ports.VoteInput) (*domain.VoteReceipt, error) {
+	receipt, err := newReceipt(input)
+	if err != nil {
+		return nil, err
+	}
+
+	ballot := &domain.Vote{
+		ID:          uuid.New(),
+		PollID:      input.PollID,
+		OptionID:    input.OptionID,
+		ReceiptHash: domain.ReceiptHash(receipt.Receipt),
+		OwnerHash:   domain.BallotOwner(receipt.Receipt, *input.Voter.UserID),
+		CreatedAt:   time.Now(),
+	}
+
+	if input.Receipt == "" {
+		if err := s.voteRepo.SaveBallot(ctx, ballot, *input.Voter.UserID); err != nil {
+			return nil, err
+		}
+		return receipt, nil
+	}
+
+	current, err := s.currentBallot(ctx, input.PollID, input.Voter, input.Receipt)
+	if err != nil {
+		return nil, err
+	}
+	if current.OptionID == input.OptionID {
+		return nil, domain.ErrAlreadyVoted
+	}
+
+	if err := s.voteRepo.ReplaceBallot(ctx, current.ReceiptHash, ballot); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// currentBallot finds the ballot a receipt stands for, provided the voter
+// cast it. Another participant holding the receipt gets
+// domain.ErrInvalidReceipt, as if it stood for no ballot.
+func (s *voteService) currentBallot(ctx context.Context, pollID uuid.UUID, voter domain.Voter, receipt string) (*domain.Vote, error) {
+	if receipt == "" {
+		return nil, domain.ErrReceiptRequired
+	}
+	if voter.Anonymous() {
+		return nil, domain.ErrLoginRequired
+	}
+
+	hasVoted, err := s.voteRepo.HasVoted(ctx, pollID, voter)
+	if err != nil {
+		return nil, err
+	}
+	if !hasVoted {
+		return nil, domain.ErrUserNotVoted
+	}
+
+	ballot, err := s.voteRepo.GetBallot(ctx, pollID, domain.ReceiptHash(receipt))
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(ballot.OwnerHash), []byte(domain.BallotOwner(receipt, *voter.UserID))) != 1 {
+		return nil, domain.ErrInvalidReceipt
+	}
+	return ballot, nil
+}
+
+// checkAnonymousLimit refuses a new anonymous vote once the poll holds
+// anonymousPerIP of them from the voter's address. A device changing its
+// vote does not add one, so it is let through. It must run in the
+// transaction saving the vote, which then holds the address's lock until
+// the vote is in, so concurrent votes from it cannot all pass the count.
+// Without a key to hash addresses with, there is nothing to count by.
+func (s *voteService) checkAnonymousLimit(ctx context.Context, input ports.VoteInput) error {
+	if s.anonymousPerIP <= 0 {
+		return nil
+	}
+
+	ipHash := s.ipPolicy.HashIP(input.VoterIP)
+	if ipHash == "" {
+		return nil
+	}
+	if err := s.voteRepo.LockAnonymousVotes(ctx, input.PollID, ipHash); err != nil {
+		return err
+	}
+
+	hasVoted, err := s.voteRepo.HasVoted(ctx, input.PollID, input.Voter)
+	if err != nil || hasVoted {
+		return err
+	}
+
+	count, err := s.voteRepo.CountAnonymousVotes(ctx, input.PollID, ipHash)
+	if err != nil {
+		return err
+	}
+	if count >= s.anonymousPerIP {
+		return domain.ErrIPVoteLimit
+	}
+	return nil
+}
+
+func (s *voteService) unvote(ctx context.Context, pollID uuid.UUID, voter domain.Voter) error {
+	hasVoted, err := s.voteRepo.HasVoted(ctx, pollID, voter)
 	if err != nil {
 		return err
 	}
@@ -73,13 +231,60 @@ func (s *voteService) unvote(ctx context.Context, pollID, userID uuid.UUID) erro
 		return domain.ErrUserNotVoted
 	}
 
-	return s.voteRepo.DeleteVote(ctx, pollID, userID)
+	return s.voteRepo.DeleteVote(ctx, pollID, voter)
 }
 
-func (s *voteService) GetUserVote(ctx context.Context, pollID, userID uuid.UUID) (*domain.Vote, error) {
-	vote, err := s.voteRepo.GetVote(ctx, pollID, userID)
+func (s *voteService) GetVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter, receipt string) (*domain.Vote, error) {
+	poll, err := s.pollRepo.GetByID(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	if poll.SecretBallot {
+		return s.currentBallot(ctx, pollID, voter, receipt)
+	}
+
+	vote, err := s.voteRepo.GetVote(ctx, pollID, voter)
 	if err != nil {
 		return nil, err
 	}
 	return vote, nil
 }
+
+func (s *voteService) RetractVote(ctx context.Context, pollID uuid.UUID, voter domain.Voter, receipt string) error {
+	poll, err := s.pollRepo.GetByID(ctx, pollID)
+	if err != nil {
+		return err
+	}
+
+	if !poll.SecretBallot {
+		return s.unvote(ctx, pollID, voter)
+	}
+
+	ballot, err := s.currentBallot(ctx, pollID, voter, receipt)
+	if err != nil {
+		return err
+	}
+	return s.voteRepo.RetractBallot(ctx, pollID, ballot.ReceiptHash, *voter.UserID)
+}
+
+// newReceipt commits to the vote with a 256-bit random nonce, so receipts
+// cannot be guessed by trying every option.
+func newReceipt(input ports.VoteInput) (*domain.VoteReceipt, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate receipt nonce: %w", err)
+	}
+	return domain.NewVoteReceipt(input.PollID, input.OptionID, nonce), nil
+}
+
+func (s *voteService) GetChallenge(ctx context.Context, pollID uuid.UUID) (*domain.Challenge, error) {
+	poll, err := s.pollRepo.GetByID(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if !poll.RequireChallenge {
+		return nil, nil
+	}
+	return s.verifier.NewChallenge(ctx, poll.ID.String())
+}
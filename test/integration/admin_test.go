@@ -0,0 +1,541 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	repo "github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/services"
+)
+
+func TestAdminAPI(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	adminID, admin := createUserWithRole(t, app.DB, domain.RoleAdmin)
+	_, moderator := createUserWithRole(t, app.DB, domain.RoleModerator)
+	_, user := createUserWithToken(t, app.DB)
+
+	problemCode := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		var problem struct {
+			Code string `json:"code"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		return problem.Code
+	}
+
+	resp := app.Do(t, "POST", "/api/polls", map[string]any{"title": "Moderated poll", "options": []string{"A", "B"}}, withToken(user))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var poll domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+	resp.Body.Close()
+
+	// 1. Plain users cannot reach the admin API, moderators only the polls
+	resp = app.Do(t, "GET", "/api/admin/polls", nil, withToken(user))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "insufficient_role", problemCode(resp))
+
+	resp = app.Do(t, "GET", "/api/admin/polls", nil, withToken(moderator))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = app.Do(t, "GET", "/api/admin/audit", nil, withToken(moderator))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "insufficient_role", problemCode(resp))
+
+	// 2. A hidden poll disappears from the public API but not the admin one
+	resp = app.Do(t, "POST", "/api/admin/polls/"+poll.ID.String()+"/hide", map[string]string{"reason": "spam"}, withToken(moderator))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "GET", "/api/polls/"+poll.ID.String(), nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = app.Do(t, "GET", "/api/polls?q=Moderated", nil)
+	var public []domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&public))
+	resp.Body.Close()
+	assert.Empty(t, public)
+
+	resp = app.Do(t, "GET", "/api/admin/polls?q=Moderated", nil, withToken(moderator))
+	var all []domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&all))
+	resp.Body.Close()
+	require.Len(t, all, 1)
+	assert.Equal(t, poll.ID, all[0].ID)
+	assert.NotNil(t, all[0].DeletedAt)
+
+	// 3. Restoring brings it back
+	resp = app.Do(t, "POST", "/api/admin/polls/"+poll.ID.String()+"/restore", nil, withToken(moderator))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "GET", "/api/polls/"+poll.ID.String(), nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = app.Do(t, "POST", "/api/admin/polls/"+uuid.New().String()+"/hide", nil, withToken(moderator))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "poll_not_found", problemCode(resp))
+
+	// An action that cannot be written to the audit log is not taken
+	_, err := app.DB.Exec("ALTER TABLE audit_log ADD CONSTRAINT audit_log_unwritable CHECK (false) NOT VALID")
+	require.NoError(t, err)
+	resp = app.Do(t, "POST", "/api/admin/polls/"+poll.ID.String()+"/hide", nil, withToken(moderator))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	_, err = app.DB.Exec("ALTER TABLE audit_log DROP CONSTRAINT audit_log_unwritable")
+	require.NoError(t, err)
+
+	resp = app.Do(t, "GET", "/api/polls/"+poll.ID.String(), nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 4. Invalidated votes are removed from the results on the next summary
+	form := url.Values{"credential": {"valid_token"}}
+	resp, err = app.Client.PostForm(app.Server.URL+"/oauth/callback", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	var voter, voterRefresh string
+	for _, cookie := range resp.Cookies() {
+		switch cookie.Name {
+		case "access_token":
+			voter = cookie.Value
+		case "refresh_token":
+			voterRefresh = cookie.Value
+		}
+	}
+	require.NotEmpty(t, voter)
+	var voterID uuid.UUID
+	require.NoError(t, app.DB.QueryRow("SELECT id FROM users WHERE email = 'test@example.com'").Scan(&voterID))
+
+	for _, token := range []string{voter, user} {
+		resp = app.Do(t, "POST", "/api/polls/"+poll.ID.String()+"/votes", map[string]any{"option_id": poll.Options[0].ID}, withToken(token))
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	require.NoError(t, app.SummarySvc.SummarizeAllVotes(context.Background()))
+
+	count := func() int64 {
+		var total int64
+		require.NoError(t, app.DB.QueryRow("SELECT COALESCE(SUM(vote_count), 0) FROM poll_results WHERE poll_id = $1", poll.ID).Scan(&total))
+		return total
+	}
+	require.Equal(t, int64(2), count())
+
+	resp = app.Do(t, "POST", "/api/admin/users/"+voterID.String()+"/votes/invalidate", map[string]string{"reason": "vote buying"}, withToken(admin))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var invalidated struct {
+		Invalidated int64 `json:"invalidated"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&invalidated))
+	resp.Body.Close()
+	assert.Equal(t, int64(1), invalidated.Invalidated)
+
+	require.NoError(t, app.SummarySvc.SummarizeAllVotes(context.Background()))
+	assert.Equal(t, int64(1), count())
+
+	// The user can neither retract the invalidated vote nor vote again
+	resp = app.Do(t, "DELETE", "/api/polls/"+poll.ID.String()+"/votes", nil, withToken(voter))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "vote_invalidated", problemCode(resp))
+	resp = app.Do(t, "POST", "/api/polls/"+poll.ID.String()+"/votes", map[string]any{"option_id": poll.Options[1].ID}, withToken(voter))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "vote_invalidated", problemCode(resp))
+	require.NoError(t, app.SummarySvc.SummarizeAllVotes(context.Background()))
+	assert.Equal(t, int64(1), count())
+
+	// 5. Admins can see the refresh tokens of a user
+	resp = app.Do(t, "GET", "/api/admin/users/"+voterID.String()+"/refresh-tokens", nil, withToken(admin))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tokens []map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	resp.Body.Close()
+	require.Len(t, tokens, 1)
+	assert.Equal(t, false, tokens[0]["revoked"])
+	assert.NotContains(t, tokens[0], "token_hash")
+
+	// 6. A banned user can neither refresh nor log in again
+	resp = app.Do(t, "POST", "/api/admin/users/"+adminID.String()+"/ban", nil, withToken(admin))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "ban_self", problemCode(resp))
+
+	resp = app.Do(t, "POST", "/api/admin/users/"+voterID.String()+"/ban", map[string]string{"reason": "vote buying"}, withToken(admin))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	req, err := http.NewRequest("POST", app.Server.URL+"/auth/refresh", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: voterRefresh})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = app.Client.PostForm(app.Server.URL+"/oauth/callback", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = app.Client.PostForm(app.Server.URL+"/oauth/stub/callback", url.Values{"id_token": {app.Issuer.IDToken(t, "test@example.com", nil)}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "nor through a provider they never linked")
+	assert.Equal(t, "user_banned", problemCode(resp))
+
+	// 7. Unbanning lets them back in
+	resp = app.Do(t, "POST", "/api/admin/users/"+voterID.String()+"/unban", nil, withToken(admin))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = app.Client.PostForm(app.Server.URL+"/oauth/callback", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	// 8. Every action was audited, newest first
+	resp = app.Do(t, "GET", "/api/admin/audit", nil, withToken(admin))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var entries []domain.AuditEntry
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+	resp.Body.Close()
+
+	var actions []domain.AuditAction
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []domain.AuditAction{
+		domain.AuditUserUnban,
+		domain.AuditUserBan,
+		domain.AuditUserTokensView,
+		domain.AuditUserVotesInvalidate,
+		domain.AuditPollRestore,
+		domain.AuditPollHide,
+	}, actions)
+	assert.Equal(t, "vote buying", entries[1].Reason)
+	assert.Equal(t, voterID, entries[1].TargetID)
+	assert.Equal(t, adminID, entries[1].ActorID)
+	assert.Equal(t, float64(1), entries[3].Details["votes"])
+
+	// 9. The audit log cannot be rewritten
+	_, err = app.DB.Exec("UPDATE audit_log SET reason = ''")
+	assert.Error(t, err)
+	_, err = app.DB.Exec("DELETE FROM audit_log")
+	assert.Error(t, err)
+}
+
+func TestPollReports(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	_, moderator := createUserWithRole(t, app.DB, domain.RoleModerator)
+	_, author := createUserWithToken(t, app.DB)
+
+	problemCode := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		var problem struct {
+			Code string `json:"code"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		return problem.Code
+	}
+
+	resp := app.Do(t, "POST", "/api/polls", map[string]any{"title": "Buy cheap watches", "options": []string{"A", "B"}}, withToken(author))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var poll domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+	resp.Body.Close()
+	pollPath := "/api/polls/" + poll.ID.String()
+
+	report := func(accessToken string, reason string) *domain.Report {
+		resp := app.Do(t, "POST", pollPath+"/reports", map[string]string{"reason": reason}, withToken(accessToken))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var r domain.Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+		return &r
+	}
+
+	listed := func() bool {
+		for _, path := range []string{"/api/polls", "/api/polls?q=watches"} {
+			resp := app.Do(t, "GET", path, nil)
+			var polls []domain.Poll
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&polls))
+			resp.Body.Close()
+			found := false
+			for _, p := range polls {
+				found = found || p.ID == poll.ID
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	openReports := func() []domain.Report {
+		resp := app.Do(t, "GET", "/api/admin/reports", nil, withToken(moderator))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var reports []domain.Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&reports))
+		return reports
+	}
+
+	_, first := createUserWithToken(t, app.DB)
+	_, second := createUserWithToken(t, app.DB)
+	_, third := createUserWithToken(t, app.DB)
+
+	// 1. Reports need a known reason and are accepted once per user
+	resp = app.Do(t, "POST", pollPath+"/reports", map[string]string{"reason": "boring"}, withToken(first))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "validation_failed", problemCode(resp))
+
+	resp = app.Do(t, "POST", pollPath+"/reports", map[string]string{"reason": "spam"})
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	firstReport := report(first, "spam")
+	assert.Equal(t, domain.ReportStatusOpen, firstReport.Status)
+
+	resp = app.Do(t, "POST", pollPath+"/reports", map[string]string{"reason": "offensive"}, withToken(first))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "already_reported", problemCode(resp))
+
+	resp = app.Do(t, "POST", "/api/polls/"+uuid.New().String()+"/reports", map[string]string{"reason": "spam"}, withToken(first))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "poll_not_found", problemCode(resp))
+
+	// 2. Below the threshold the poll is still listed
+	assert.True(t, listed())
+
+	// 3. Reaching it leaves the poll out of listings, not out of direct links
+	secondReport := report(second, "spam")
+	assert.False(t, listed())
+
+	resp = app.Do(t, "GET", pollPath, nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 4. Only moderators see the queue
+	resp = app.Do(t, "GET", "/api/admin/reports", nil, withToken(first))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Len(t, openReports(), 2)
+
+	// 5. A dismissed report stops counting
+	resp = app.Do(t, "POST", "/api/admin/reports/"+firstReport.ID.String()+"/dismiss", map[string]string{"reason": "not spam"}, withToken(moderator))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.True(t, listed())
+	require.Len(t, openReports(), 1)
+
+	resp = app.Do(t, "POST", "/api/admin/reports/"+firstReport.ID.String()+"/dismiss", nil, withToken(moderator))
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "report_resolved", problemCode(resp))
+
+	// 6. Actioning a report hides the poll and resolves the other reports
+	thirdReport := report(third, "misleading")
+	resp = app.Do(t, "POST", "/api/admin/reports/"+secondReport.ID.String()+"/action", nil, withToken(moderator))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "GET", pollPath, nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Empty(t, openReports())
+
+	resp = app.Do(t, "GET", "/api/admin/reports?status=actioned", nil, withToken(moderator))
+	var actioned []domain.Report
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&actioned))
+	resp.Body.Close()
+	require.Len(t, actioned, 2)
+	ids := []uuid.UUID{actioned[0].ID, actioned[1].ID}
+	assert.ElementsMatch(t, []uuid.UUID{secondReport.ID, thirdReport.ID}, ids)
+	assert.NotNil(t, actioned[0].ResolvedBy)
+
+	resp = app.Do(t, "GET", "/api/admin/reports?status=pending", nil, withToken(moderator))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_report_status", problemCode(resp))
+
+	// 7. Moderation decisions are audited
+	var audited int
+	require.NoError(t, app.DB.QueryRow("SELECT COUNT(*) FROM audit_log WHERE action IN ('report.dismiss', 'report.action')").Scan(&audited))
+	assert.Equal(t, 2, audited)
+}
+
+func TestVoteAnalysis(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	_, admin := createUserWithRole(t, app.DB, domain.RoleAdmin)
+
+	body, _ := json.Marshal(map[string]any{"title": "Brigaded poll", "options": []string{"A", "B"}})
+	resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", strings.NewReader(string(body)))
+	require.NoError(t, err)
+	var poll domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+	resp.Body.Close()
+	optionA, optionB := poll.Options[0].ID, poll.Options[1].ID
+
+	users := make([]uuid.UUID, 14)
+	for i := 1; i < len(users); i++ {
+		users[i], _ = createUserWithToken(t, app.DB)
+	}
+	_, err = app.DB.Exec("UPDATE users SET created_at = NOW() - INTERVAL '1 day' WHERE id <> $1", users[11])
+	require.NoError(t, err)
+
+	base := time.Now().Add(-2 * time.Hour)
+	vote := func(userID, optionID uuid.UUID, ip string, at time.Time, retracted bool) {
+		var deletedAt *time.Time
+		if retracted {
+			deletedAt = &at
+		}
+		_, err := app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip, created_at, deleted_at) VALUES ($1, $2, $3, $4, $5, $6)`,
+			poll.ID, optionID, userID, ip, at, deletedAt)
+		require.NoError(t, err)
+	}
+
+	// Five accounts from one address, a minute apart
+	for i := 1; i <= 5; i++ {
+		vote(users[i], optionA, "9.9.9.9", base.Add(time.Duration(i)*time.Minute), false)
+	}
+	// Two more from its /24
+	vote(users[6], optionB, "9.9.9.10", base.Add(30*time.Minute), false)
+	vote(users[7], optionB, "9.9.9.11", base.Add(40*time.Minute), false)
+	// Three votes on B within twenty seconds
+	for i := 8; i <= 10; i++ {
+		vote(users[i], optionB, fmt.Sprintf("5.5.5.%d", i), base.Add(time.Hour+time.Duration(i)*time.Second), false)
+	}
+	// An account voting as soon as it was created
+	vote(users[11], optionA, "7.7.7.7", time.Now(), false)
+	// A user changing their vote over and over
+	for i := range 4 {
+		vote(users[12], []uuid.UUID{optionA, optionB}[(i+1)%2], "8.8.8.8", base.Add(90*time.Minute+time.Duration(i)*time.Minute), i < 3)
+	}
+	// Another one who ended up retracting, leaving no vote to flag
+	for i := range 4 {
+		vote(users[13], []uuid.UUID{optionA, optionB}[i%2], "6.6.6.6", base.Add(95*time.Minute+time.Duration(i)*5*time.Minute), true)
+	}
+
+	// Votes inserted directly lack the address hashes the server records,
+	// like those cast before it did, which the IP retention job fills in
+	retention := services.NewIPRetentionService(repo.NewIPRetentionRepository(app.DB), testIPPolicy)
+	_, err = retention.Apply(context.Background(), time.Now())
+	require.NoError(t, err)
+
+	// 1. Every rule flags the votes it is about, once
+	config := domain.VoteAnalysisConfig{
+		BurstVotes:        3,
+		BurstWindow:       time.Minute,
+		AccountsPerIP:     5,
+		AccountsPerSubnet: 7,
+		NewAccountAge:     10 * time.Minute,
+		FlipVotes:         4,
+		FlipWindow:        time.Hour,
+	}
+	analysis := services.NewVoteAnalysisService(repo.NewVoteFlagRepository(app.DB), config)
+	flagged, err := analysis.Analyze(context.Background(), base)
+	require.NoError(t, err)
+	assert.Equal(t, map[domain.VoteFlagRule]int64{
+		domain.VoteFlagBurst:        3,
+		domain.VoteFlagSharedIP:     5,
+		domain.VoteFlagSharedSubnet: 7,
+		domain.VoteFlagNewAccount:   1,
+		domain.VoteFlagFlipping:     1,
+	}, flagged)
+
+	flagged, err = analysis.Analyze(context.Background(), base)
+	require.NoError(t, err)
+	for rule, count := range flagged {
+		assert.Zero(t, count, rule)
+	}
+
+	// 2. Admins review the flags
+	resp = app.Do(t, "GET", "/api/admin/vote-flags", nil, withToken(admin))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var flags []domain.VoteFlag
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&flags))
+	resp.Body.Close()
+	require.Len(t, flags, 17)
+
+	byRule := map[domain.VoteFlagRule]domain.VoteFlag{}
+	for _, f := range flags {
+		byRule[f.Rule] = f
+	}
+	assert.Equal(t, users[11], byRule[domain.VoteFlagNewAccount].UserID)
+	assert.Equal(t, users[12], byRule[domain.VoteFlagFlipping].UserID)
+	assert.Equal(t, "9.9.9.0/24", byRule[domain.VoteFlagSharedSubnet].Details["subnet"])
+
+	resp = app.Do(t, "POST", "/api/admin/vote-flags/"+byRule[domain.VoteFlagBurst].ID.String()+"/dismiss", nil, withToken(admin))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "POST", "/api/admin/vote-flags/"+byRule[domain.VoteFlagNewAccount].ID.String()+"/invalidate", nil, withToken(admin))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "POST", "/api/admin/vote-flags/"+byRule[domain.VoteFlagNewAccount].ID.String()+"/invalidate", nil, withToken(admin))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = app.Do(t, "GET", "/api/admin/vote-flags?status=invalidated", nil, withToken(admin))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&flags))
+	resp.Body.Close()
+	require.Len(t, flags, 1)
+
+	// 3. Invalidated votes are left out of the results
+	require.NoError(t, app.SummarySvc.SummarizeAllVotes(context.Background()))
+
+	var status string
+	var retracted bool
+	err = app.DB.QueryRow("SELECT status, deleted_at IS NOT NULL FROM votes WHERE id = $1", byRule[domain.VoteFlagNewAccount].VoteID).Scan(&status, &retracted)
+	require.NoError(t, err)
+	assert.Equal(t, "invalid", status)
+	assert.False(t, retracted, "the vote still stands for its voter")
+
+	hasVoted, err := repo.NewVoteRepository(app.DB).HasVoted(context.Background(), poll.ID, domain.UserVoter(users[11]))
+	require.NoError(t, err)
+	assert.True(t, hasVoted)
+
+	counts := map[uuid.UUID]int{}
+	rows, err := app.DB.Query("SELECT option_id, vote_count FROM poll_results WHERE poll_id = $1", poll.ID)
+	require.NoError(t, err)
+	for rows.Next() {
+		var optionID uuid.UUID
+		var count int
+		require.NoError(t, rows.Scan(&optionID, &count))
+		counts[optionID] = count
+	}
+	require.NoError(t, rows.Err())
+	rows.Close()
+	assert.Equal(t, map[uuid.UUID]int{optionA: 6, optionB: 5}, counts)
+}
@@ -1,13 +1,24 @@
 package integration
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	handler "github.com/vncsmyrnk/poll/internal/adapters/handler/http"
+	"github.com/vncsmyrnk/poll/internal/adapters/jwtkeys"
 )
 
 func TestAuthFlow(t *testing.T) {
@@ -162,3 +173,799 @@ func TestLogout(t *testing.T) {
 	defer resp.Body.Close()
 	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
+
+func TestRefreshTokenRotation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	refresh := func(token string) *http.Response {
+		req, err := http.NewRequest("POST", app.Server.URL+"/auth/refresh", nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: token})
+		resp, err := app.Client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	cookieValue := func(resp *http.Response, name string) string {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == name && cookie.MaxAge >= 0 {
+				return cookie.Value
+			}
+		}
+		return ""
+	}
+
+	// 1. Login
+	form := url.Values{}
+	form.Add("credential", "valid_token")
+	resp, err := app.Client.PostForm(app.Server.URL+"/oauth/callback", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	firstToken := cookieValue(resp, "refresh_token")
+	require.NotEmpty(t, firstToken)
+
+	// 2. Every refresh hands out a new refresh token
+	resp = refresh(firstToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	secondToken := cookieValue(resp, "refresh_token")
+	require.NotEmpty(t, secondToken)
+	assert.NotEqual(t, firstToken, secondToken)
+
+	resp = refresh(secondToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	thirdToken := cookieValue(resp, "refresh_token")
+	require.NotEmpty(t, thirdToken)
+
+	var familySize, revoked int
+	err = app.DB.QueryRow("SELECT COUNT(DISTINCT family_id), COUNT(*) FILTER (WHERE revoked) FROM refresh_tokens").Scan(&familySize, &revoked)
+	require.NoError(t, err)
+	assert.Equal(t, 1, familySize, "rotated tokens stay in the same family")
+	assert.Equal(t, 2, revoked, "only the latest token stays active")
+
+	// 3. Replaying an already rotated token revokes the whole family
+	resp = refresh(firstToken)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = refresh(thirdToken)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode, "the legitimate token is revoked as well")
+
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM refresh_tokens WHERE NOT revoked").Scan(&revoked)
+	require.NoError(t, err)
+	assert.Equal(t, 0, revoked)
+
+	// 4. Logging in again starts a new family
+	resp, err = app.Client.PostForm(app.Server.URL+"/oauth/callback", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp = refresh(cookieValue(resp, "refresh_token"))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSessions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	login := func(userAgent string) map[string]string {
+		resp := app.Do(t, "POST", "/oauth/callback", url.Values{"credential": {"valid_token"}}, withHeader("User-Agent", userAgent))
+		resp.Body.Close()
+		require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+		cookies := map[string]string{}
+		for _, cookie := range resp.Cookies() {
+			cookies[cookie.Name] = cookie.Value
+		}
+		return cookies
+	}
+
+	laptop := login("laptop-browser")
+	phone := login("phone-browser")
+
+	// 1. Both logins are listed, the one making the request is current
+	resp := app.Do(t, "GET", "/api/me/sessions", nil, withCookies(phone))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var sessions []struct {
+		ID         string    `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		IPAddress  string    `json:"ip_address"`
+		Current    bool      `json:"current"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastUsedAt time.Time `json:"last_used_at"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	resp.Body.Close()
+	require.Len(t, sessions, 2)
+
+	byAgent := map[string]int{}
+	for i, s := range sessions {
+		byAgent[s.UserAgent] = i
+		assert.NotEmpty(t, s.IPAddress)
+		assert.False(t, s.LastUsedAt.Before(s.CreatedAt))
+	}
+	require.Contains(t, byAgent, "laptop-browser")
+	require.Contains(t, byAgent, "phone-browser")
+	assert.True(t, sessions[byAgent["phone-browser"]].Current)
+	assert.False(t, sessions[byAgent["laptop-browser"]].Current)
+	laptopSession := sessions[byAgent["laptop-browser"]].ID
+
+	// 2. A refresh keeps the session ID
+	resp = app.Do(t, "POST", "/auth/refresh", nil, withCookie(&http.Cookie{Name: "refresh_token", Value: phone["refresh_token"]}))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		phone[cookie.Name] = cookie.Value
+	}
+
+	resp = app.Do(t, "GET", "/api/me/sessions", nil, withCookies(phone))
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	resp.Body.Close()
+	require.Len(t, sessions, 2)
+
+	// 3. Revoking the laptop session kills its refresh token
+	resp = app.Do(t, "DELETE", "/api/me/sessions/"+laptopSession, nil, withCookies(phone))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "POST", "/auth/refresh", nil, withCookie(&http.Cookie{Name: "refresh_token", Value: laptop["refresh_token"]}))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = app.Do(t, "DELETE", "/api/me/sessions/"+laptopSession, nil, withCookies(phone))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = app.Do(t, "DELETE", "/api/me/sessions/not-a-uuid", nil, withCookies(phone))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 4. Revoking everything signs the phone out too
+	resp = app.Do(t, "POST", "/api/me/sessions/revoke-all", nil, withCookies(phone))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "POST", "/auth/refresh", nil, withCookie(&http.Cookie{Name: "refresh_token", Value: phone["refresh_token"]}))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = app.Do(t, "GET", "/api/me/sessions", nil, withCookies(phone))
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	resp.Body.Close()
+	assert.Empty(t, sessions)
+}
+
+func TestAccessTokenKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	userID, _ := createUserWithToken(t, app.DB)
+	claims := map[string]any{
+		"sub": userID.String(),
+		"exp": time.Now().Add(15 * time.Minute).Unix(),
+		"iat": time.Now().Unix(),
+	}
+
+	getMe := func(token string) int {
+		req, err := http.NewRequest("GET", app.Server.URL+"/api/me", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	signingKey, err := jwtkeys.NewKey(testSigningKey)
+	require.NoError(t, err)
+	retiredKey, err := jwtkeys.NewKey(testRetiredKey)
+	require.NoError(t, err)
+
+	// 1. The JWKS publishes both the signing and the retired key
+	resp, err := app.Client.Get(app.Server.URL + "/.well-known/jwks.json")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var jwks jwtkeys.JWKS
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&jwks))
+	resp.Body.Close()
+
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, signingKey.ID, jwks.Keys[0].Kid)
+	assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+	assert.Equal(t, "EdDSA", jwks.Keys[0].Alg)
+	assert.Equal(t, retiredKey.ID, jwks.Keys[1].Kid)
+	assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+	assert.Empty(t, jwks.Keys[1].Crv)
+
+	// 2. Tokens issued on login carry the kid of the signing key
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	form := url.Values{}
+	form.Add("credential", "valid_token")
+	resp, err = app.Client.PostForm(app.Server.URL+"/oauth/callback", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, accessToken)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(accessToken, jwt.MapClaims{})
+	require.NoError(t, err)
+	assert.Equal(t, signingKey.ID, parsed.Header["kid"])
+	assert.Equal(t, http.StatusOK, getMe(accessToken))
+
+	// 3. Tokens signed by the retired key are still accepted
+	retired, err := jwtkeys.NewKeySet(retiredKey)
+	require.NoError(t, err)
+	token, err := retired.Sign(claims)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, getMe(token))
+
+	// 4. Unknown keys and shared secrets are rejected
+	_, otherPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := jwtkeys.NewKey(otherPrivate)
+	require.NoError(t, err)
+	other, err := jwtkeys.NewKeySet(otherKey)
+	require.NoError(t, err)
+	token, err = other.Sign(claims)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, getMe(token))
+
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims)).SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, getMe(token))
+
+	// 5. A token claiming the kid of the signing key with another algorithm
+	// is rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims))
+	forged.Header["kid"] = signingKey.ID
+	token, err = forged.SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, getMe(token))
+}
+
+func TestOIDCLogin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	callback := func(provider, idToken string) *http.Response {
+		form := url.Values{}
+		form.Add("id_token", idToken)
+		resp, err := app.Client.PostForm(app.Server.URL+"/oauth/"+provider+"/callback", form)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// 1. A token from the configured issuer logs the user in
+	resp := callback("stub", app.Issuer.IDToken(t, "oidc@example.com", nil))
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	var accessToken string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "access_token" {
+			accessToken = cookie.Value
+		}
+	}
+	require.NotEmpty(t, accessToken)
+
+	var name string
+	err := app.DB.QueryRow("SELECT name FROM users WHERE email = $1", "oidc@example.com").Scan(&name)
+	require.NoError(t, err)
+	assert.Equal(t, "Stub user", name)
+
+	// 2. Tokens that do not belong to us are rejected
+	rejected := map[string]map[string]any{
+		"wrong audience":     {"aud": "another-client"},
+		"wrong issuer":       {"iss": "https://evil.example.com"},
+		"expired":            {"exp": time.Now().Add(-time.Minute).Unix()},
+		"missing expiration": {"exp": nil},
+	}
+	for name, overrides := range rejected {
+		resp = callback("stub", app.Issuer.IDToken(t, "oidc@example.com", overrides))
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, name)
+	}
+
+	// 3. Accounts are not created for unverified addresses
+	resp = callback("stub", app.Issuer.IDToken(t, "unverified@example.com", map[string]any{"email_verified": false}))
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// 4. Tokens signed by keys the issuer does not publish are rejected
+	forged, err := testKeys.Sign(map[string]any{
+		"iss":   app.Issuer.Server.URL,
+		"aud":   stubClientID,
+		"email": "oidc@example.com",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	})
+	require.NoError(t, err)
+	resp = callback("stub", forged)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// 5. Unknown providers are reported as such
+	resp = callback("unknown", app.Issuer.IDToken(t, "oidc@example.com", nil))
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAuthCodeLogin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	get := func(path string, cookies ...*http.Cookie) *http.Response {
+		req, err := http.NewRequest("GET", app.Server.URL+path, nil)
+		require.NoError(t, err)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := app.Client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	findCookie := func(resp *http.Response, name string) *http.Cookie {
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == name {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	// 1. Login redirects to the provider with state and a PKCE challenge
+	resp := get("/oauth/github/login")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := resp.Location()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(location.String(), app.GitHub.Server.URL+"/login/oauth/authorize"))
+
+	params := location.Query()
+	assert.Equal(t, "S256", params.Get("code_challenge_method"))
+	assert.Equal(t, "https://poll.example.com/oauth/github/callback", params.Get("redirect_uri"))
+	state := params.Get("state")
+	require.NotEmpty(t, state)
+	require.NotEmpty(t, params.Get("code_challenge"))
+
+	flowCookie := findCookie(resp, "oauth_flow")
+	require.NotNil(t, flowCookie)
+	assert.True(t, flowCookie.HttpOnly)
+
+	// 2. The callback checks the state, redeems the code and logs in
+	code := app.GitHub.Authorize(params.Get("code_challenge"), params.Get("redirect_uri"))
+
+	resp = get("/oauth/github/callback?state=wrong&code="+code, flowCookie)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = get("/oauth/github/callback?state=" + state + "&code=" + code)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "missing flow cookie")
+
+	tampered := *flowCookie
+	tampered.Value = "x" + tampered.Value
+	resp = get("/oauth/github/callback?state="+state+"&code="+code, &tampered)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = get("/oauth/github/callback?state="+state+"&code="+code, flowCookie)
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	location, err = resp.Location()
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/redirect", location.String())
+	require.NotNil(t, findCookie(resp, "access_token"))
+	require.NotNil(t, findCookie(resp, "refresh_token"))
+
+	var name string
+	err = app.DB.QueryRow("SELECT name FROM users WHERE email = $1", "octocat@example.com").Scan(&name)
+	require.NoError(t, err)
+	assert.Equal(t, "octocat", name)
+
+	// 3. A code cannot be redeemed twice
+	resp = get("/oauth/github/callback?state="+state+"&code="+code, flowCookie)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// 4. Providers without this flow are refused
+	resp = get("/oauth/stub/login")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = get("/oauth/unknown/login")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestEmailLogin(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	start := func(email string) int {
+		resp, err := app.Client.Post(app.Server.URL+"/auth/email/start", "application/json", strings.NewReader(`{"email": "`+email+`"}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	linkPattern := regexp.MustCompile(`https://poll\.example\.com/auth/email/verify\?token=(\S+)`)
+	lastToken := func() string {
+		content, err := os.ReadFile(app.MailFile)
+		require.NoError(t, err)
+		matches := linkPattern.FindAllStringSubmatch(string(content), -1)
+		require.NotEmpty(t, matches)
+		token, err := url.QueryUnescape(matches[len(matches)-1][1])
+		require.NoError(t, err)
+		return token
+	}
+
+	verify := func(token string) *http.Response {
+		resp, err := app.Client.Get(app.Server.URL + "/auth/email/verify?" + url.Values{"token": {token}}.Encode())
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// 1. Invalid addresses are rejected
+	assert.Equal(t, http.StatusUnprocessableEntity, start("not-an-email"))
+
+	// 2. The emailed link logs the user in and creates the account
+	require.Equal(t, http.StatusAccepted, start("Magic@Example.com"))
+	token := lastToken()
+
+	resp := verify(token)
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	location, err := resp.Location()
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/redirect", location.String())
+
+	cookies := map[string]string{}
+	for _, cookie := range resp.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	assert.NotEmpty(t, cookies["access_token"])
+	assert.NotEmpty(t, cookies["refresh_token"])
+
+	var name string
+	err = app.DB.QueryRow("SELECT name FROM users WHERE email = $1", "magic@example.com").Scan(&name)
+	require.NoError(t, err)
+	assert.Equal(t, "magic", name)
+
+	// 3. Links work only once
+	resp = verify(token)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// 4. Expired links are rejected
+	require.Equal(t, http.StatusAccepted, start("magic@example.com"))
+	token = lastToken()
+	_, err = app.DB.Exec("UPDATE email_login_tokens SET expires_at = NOW() - INTERVAL '1 minute' WHERE used_at IS NULL")
+	require.NoError(t, err)
+	resp = verify(token)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// 5. Logging in again reuses the account
+	require.Equal(t, http.StatusAccepted, start("magic@example.com"))
+	resp = verify(lastToken())
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	var users int
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1", "magic@example.com").Scan(&users)
+	require.NoError(t, err)
+	assert.Equal(t, 1, users)
+
+	// 6. Links to one address are limited whichever IP asks for them and
+	// however the address is written
+	resp = app.Do(t, "POST", "/auth/email/start", map[string]string{"email": " MAGIC@example.com"}, withHeader("X-Forwarded-For", "203.0.113.7"))
+	resp.Body.Close()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	resp = app.Do(t, "POST", "/auth/email/start", map[string]string{"email": "magic@example.com"}, withHeader("X-Forwarded-For", "198.51.100.9"))
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	var problem handler.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	resp.Body.Close()
+	assert.Equal(t, "rate_limited", problem.Code)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	assert.Equal(t, http.StatusAccepted, start("other@example.com"))
+}
+
+func TestAccountLinking(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	login := func(provider, credential string) (int, string) {
+		resp := app.Do(t, "POST", "/oauth/"+provider+"/callback", url.Values{"credential": {credential}})
+		resp.Body.Close()
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == "access_token" {
+				return resp.StatusCode, cookie.Value
+			}
+		}
+		return resp.StatusCode, ""
+	}
+
+	userOf := func(accessToken string) string {
+		claims := jwt.MapClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(accessToken, claims)
+		require.NoError(t, err)
+		return claims["sub"].(string)
+	}
+
+	identities := func(accessToken string) []map[string]any {
+		resp := app.Do(t, "GET", "/api/me/identities", nil, withToken(accessToken))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var list []map[string]any
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		return list
+	}
+
+	// 1. A matching email at another provider does not give access
+	status, owner := login("google", "valid_token")
+	require.Equal(t, http.StatusSeeOther, status)
+	ownerID := userOf(owner)
+
+	stubToken := app.Issuer.IDToken(t, "test@example.com", nil)
+	status, _ = login("stub", stubToken)
+	assert.Equal(t, http.StatusConflict, status)
+	status, _ = login("stub", app.Issuer.IDToken(t, "Test@Example.COM", nil))
+	assert.Equal(t, http.StatusConflict, status, "emails match whatever their case")
+
+	// 2. Once linked from the account, the provider logs into it
+	resp := app.Do(t, "POST", "/api/me/identities/stub", url.Values{"id_token": {stubToken}}, withToken(owner))
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	status, token := login("stub", app.Issuer.IDToken(t, "test@example.com", nil))
+	require.Equal(t, http.StatusSeeOther, status)
+	assert.Equal(t, ownerID, userOf(token))
+
+	// 3. An identity belongs to a single account
+	_, other := createUserWithToken(t, app.DB)
+	resp = app.Do(t, "POST", "/api/me/identities/stub", url.Values{"id_token": {stubToken}}, withToken(other))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// 4. Authorization-code providers are linked through the login flow
+	resp = app.Do(t, "GET", "/oauth/github/login?link=true", nil)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "linking requires a session")
+
+	resp = app.Do(t, "GET", "/oauth/github/login?link=true", nil, withToken(owner))
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := resp.Location()
+	require.NoError(t, err)
+	var flowCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "oauth_flow" {
+			flowCookie = cookie
+		}
+	}
+	require.NotNil(t, flowCookie)
+	params := location.Query()
+	code := app.GitHub.Authorize(params.Get("code_challenge"), params.Get("redirect_uri"))
+
+	req, err := http.NewRequest("GET", app.Server.URL+"/oauth/github/callback?"+url.Values{"code": {code}, "state": {params.Get("state")}}.Encode(), nil)
+	require.NoError(t, err)
+	req.AddCookie(flowCookie)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: owner})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	linked := identities(owner)
+	require.Len(t, linked, 3)
+	providers := map[string]string{}
+	for _, identity := range linked {
+		providers[identity["provider"].(string)] = identity["id"].(string)
+	}
+	assert.Contains(t, providers, "google")
+	assert.Contains(t, providers, "stub")
+	assert.Contains(t, providers, "github")
+
+	// 5. Unlinking removes access through that provider, except for the last
+	resp = app.Do(t, "DELETE", "/api/me/identities/"+providers["stub"], nil, withToken(owner))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	status, _ = login("stub", app.Issuer.IDToken(t, "test@example.com", nil))
+	assert.Equal(t, http.StatusConflict, status)
+
+	resp = app.Do(t, "DELETE", "/api/me/identities/"+providers["stub"], nil, withToken(owner))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = app.Do(t, "DELETE", "/api/me/identities/"+providers["github"], nil, withToken(owner))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = app.Do(t, "DELETE", "/api/me/identities/"+providers["google"], nil, withToken(owner))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// 6. Accounts from before identities existed are claimed on first login
+	legacyID, _ := createUserWithToken(t, app.DB)
+	status, token = login("stub", app.Issuer.IDToken(t, fmt.Sprintf("user-%s@example.com", legacyID), nil))
+	require.Equal(t, http.StatusSeeOther, status)
+	assert.Equal(t, legacyID.String(), userOf(token))
+}
+
+func TestAPITokens(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	userID, session := createUserWithToken(t, app.DB)
+
+	problemCode := func(resp *http.Response) string {
+		defer resp.Body.Close()
+		var problem struct {
+			Code string `json:"code"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		return problem.Code
+	}
+
+	// 1. A token is created with the requested scopes and returned once
+	resp := app.Do(t, "POST", "/api/me/tokens", map[string]any{
+		"name":   "ci bot",
+		"scopes": []string{"polls:write", "results:read"},
+	}, withBearer(session))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var created struct {
+		ID     string   `json:"id"`
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		Token  string   `json:"token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	assert.Equal(t, "ci bot", created.Name)
+	assert.ElementsMatch(t, []string{"polls:write", "results:read"}, created.Scopes)
+	require.True(t, strings.HasPrefix(created.Token, "poll_pat_"))
+	bot := created.Token
+
+	var stored string
+	require.NoError(t, app.DB.QueryRow("SELECT token_hash FROM api_tokens WHERE id = $1", created.ID).Scan(&stored))
+	assert.NotEqual(t, bot, stored)
+
+	// 2. Unknown scopes and past expirations are rejected
+	resp = app.Do(t, "POST", "/api/me/tokens", map[string]any{
+		"name":       "bad",
+		"scopes":     []string{"polls:delete"},
+		"expires_at": time.Now().Add(-time.Hour),
+	}, withBearer(session))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "validation_failed", problemCode(resp))
+
+	// 3. The token creates polls on behalf of its owner
+	resp = app.Do(t, "POST", "/api/polls", map[string]any{
+		"title":   "Bot poll",
+		"options": []string{"A", "B"},
+	}, withBearer(bot))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var poll struct {
+		ID        string `json:"id"`
+		CreatedBy string `json:"created_by"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+	resp.Body.Close()
+	assert.Equal(t, userID.String(), poll.CreatedBy)
+
+	// 4. ...and reads results
+	resp = app.Do(t, "GET", "/api/polls/"+poll.ID+"/count", nil, withBearer(bot))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 5. Routes outside its scopes are forbidden
+	resp = app.Do(t, "POST", "/api/polls/"+poll.ID+"/votes", map[string]any{"option_id": uuid.New()}, withBearer(bot))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "insufficient_scope", problemCode(resp))
+
+	// 6. Tokens cannot manage tokens nor the account
+	resp = app.Do(t, "POST", "/api/me/tokens", map[string]any{"name": "child", "scopes": []string{"votes:write"}}, withBearer(bot))
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "insufficient_scope", problemCode(resp))
+	resp = app.Do(t, "GET", "/api/me/sessions", nil, withBearer(bot))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// 7. The listing shows usage but never the secret
+	resp = app.Do(t, "GET", "/api/me/tokens", nil, withBearer(session))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var tokens []map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokens))
+	resp.Body.Close()
+	require.Len(t, tokens, 1)
+	assert.Equal(t, created.ID, tokens[0]["id"])
+	assert.NotNil(t, tokens[0]["last_used_at"])
+	assert.NotContains(t, tokens[0], "token")
+	assert.NotContains(t, tokens[0], "token_hash")
+
+	// 8. Another user cannot revoke it
+	_, other := createUserWithToken(t, app.DB)
+	resp = app.Do(t, "DELETE", "/api/me/tokens/"+created.ID, nil, withBearer(other))
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "api_token_not_found", problemCode(resp))
+
+	// 9. A revoked token is refused, even where anonymous access is allowed
+	resp = app.Do(t, "DELETE", "/api/me/tokens/"+created.ID, nil, withBearer(session))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = app.Do(t, "GET", "/api/polls/"+poll.ID+"/count", nil, withBearer(bot))
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_api_token", problemCode(resp))
+
+	resp = app.Do(t, "POST", "/api/polls", map[string]any{"title": "Anon", "options": []string{"A", "B"}}, withBearer(bot))
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_api_token", problemCode(resp))
+
+	// 10. Expired tokens are refused
+	expiresAt := time.Now().Add(time.Hour)
+	resp = app.Do(t, "POST", "/api/me/tokens", map[string]any{
+		"name":       "short lived",
+		"scopes":     []string{"results:read"},
+		"expires_at": expiresAt,
+	}, withBearer(session))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+
+	_, err := app.DB.Exec("UPDATE api_tokens SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1", created.ID)
+	require.NoError(t, err)
+	resp = app.Do(t, "GET", "/api/polls/"+poll.ID+"/count", nil, withBearer(created.Token))
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "invalid_api_token", problemCode(resp))
+}
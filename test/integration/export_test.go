@@ -0,0 +1,179 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+)
+
+func TestExportPoll(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	// 1. Create a poll as the creator
+	_, creatorToken := createUserWithToken(t, app.DB)
+	body, _ := json.Marshal(map[string]interface{}{
+		"title":   "Export Poll",
+		"options": []string{"Opt1", "Opt2"},
+	})
+	req, err := http.NewRequest("POST", app.Server.URL+"/api/polls", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err := app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var poll domain.Poll
+	json.NewDecoder(resp.Body).Decode(&poll)
+	resp.Body.Close()
+
+	// 2. Two voters
+	voterID, voterToken := createUserWithToken(t, app.DB)
+	otherID := uuid.New()
+	_, err = app.DB.Exec("INSERT INTO users (id, email, name) VALUES ($1, 'export@ex.com', 'Other')", otherID)
+	require.NoError(t, err)
+	_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip) VALUES ($1, $2, $3, '5.5.5.1'), ($1, $4, $5, '5.5.5.2')`,
+		poll.ID, poll.Options[0].ID, voterID, poll.Options[1].ID, otherID)
+	require.NoError(t, err)
+	err = app.SummarySvc.SummarizeAllVotes(context.Background())
+	require.NoError(t, err)
+
+	exportURL := func(format string) string {
+		return fmt.Sprintf("%s/api/polls/%s/export?format=%s", app.Server.URL, poll.ID, format)
+	}
+
+	// 3. The creator gets results and anonymized ballots as CSV
+	req, err = http.NewRequest("GET", exportURL("csv"), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Len(t, records, 5, "header, two results and two ballots")
+	assert.Equal(t, "record_type", records[0][0])
+	var ballotHashes []string
+	for _, rec := range records[1:] {
+		switch rec[0] {
+		case "result":
+			assert.Equal(t, "1", rec[3])
+		case "ballot":
+			ballotHashes = append(ballotHashes, rec[5])
+		}
+	}
+	require.Len(t, ballotHashes, 2)
+	for _, h := range ballotHashes {
+		assert.Len(t, h, 64)
+		assert.NotContains(t, h, voterID.String())
+		assert.NotContains(t, h, otherID.String())
+	}
+
+	// 4. NDJSON carries the same records
+	req, err = http.NewRequest("GET", exportURL("ndjson"), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	types := map[string]int{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		types[line["type"].(string)]++
+	}
+	resp.Body.Close()
+	assert.Equal(t, map[string]int{"result": 2, "ballot": 2}, types)
+
+	// 5. A voter only gets the aggregated results
+	req, err = http.NewRequest("GET", exportURL("json"), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: voterToken})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var export struct {
+		Results []domain.OptionResult `json:"results"`
+		Ballots []domain.Ballot       `json:"ballots"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&export)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Len(t, export.Results, 2)
+	assert.Empty(t, export.Ballots)
+
+	// 6. Users who did not vote cannot export, and unknown formats are rejected
+	req, err = http.NewRequest("GET", exportURL("csv"), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: createUserAndToken(t, app.DB)})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req, err = http.NewRequest("GET", exportURL("xml"), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 7. Secret ballots are only dated to the day they were cast
+	body, _ = json.Marshal(map[string]interface{}{
+		"title":         "Secret Export Poll",
+		"options":       []string{"Opt1", "Opt2"},
+		"secret_ballot": true,
+	})
+	req, err = http.NewRequest("POST", app.Server.URL+"/api/polls", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var secret domain.Poll
+	json.NewDecoder(resp.Body).Decode(&secret)
+	resp.Body.Close()
+
+	castAt := time.Date(2026, 3, 4, 15, 16, 17, 0, time.UTC)
+	_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, receipt_hash, created_at) VALUES ($1, $2, $3, $4)`,
+		secret.ID, secret.Options[0].ID, strings.Repeat("ab", 32), castAt)
+	require.NoError(t, err)
+
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/api/polls/%s/export?format=json", app.Server.URL, secret.ID), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	export.Ballots = nil
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&export))
+	resp.Body.Close()
+	require.Len(t, export.Ballots, 1)
+	assert.True(t, export.Ballots[0].CastAt.Equal(time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)))
+	assert.Empty(t, export.Ballots[0].VoterHash)
+}
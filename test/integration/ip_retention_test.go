@@ -0,0 +1,144 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	repo "github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+	"github.com/vncsmyrnk/poll/internal/core/services"
+)
+
+func TestIPRetention(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+	ctx := context.Background()
+
+	body, _ := json.Marshal(map[string]any{"title": "Keep or forget?", "options": []string{"Keep", "Forget"}})
+	resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var poll domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+	resp.Body.Close()
+
+	// Three accounts voted from one address long ago, before votes
+	// recorded address hashes, and one did yesterday
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	insert := func(ip string, at time.Time) uuid.UUID {
+		userID, _ := createUserWithToken(t, app.DB)
+		var id uuid.UUID
+		err := app.DB.QueryRow(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			poll.ID, poll.Options[0].ID, userID, ip, at).Scan(&id)
+		require.NoError(t, err)
+		return id
+	}
+	oldVotes := []uuid.UUID{insert("198.51.100.7", old), insert("198.51.100.7", old), insert("198.51.100.7", old)}
+	recent := insert("198.51.100.8", time.Now().Add(-24*time.Hour))
+
+	voterIP := func(voteID uuid.UUID) (ip, hash, subnetHash sql.NullString) {
+		err := app.DB.QueryRow("SELECT host(voter_ip), voter_ip_hash, voter_subnet_hash FROM votes WHERE id = $1", voteID).Scan(&ip, &hash, &subnetHash)
+		require.NoError(t, err)
+		return ip, hash, subnetHash
+	}
+	retention := func(anonymization domain.IPAnonymization) ports.IPRetentionService {
+		policy := testIPPolicy
+		policy.Anonymization = anonymization
+		policy.RetainFor = 30 * 24 * time.Hour
+		return services.NewIPRetentionService(repo.NewIPRetentionRepository(app.DB), policy)
+	}
+
+	// 1. Hashing hashes every address first, then removes the old ones
+	result, err := retention(domain.IPHash).Apply(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, &domain.IPRetentionResult{Hashed: 4, Anonymized: 3}, result)
+
+	ip, hash, _ := voterIP(oldVotes[0])
+	assert.False(t, ip.Valid)
+	assert.Equal(t, testIPPolicy.HashIP("198.51.100.7"), hash.String)
+	ip, _, _ = voterIP(recent)
+	assert.Equal(t, "198.51.100.8", ip.String)
+
+	result, err = retention(domain.IPHash).Apply(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, &domain.IPRetentionResult{}, result, "a second run has nothing left to do")
+
+	// 2. Abuse detection still groups the votes by their address and network
+	analysis := services.NewVoteAnalysisService(repo.NewVoteFlagRepository(app.DB), domain.VoteAnalysisConfig{AccountsPerIP: 3, AccountsPerSubnet: 4})
+	flagged, err := analysis.Analyze(ctx, old.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), flagged[domain.VoteFlagSharedIP])
+	assert.Equal(t, int64(4), flagged[domain.VoteFlagSharedSubnet])
+
+	// 3. Truncation keeps the network of the address and only its hash
+	result, err = retention(domain.IPTruncate).Apply(ctx, time.Now().Add(365*24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, &domain.IPRetentionResult{Anonymized: 1}, result)
+
+	ip, hash, subnetHash := voterIP(recent)
+	assert.Equal(t, "198.51.100.0", ip.String)
+	assert.False(t, hash.Valid)
+	assert.Equal(t, testIPPolicy.HashSubnet("198.51.100.8"), subnetHash.String)
+
+	result, err = retention(domain.IPTruncate).Apply(ctx, time.Now().Add(365*24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, &domain.IPRetentionResult{}, result, "a truncated address is not hashed again")
+
+	// 4. Flags lose the address along with their vote
+	result, err = retention(domain.IPHash).Apply(ctx, time.Now().Add(365*24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Anonymized)
+	var rawFlags int
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM vote_flags WHERE voter_ip IS NOT NULL").Scan(&rawFlags)
+	require.NoError(t, err)
+	assert.Zero(t, rawFlags)
+
+	// 5. With no retention period, raw addresses are never stored, and
+	// anonymous votes are still capped per address
+	policy := testIPPolicy
+	policy.Anonymization = domain.IPHash
+	voteSvc := services.NewVoteService(repo.NewPollRepository(app.DB), repo.NewVoteRepository(app.DB), nil, 1, policy, repo.NewTransactor(app.DB))
+
+	userID, _ := createUserWithToken(t, app.DB)
+	_, err = voteSvc.Vote(ctx, ports.VoteInput{PollID: poll.ID, OptionID: poll.Options[1].ID, Voter: domain.UserVoter(userID), VoterIP: "192.0.2.1"})
+	require.NoError(t, err)
+	var stored sql.NullString
+	var storedHash string
+	err = app.DB.QueryRow("SELECT voter_ip::text, voter_ip_hash FROM votes WHERE user_id = $1", userID).Scan(&stored, &storedHash)
+	require.NoError(t, err)
+	assert.False(t, stored.Valid)
+	assert.Equal(t, policy.HashIP("192.0.2.1"), storedHash)
+
+	_, err = app.DB.Exec("UPDATE polls SET allow_anonymous = TRUE WHERE id = $1", poll.ID)
+	require.NoError(t, err)
+	_, err = voteSvc.Vote(ctx, ports.VoteInput{PollID: poll.ID, OptionID: poll.Options[1].ID, Voter: domain.DeviceVoter(uuid.New()), VoterIP: "192.0.2.1"})
+	require.NoError(t, err)
+	_, err = voteSvc.Vote(ctx, ports.VoteInput{PollID: poll.ID, OptionID: poll.Options[1].ID, Voter: domain.DeviceVoter(uuid.New()), VoterIP: "192.0.2.1"})
+	assert.ErrorIs(t, err, domain.ErrIPVoteLimit)
+
+	// 6. Truncating with no retention period stores the network and its
+	// hash, but not the hash of the address
+	policy.Anonymization = domain.IPTruncate
+	voteSvc = services.NewVoteService(repo.NewPollRepository(app.DB), repo.NewVoteRepository(app.DB), nil, 1, policy, repo.NewTransactor(app.DB))
+
+	userID, _ = createUserWithToken(t, app.DB)
+	_, err = voteSvc.Vote(ctx, ports.VoteInput{PollID: poll.ID, OptionID: poll.Options[1].ID, Voter: domain.UserVoter(userID), VoterIP: "192.0.2.9"})
+	require.NoError(t, err)
+	var voteID uuid.UUID
+	require.NoError(t, app.DB.QueryRow("SELECT id FROM votes WHERE user_id = $1", userID).Scan(&voteID))
+	ip, hash, subnetHash = voterIP(voteID)
+	assert.Equal(t, "192.0.2.0", ip.String)
+	assert.False(t, hash.Valid)
+	assert.Equal(t, policy.HashSubnet("192.0.2.9"), subnetHash.String)
+}
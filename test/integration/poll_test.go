@@ -6,16 +6,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	repo "github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+	"github.com/vncsmyrnk/poll/internal/core/services"
 )
 
 // TestPollFlow tests the basic lifecycle: Create Poll -> Get Poll -> Vote -> Prevent Duplicate Vote
@@ -155,14 +158,12 @@ func TestVoteSummarization(t *testing.T) {
 
 	// Verify Count
 	// We need to authenticate as a user who has voted (e.g., u1)
-	claims := jwt.MapClaims{
+	signedToken, err := testKeys.Sign(map[string]any{
 		"sub":   u1.String(),
 		"email": "u1@ex.com",
 		"exp":   time.Now().Add(15 * time.Minute).Unix(),
 		"iat":   time.Now().Unix(),
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte("test-secret"))
+	})
 	require.NoError(t, err)
 
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/polls/%s/count", app.Server.URL, poll.ID), nil)
@@ -210,14 +211,12 @@ func TestVoteSummarization(t *testing.T) {
 	req, err = http.NewRequest("GET", fmt.Sprintf("%s/api/polls/%s/count", app.Server.URL, poll.ID), nil)
 	require.NoError(t, err)
 	// Use u2's token since u1's vote is now deleted
-	claims = jwt.MapClaims{
+	signedToken, err = testKeys.Sign(map[string]any{
 		"sub":   u2.String(),
 		"email": "u2@ex.com",
 		"exp":   time.Now().Add(15 * time.Minute).Unix(),
 		"iat":   time.Now().Unix(),
-	}
-	token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err = token.SignedString([]byte("test-secret"))
+	})
 	require.NoError(t, err)
 	req.AddCookie(&http.Cookie{Name: "access_token", Value: signedToken})
 
@@ -387,3 +386,428 @@ func TestListPollsSorted(t *testing.T) {
 	assert.Equal(t, "Poll C", list[1].Title) // 5 votes
 	assert.Equal(t, "Poll A", list[2].Title) // 0 votes
 }
+
+// TestListMyPolls checks that the dashboard only lists the caller's polls along with their analytics
+func TestListMyPolls(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	creatorID, creatorToken := createUserWithToken(t, app.DB)
+
+	// 1. Create one poll as the creator and one anonymously
+	body, _ := json.Marshal(map[string]interface{}{
+		"title":       "Creator Poll",
+		"description": "Owned by the creator",
+		"options":     []string{"Opt1", "Opt2"},
+	})
+	req, err := http.NewRequest("POST", app.Server.URL+"/api/polls", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err := app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var poll domain.Poll
+	json.NewDecoder(resp.Body).Decode(&poll)
+	resp.Body.Close()
+	require.NotNil(t, poll.CreatedBy)
+	assert.Equal(t, creatorID, *poll.CreatedBy)
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"title":   "Anonymous Poll",
+		"options": []string{"Opt1", "Opt2"},
+	})
+	resp, err = app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp.Body.Close()
+
+	// 2. Three votes, two of them processed and one still pending
+	for i := 0; i < 3; i++ {
+		uid := uuid.New()
+		_, err := app.DB.Exec("INSERT INTO users (id, email, name) VALUES ($1, $2, 'Voter')", uid, fmt.Sprintf("voter-%d@ex.com", i))
+		require.NoError(t, err)
+		_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip) VALUES ($1, $2, $3, $4)`,
+			poll.ID, poll.Options[i%2].ID, uid, fmt.Sprintf("3.3.3.%d", i))
+		require.NoError(t, err)
+
+		if i == 1 {
+			err = app.SummarySvc.SummarizeAllVotes(context.Background())
+			require.NoError(t, err)
+		}
+	}
+
+	// A retracted vote is no longer a voter
+	retractedID := uuid.New()
+	_, err = app.DB.Exec("INSERT INTO users (id, email, name) VALUES ($1, 'retracted@ex.com', 'Voter')", retractedID)
+	require.NoError(t, err)
+	_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip, deleted_at) VALUES ($1, $2, $3, '3.3.3.9', NOW())`,
+		poll.ID, poll.Options[0].ID, retractedID)
+	require.NoError(t, err)
+
+	// 3. Fetch the dashboard
+	req, err = http.NewRequest("GET", app.Server.URL+"/api/me/polls", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: creatorToken})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var analytics []domain.PollAnalytics
+	err = json.NewDecoder(resp.Body).Decode(&analytics)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Len(t, analytics, 1)
+	a := analytics[0]
+	assert.Equal(t, poll.ID, a.PollID)
+	assert.Equal(t, domain.PollStatusOpen, a.Status)
+	assert.Equal(t, int64(2), a.TotalVotes)
+	assert.Equal(t, int64(3), a.UniqueVoters)
+	assert.Equal(t, int64(1), a.PendingVotes)
+	assert.NotNil(t, a.LastVoteAt)
+
+	// 4. Anonymous callers have no dashboard
+	resp, err = app.Client.Get(app.Server.URL + "/api/me/polls")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+// TestPollTimeline checks that votes are bucketed over time and retractions are subtracted
+func TestPollTimeline(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"title":   "Timeline Poll",
+		"options": []string{"Opt1", "Opt2"},
+	})
+	resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var poll domain.Poll
+	json.NewDecoder(resp.Body).Decode(&poll)
+	resp.Body.Close()
+
+	opt1 := poll.Options[0].ID
+	opt2 := poll.Options[1].ID
+	base := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// 10:05 and 10:30 two votes for Opt1, 11:10 the first one is retracted, 11:20 one vote for Opt2
+	u1, token := createUserWithToken(t, app.DB)
+	u2 := uuid.New()
+	u3 := uuid.New()
+	_, err = app.DB.Exec("INSERT INTO users (id, email, name) VALUES ($1, 'tl2@ex.com', 'U2'), ($2, 'tl3@ex.com', 'U3')", u2, u3)
+	require.NoError(t, err)
+
+	_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip, created_at, deleted_at) VALUES ($1, $2, $3, '4.4.4.1', $4, $5)`,
+		poll.ID, opt1, u1, base.Add(5*time.Minute), base.Add(70*time.Minute))
+	require.NoError(t, err)
+	_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip, created_at) VALUES ($1, $2, $3, '4.4.4.2', $4)`,
+		poll.ID, opt1, u2, base.Add(30*time.Minute))
+	require.NoError(t, err)
+	_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip, created_at) VALUES ($1, $2, $3, '4.4.4.3', $4)`,
+		poll.ID, opt2, u3, base.Add(80*time.Minute))
+	require.NoError(t, err)
+
+	// The caller still needs an active vote to see the timeline
+	_, err = app.DB.Exec(`INSERT INTO votes (poll_id, option_id, user_id, voter_ip, created_at) VALUES ($1, $2, $3, '4.4.4.1', $4)`,
+		poll.ID, opt2, u1, base.Add(80*time.Minute))
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/polls/%s/timeline?bucket=hour", app.Server.URL, poll.ID), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var points []domain.PollTimelinePoint
+	err = json.NewDecoder(resp.Body).Decode(&points)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	type key struct {
+		bucket time.Time
+		option uuid.UUID
+	}
+	got := make(map[key]domain.PollTimelinePoint)
+	for _, p := range points {
+		got[key{p.BucketStart.UTC(), p.OptionID}] = p
+	}
+	require.Len(t, got, 3)
+
+	p := got[key{base, opt1}]
+	assert.Equal(t, int64(2), p.Votes)
+	assert.Equal(t, int64(2), p.Total)
+
+	p = got[key{base.Add(time.Hour), opt1}]
+	assert.Equal(t, int64(-1), p.Votes)
+	assert.Equal(t, int64(1), p.Total)
+
+	p = got[key{base.Add(time.Hour), opt2}]
+	assert.Equal(t, int64(2), p.Votes)
+	assert.Equal(t, int64(2), p.Total)
+
+	// Invalid bucket
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/api/polls/%s/timeline?bucket=century", app.Server.URL, poll.ID), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Users who did not vote cannot see it
+	req, err = http.NewRequest("GET", fmt.Sprintf("%s/api/polls/%s/timeline", app.Server.URL, poll.ID), nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: createUserAndToken(t, app.DB)})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// TestImportPolls checks that valid rows are imported and invalid ones are reported
+func TestImportPolls(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	creatorID, token := createUserWithToken(t, app.DB)
+
+	// 1. CSV: rows 2 and 4 are valid, row 3 misses the title and row 5 has a single option
+	csvBody := "title,description,option 1,option 2,option 3\n" +
+		"Imported A,First,Yes,No,\n" +
+		",Missing title,Yes,No,\n" +
+		"Imported B,,Red,Green,Blue\n" +
+		"Imported C,,Only,,\n"
+
+	req, err := http.NewRequest("POST", app.Server.URL+"/api/polls/import", strings.NewReader(csvBody))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/csv")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	resp, err := app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var report ports.ImportReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 2, report.Failed)
+	require.Len(t, report.Rows, 4)
+	assert.Equal(t, 2, report.Rows[0].Row)
+	assert.NotNil(t, report.Rows[0].PollID)
+	assert.Equal(t, "title is required", report.Rows[1].Error)
+	assert.Nil(t, report.Rows[1].PollID)
+	assert.NotNil(t, report.Rows[2].PollID)
+	assert.NotEmpty(t, report.Rows[3].Error)
+
+	var count int
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM polls WHERE created_by = $1", creatorID).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM poll_options WHERE poll_id = $1", *report.Rows[2].PollID).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// 2. NDJSON with a malformed line
+	ndjsonBody := `{"title": "Imported D", "options": ["A", "B"]}` + "\n" + `{"title": ` + "\n"
+	req, err = http.NewRequest("POST", app.Server.URL+"/api/polls/import?format=ndjson", strings.NewReader(ndjsonBody))
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, 1, report.Failed)
+
+	// 3. Unknown formats and anonymous callers are rejected
+	req, err = http.NewRequest("POST", app.Server.URL+"/api/polls/import?format=xlsx", strings.NewReader("x"))
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = app.Client.Post(app.Server.URL+"/api/polls/import", "text/csv", strings.NewReader(csvBody))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// 4. A batch failing to save leaves none of the import behind
+	rows := make([]ports.ImportPollRow, 150)
+	for i := range rows {
+		rows[i] = ports.ImportPollRow{Row: i + 2, Input: ports.CreatePollInput{
+			Title: fmt.Sprintf("Batch %d", i), Options: []string{"A", "B"}, CreatedBy: &creatorID,
+		}}
+	}
+	unknownUser := uuid.New()
+	rows[120].Input.CreatedBy = &unknownUser
+
+	pollSvc := services.NewPollService(repo.NewPollRepository(app.DB), repo.NewPollResultRepository(app.DB), repo.NewVoteRepository(app.DB), nil, 0, repo.NewTransactor(app.DB))
+	_, err = pollSvc.Import(context.Background(), rows)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 102")
+
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM polls WHERE created_by = $1", creatorID).Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+// TestProblemDetails checks that errors are reported as RFC 7807 problem details
+func TestProblemDetails(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	// 1. Validation failures list every offending field
+	body, _ := json.Marshal(map[string]interface{}{
+		"title":   "",
+		"options": []string{"Only one"},
+	})
+	resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+
+	var problem struct {
+		Type   string              `json:"type"`
+		Status int                 `json:"status"`
+		Code   string              `json:"code"`
+		Errors []domain.FieldError `json:"errors"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&problem)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "about:blank", problem.Type)
+	assert.Equal(t, http.StatusUnprocessableEntity, problem.Status)
+	assert.Equal(t, "validation_failed", problem.Code)
+	require.Len(t, problem.Errors, 2)
+	assert.Equal(t, "title", problem.Errors[0].Field)
+	assert.Equal(t, "required", problem.Errors[0].Code)
+	assert.Equal(t, "options", problem.Errors[1].Field)
+	assert.Equal(t, "too_few", problem.Errors[1].Code)
+
+	// 2. Domain errors carry a stable code
+	resp, err = app.Client.Get(fmt.Sprintf("%s/api/polls/%s", app.Server.URL, uuid.New()))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	problem.Errors = nil
+	err = json.NewDecoder(resp.Body).Decode(&problem)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "poll_not_found", problem.Code)
+	assert.Empty(t, problem.Errors)
+
+	// 3. Authentication failures too
+	resp, err = app.Client.Get(app.Server.URL + "/api/me")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "application/problem+json", resp.Header.Get("Content-Type"))
+	err = json.NewDecoder(resp.Body).Decode(&problem)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "unauthorized", problem.Code)
+}
+
+func TestContentFilter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	create := func(input map[string]any) (*http.Response, []domain.FieldError) {
+		body, _ := json.Marshal(input)
+		resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var problem struct {
+			Errors []domain.FieldError `json:"errors"`
+		}
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+		}
+		return resp, problem.Errors
+	}
+
+	// 1. Blocklisted terms are caught through look-alike characters
+	resp, errs := create(map[string]any{
+		"title":   "Ѕｐａｍ of the week",
+		"options": []string{"Yes", "No"},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "title", errs[0].Field)
+	assert.Equal(t, "blocked", errs[0].Code)
+
+	// 2. Duplicate options ignore case and whitespace
+	resp, errs = create(map[string]any{
+		"title":   "Favourite city",
+		"options": []string{"New York", "Paris", "  new   york "},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "options[2]", errs[0].Field)
+	assert.Equal(t, "duplicate", errs[0].Code)
+
+	// 3. Blank options are dropped rather than reported as duplicates
+	resp, errs = create(map[string]any{
+		"title":   "Favourite colour",
+		"options": []string{"Red", " ", "Blue", "   "},
+	})
+	require.Equal(t, http.StatusCreated, resp.StatusCode, "%v", errs)
+
+	// 4. Length and link limits apply per field, alongside the built-in checks
+	resp, errs = create(map[string]any{
+		"title":       "",
+		"description": "see https://a.example https://b.example https://c.example https://d.example",
+		"options":     []string{strings.Repeat("x", 201), "visit example.com"},
+	})
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	codes := map[string]string{}
+	for _, e := range errs {
+		codes[e.Field] = e.Code
+	}
+	assert.Equal(t, map[string]string{
+		"title":       "required",
+		"description": "too_many_urls",
+		"options[0]":  "too_long",
+		"options[1]":  "too_many_urls",
+	}, codes)
+
+	// 5. Blocklist entries match whole words only and the description may hold a link
+	resp, _ = create(map[string]any{
+		"title":       "Are spammy subject lines effective?",
+		"description": "Details at https://example.com",
+		"options":     []string{"Yes", "No"},
+	})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
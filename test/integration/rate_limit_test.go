@@ -0,0 +1,148 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	handler "github.com/vncsmyrnk/poll/internal/adapters/handler/http"
+	"github.com/vncsmyrnk/poll/internal/adapters/ratelimit"
+	repo "github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/ports"
+)
+
+func TestRateLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	// newServer puts the middleware in front of a handler that always
+	// succeeds. The X-Test-User header stands in for the auth middleware.
+	newServer := func(limits handler.RateLimits, rule handler.RateLimitRule) *httptest.Server {
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+		limited := handler.NewRateLimitMiddleware(limits, "test", rule)(ok)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if userID, err := uuid.Parse(r.Header.Get("X-Test-User")); err == nil {
+				r = r.WithContext(context.WithValue(r.Context(), handler.UserIDKey, userID))
+			}
+			limited.ServeHTTP(w, r)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	send := func(server *httptest.Server, userID uuid.UUID) *http.Response {
+		req, err := http.NewRequest("POST", server.URL, nil)
+		require.NoError(t, err)
+		if userID != uuid.Nil {
+			req.Header.Set("X-Test-User", userID.String())
+		}
+		resp, err := server.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	stores := map[string]ports.RateLimitStore{
+		"memory":   ratelimit.NewMemoryStore(),
+		"postgres": repo.NewRateLimitStore(app.DB),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			// 1. The IP bucket holds three requests, then asks to come back later
+			server := newServer(handler.RateLimits{Store: store}, handler.RateLimitRule{PerIP: domain.RateLimit{Requests: 3, Per: time.Hour}})
+			for remaining := 2; remaining >= 0; remaining-- {
+				resp := send(server, uuid.Nil)
+				require.Equal(t, http.StatusNoContent, resp.StatusCode)
+				assert.Equal(t, "3", resp.Header.Get("RateLimit-Limit"))
+				assert.Equal(t, "3;w=3600", resp.Header.Get("RateLimit-Policy"))
+				assert.Equal(t, strconv.Itoa(remaining), resp.Header.Get("RateLimit-Remaining"))
+			}
+
+			resp, err := server.Client().Post(server.URL, "application/json", nil)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+			var problem handler.Problem
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+			resp.Body.Close()
+			assert.Equal(t, "rate_limited", problem.Code)
+			assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+			retryAfter, err := time.ParseDuration(resp.Header.Get("Retry-After") + "s")
+			require.NoError(t, err)
+			assert.InDelta(t, 20*time.Minute, retryAfter, float64(time.Minute))
+
+			// 2. Users get their own bucket on top of the one for their IP, and
+			// requests refused by it leave the IP bucket alone
+			server = newServer(handler.RateLimits{Store: store}, handler.RateLimitRule{
+				PerUser: domain.RateLimit{Requests: 2, Per: time.Hour},
+				PerIP:   domain.RateLimit{Requests: 3, Per: time.Hour},
+			})
+			alice, bob := uuid.New(), uuid.New()
+			assert.Equal(t, "1", send(server, alice).Header.Get("RateLimit-Remaining"))
+			assert.Equal(t, "0", send(server, alice).Header.Get("RateLimit-Remaining"))
+			assert.Equal(t, http.StatusTooManyRequests, send(server, alice).StatusCode)
+			assert.Equal(t, http.StatusNoContent, send(server, bob).StatusCode)
+			assert.Equal(t, http.StatusTooManyRequests, send(server, uuid.Nil).StatusCode)
+		})
+	}
+
+	// 3. Replicas sharing the database never hand out more tokens than the limit
+	rule := handler.RateLimitRule{PerUser: domain.RateLimit{Requests: 5, Per: time.Hour}}
+	replicas := []*httptest.Server{
+		newServer(handler.RateLimits{Store: repo.NewRateLimitStore(app.DB)}, rule),
+		newServer(handler.RateLimits{Store: repo.NewRateLimitStore(app.DB)}, rule),
+	}
+	userID := uuid.New()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowed := 0
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if send(replicas[i%2], userID).StatusCode == http.StatusNoContent {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 5, allowed)
+
+	// 4. Buckets can be keyed by a hash of the client IP, so the database
+	// does not hold it
+	policy := testIPPolicy
+	hashed := newServer(handler.RateLimits{Store: repo.NewRateLimitStore(app.DB), IPKey: policy.HashIP}, handler.RateLimitRule{PerIP: domain.RateLimit{Requests: 3, Per: time.Hour}})
+	require.Equal(t, http.StatusNoContent, send(hashed, uuid.Nil).StatusCode)
+	var key string
+	err := app.DB.QueryRow("SELECT key FROM rate_limit_buckets WHERE key LIKE 'test:ip:%' ORDER BY updated_at DESC LIMIT 1").Scan(&key)
+	require.NoError(t, err)
+	assert.Equal(t, "test:ip:"+policy.HashIP("127.0.0.1"), key)
+
+	// 5. Poll creation goes through the limiter
+	body, _ := json.Marshal(map[string]any{"title": "Limited", "options": []string{"A", "B"}})
+	resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "1000", resp.Header.Get("RateLimit-Limit"))
+
+	// 6. So do the public audit routes
+	resp, err = app.Client.Get(app.Server.URL + "/api/polls/" + uuid.NewString() + "/audit")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "1000", resp.Header.Get("RateLimit-Limit"))
+}
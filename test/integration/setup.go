@@ -1,28 +1,50 @@
 package integration
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/vncsmyrnk/poll/internal/adapters/challenge"
+	"github.com/vncsmyrnk/poll/internal/adapters/contentfilter"
 	handler "github.com/vncsmyrnk/poll/internal/adapters/handler/http"
+	"github.com/vncsmyrnk/poll/internal/adapters/jwtkeys"
+	"github.com/vncsmyrnk/poll/internal/adapters/mail"
+	"github.com/vncsmyrnk/poll/internal/adapters/oauth"
+	"github.com/vncsmyrnk/poll/internal/adapters/oauth/github"
+	"github.com/vncsmyrnk/poll/internal/adapters/oauth/oidc"
+	"github.com/vncsmyrnk/poll/internal/adapters/ratelimit"
 	repo "github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
 	"github.com/vncsmyrnk/poll/internal/core/ports"
 	"github.com/vncsmyrnk/poll/internal/core/services"
+	"golang.org/x/oauth2"
 )
 
 // MockVerifier for testing
@@ -33,21 +55,291 @@ type MockVerifier struct {
 
 func (v *MockVerifier) Verify(ctx context.Context, token string, clientID string) (*ports.TokenPayload, error) {
 	if token == "valid_token" {
-		return &ports.TokenPayload{Email: v.email, Name: v.name}, nil
+		return &ports.TokenPayload{Subject: "google-" + v.email, Email: v.email, EmailVerified: true, Name: v.name}, nil
 	}
 	return nil, assert.AnError
 }
 
+// Access tokens are signed with testSigningKey, while testRetiredKey stands
+// for a key that was rotated out but still verifies the tokens it issued.
+var (
+	testSigningKey ed25519.PrivateKey
+	testRetiredKey *rsa.PrivateKey
+	testKeys       *jwtkeys.KeySet
+)
+
+func init() {
+	var err error
+	if _, testSigningKey, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+	if testRetiredKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+
+	key, err := jwtkeys.NewKey(testSigningKey)
+	if err != nil {
+		panic(err)
+	}
+	if testKeys, err = jwtkeys.NewKeySet(key); err != nil {
+		panic(err)
+	}
+}
+
+// StubIssuer is a minimal OpenID Connect provider serving discovery and
+// JWKS documents for the ID tokens it signs.
+type StubIssuer struct {
+	Server *httptest.Server
+	keys   *jwtkeys.KeySet
+}
+
+func newStubIssuer(t *testing.T) *StubIssuer {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := jwtkeys.NewKey(private)
+	require.NoError(t, err)
+	keys, err := jwtkeys.NewKeySet(key)
+	require.NoError(t, err)
+
+	issuer := &StubIssuer{keys: keys}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer.Server.URL,
+			"jwks_uri": issuer.Server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(keys.JWKS())
+	})
+	issuer.Server = httptest.NewServer(mux)
+
+	return issuer
+}
+
+// IDToken signs an ID token for email. Overrides replace or add claims, and
+// a nil override removes the claim.
+func (i *StubIssuer) IDToken(t *testing.T, email string, overrides map[string]any) string {
+	t.Helper()
+
+	claims := map[string]any{
+		"iss":            i.Server.URL,
+		"aud":            stubClientID,
+		"sub":            email,
+		"email":          email,
+		"email_verified": true,
+		"name":           "Stub user",
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+
+	token, err := i.keys.Sign(claims)
+	require.NoError(t, err)
+	return token
+}
+
+const stubClientID = "poll-test-client"
+
+// StubGitHub mimics the GitHub endpoints used by the authorization-code
+// flow. Authorize stands in for the user granting access on github.com.
+type StubGitHub struct {
+	Server *httptest.Server
+
+	mu     sync.Mutex
+	grants map[string]stubGrant
+}
+
+type stubGrant struct {
+	challenge   string
+	redirectURI string
+}
+
+const stubGitHubToken = "stub-github-token"
+
+func newStubGitHub(t *testing.T) *StubGitHub {
+	t.Helper()
+
+	gh := &StubGitHub{grants: map[string]stubGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		gh.mu.Lock()
+		grant, ok := gh.grants[r.FormValue("code")]
+		delete(gh.grants, r.FormValue("code"))
+		gh.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge || r.FormValue("redirect_uri") != grant.redirectURI {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": stubGitHubToken, "token_type": "bearer"})
+	})
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+stubGitHubToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("GET /user", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"id": 583231, "login": "octocat", "name": nil})
+	}))
+	mux.HandleFunc("GET /user/emails", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]map[string]any{
+			{"email": "octocat@users.noreply.example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		})
+	}))
+	gh.Server = httptest.NewServer(mux)
+
+	return gh
+}
+
+// Authorize grants access for the PKCE challenge and returns the code
+// GitHub would redirect back with.
+func (g *StubGitHub) Authorize(challenge, redirectURI string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	code := uuid.NewString()
+	g.grants[code] = stubGrant{challenge: challenge, redirectURI: redirectURI}
+	return code
+}
+
+func (g *StubGitHub) AuthCodeFlow() ports.AuthCodeFlow {
+	return github.NewAuthCodeFlow(github.Config{
+		ClientID:     "poll-test-github-client",
+		ClientSecret: "poll-test-github-secret",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  g.Server.URL + "/login/oauth/authorize",
+			TokenURL: g.Server.URL + "/login/oauth/access_token",
+		},
+		APIURL:     g.Server.URL,
+		HTTPClient: g.Server.Client(),
+	})
+}
+
+// StubSiteVerify mimics the siteverify endpoint shared by Turnstile and
+// hCaptcha. Solve stands in for a user completing the widget; like the real
+// services, each token is accepted once.
+type StubSiteVerify struct {
+	Server *httptest.Server
+	// RemoteIPs records the remoteip sent with each verification.
+	RemoteIPs []string
+
+	mu     sync.Mutex
+	tokens map[string]bool
+}
+
+const stubSiteVerifySecret = "stub-siteverify-secret"
+
+func newStubSiteVerify(t *testing.T) *StubSiteVerify {
+	t.Helper()
+
+	sv := &StubSiteVerify{tokens: map[string]bool{}}
+	sv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("secret") != stubSiteVerifySecret {
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error-codes": []string{"invalid-input-secret"}})
+			return
+		}
+
+		sv.mu.Lock()
+		ok := sv.tokens[r.FormValue("response")]
+		delete(sv.tokens, r.FormValue("response"))
+		sv.RemoteIPs = append(sv.RemoteIPs, r.FormValue("remoteip"))
+		sv.mu.Unlock()
+
+		if !ok {
+			json.NewEncoder(w).Encode(map[string]any{"success": false, "error-codes": []string{"invalid-input-response"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"success": true})
+	}))
+	t.Cleanup(sv.Server.Close)
+	return sv
+}
+
+func (s *StubSiteVerify) Solve() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token := uuid.NewString()
+	s.tokens[token] = true
+	return token
+}
+
+func (s *StubSiteVerify) Verifier(secret string) ports.ChallengeVerifier {
+	return challenge.NewSiteVerifier(challenge.SiteVerifyConfig{
+		SiteKey:    "stub-site-key",
+		Secret:     secret,
+		VerifyURL:  s.Server.URL,
+		HTTPClient: s.Server.Client(),
+	})
+}
+
+// testChallengeDifficulty keeps proof of work cheap enough for tests to
+// solve.
+const testChallengeDifficulty = 8
+
+// testAnonymousPerIP lets tests reach the cap on anonymous votes from one
+// address with a few votes.
+const testAnonymousPerIP = 3
+
+// testIPPolicy keeps addresses as they are, as the server does by default;
+// tests anonymize them through their own IP retention service.
+var testIPPolicy = domain.IPRetentionPolicy{HashKey: []byte("test-ip-hash-secret")}
+
+// testReportThreshold is low enough for tests to hide a poll from listings
+// with a couple of reports.
+const testReportThreshold = 2
+
+// testRateLimit is high enough for no test to hit it by accident.
+var testRateLimit = domain.RateLimit{Requests: 1000, Per: time.Minute}
+
+// testEmailRecipientLimit lets TestEmailLogin run into the limit on the links
+// mailed to one address.
+var testEmailRecipientLimit = domain.RateLimit{Requests: 4, Per: time.Hour}
+
+// testTrustedProxies makes the test client a trusted proxy, so tests can
+// pick the client IP through X-Forwarded-For.
+var testTrustedProxies = handler.TrustedProxies{
+	Prefixes: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+	Header:   "X-Forwarded-For",
+}
+
+// testBlocklist is the content filter blocklist used by the test server.
+var testBlocklist = []string{"spam", "buy followers"}
+
 type TestApp struct {
 	DB          *sql.DB
 	Server      *httptest.Server
 	Client      *http.Client
+	Issuer      *StubIssuer
+	GitHub      *StubGitHub
+	MailFile    string
 	SummarySvc  ports.SummaryService
 	DBContainer testcontainers.Container
 }
 
 func setupTestApp(t *testing.T) *TestApp {
-	os.Setenv("JWT_SECRET", "test-secret")
 	ctx := context.Background()
 	dbContainer, dbURL, err := setupPostgresContainer(ctx)
 	require.NoError(t, err)
@@ -63,19 +355,55 @@ func setupTestApp(t *testing.T) *TestApp {
 	resultRepo := repo.NewPollResultRepository(db)
 	userRepo := repo.NewUserRepository(db)
 	authRepo := repo.NewAuthRepository(db)
+	reportRepo := repo.NewReportRepository(db)
+	voteFlagRepo := repo.NewVoteFlagRepository(db)
+	auditRepo := repo.NewAuditRepository(db)
+	transactor := repo.NewTransactor(db)
 	mockVerifier := &MockVerifier{email: "test@example.com", name: "Test user"}
 
-	svc := services.NewPollService(pollRepo, resultRepo, voteRepo)
-	voteSvc := services.NewVoteService(pollRepo, voteRepo)
+	filterConfig := contentfilter.DefaultConfig()
+	filterConfig.Blocklist = testBlocklist
+	svc := services.NewPollService(pollRepo, resultRepo, voteRepo, contentfilter.New(filterConfig), testReportThreshold, transactor)
+	pow, err := challenge.NewProofOfWork(challenge.ProofOfWorkConfig{
+		Key:        []byte("test-challenge-secret"),
+		Spent:      repo.NewChallengeNonceStore(db),
+		Difficulty: testChallengeDifficulty,
+	})
+	require.NoError(t, err)
+	voteSvc := services.NewVoteService(pollRepo, voteRepo, pow, testAnonymousPerIP, testIPPolicy, transactor)
 	userSvc := services.NewUserService(userRepo)
-	authSvc := services.NewAuthService(userRepo, authRepo, mockVerifier)
+	keys := loadTestKeySet(t)
+	issuer := newStubIssuer(t)
+	gh := newStubGitHub(t)
+	providers := oauth.NewRegistry(
+		&ports.LoginProvider{Name: "google", Verifier: mockVerifier},
+		&ports.LoginProvider{Name: "stub", ClientID: stubClientID, Verifier: oidc.NewVerifier(issuer.Server.URL, issuer.Server.Client())},
+		&ports.LoginProvider{Name: "github", AuthCode: gh.AuthCodeFlow()},
+	)
+	mailFile := filepath.Join(t.TempDir(), "mail.txt")
+	authSvc := services.NewAuthService(userRepo, authRepo, providers, keys, mail.NewFileMailer(mailFile))
 	summarySvc := services.NewSummaryService(pollRepo, resultRepo)
+	exportSvc := services.NewExportService(pollRepo, resultRepo, voteRepo, []byte("test-voter-hash-secret"))
+	reportSvc := services.NewReportService(pollRepo, reportRepo)
+	adminSvc := services.NewAdminService(pollRepo, userRepo, voteRepo, authRepo, reportRepo, voteFlagRepo, auditRepo, transactor)
 
 	pollHandler := handler.NewPollHandler(svc)
 	voteHandler := handler.NewVoteHandler(voteSvc)
 	userhandler := handler.NewUserHandler(userSvc)
-	authHandler := handler.NewAuthHandler(authSvc, "https://example.com/redirect", "", http.SameSiteLaxMode)
-	router := handler.NewHandler(pollHandler, voteHandler, authHandler, userhandler, []string{"*"})
+	oauthFlow := handler.OAuthFlowConfig{CallbackBaseURL: "https://poll.example.com", StateKey: []byte("test-oauth-state-secret")}
+	rateLimits := handler.RateLimits{
+		Store:          ratelimit.NewMemoryStore(),
+		CreatePoll:     handler.RateLimitRule{PerIP: testRateLimit},
+		Vote:           handler.RateLimitRule{PerIP: testRateLimit},
+		EmailLogin:     handler.RateLimitRule{PerIP: testRateLimit},
+		EmailRecipient: testEmailRecipientLimit,
+		Audit:          handler.RateLimitRule{PerIP: testRateLimit},
+	}
+	authHandler := handler.NewAuthHandler(authSvc, "https://example.com/redirect", "", http.SameSiteLaxMode, oauthFlow, rateLimits)
+	exportHandler := handler.NewExportHandler(exportSvc)
+	reportHandler := handler.NewReportHandler(reportSvc)
+	adminHandler := handler.NewAdminHandler(adminSvc)
+	router := handler.NewHandler(pollHandler, voteHandler, authHandler, userhandler, exportHandler, reportHandler, adminHandler, keys, authSvc, []string{"*"}, rateLimits, testTrustedProxies, handler.DeviceCookie{Key: []byte("test-device-secret"), SameSite: http.SameSiteLaxMode})
 
 	server := httptest.NewServer(router)
 
@@ -83,19 +411,117 @@ func setupTestApp(t *testing.T) *TestApp {
 		DB:          db,
 		Server:      server,
 		Client:      server.Client(),
+		Issuer:      issuer,
+		GitHub:      gh,
+		MailFile:    mailFile,
 		SummarySvc:  summarySvc,
 		DBContainer: dbContainer,
 	}
 }
 
+// loadTestKeySet goes through the same PEM loading as the server does.
+func loadTestKeySet(t *testing.T) *jwtkeys.KeySet {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(testSigningKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwt-signing-key.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	require.NoError(t, err)
+
+	signingKey, err := jwtkeys.LoadKey(path)
+	require.NoError(t, err)
+	retiredKey, err := jwtkeys.NewKey(&testRetiredKey.PublicKey)
+	require.NoError(t, err)
+
+	keys, err := jwtkeys.NewKeySet(signingKey, retiredKey)
+	require.NoError(t, err)
+	return keys
+}
+
 func (app *TestApp) Teardown(t *testing.T) {
 	app.Server.Close()
+	app.Issuer.Server.Close()
+	app.GitHub.Server.Close()
 	app.DB.Close()
 	if err := app.DBContainer.Terminate(context.Background()); err != nil {
 		t.Logf("failed to terminate container: %v", err)
 	}
 }
 
+// requestOption sets the credentials or headers of a request sent with
+// TestApp.Do. Empty values leave the request alone.
+type requestOption func(*http.Request)
+
+// withToken signs the request in with an access token cookie, as browsers do.
+func withToken(token string) requestOption {
+	return withCookie(&http.Cookie{Name: "access_token", Value: token})
+}
+
+// withBearer sends the token in the Authorization header, as scripts do.
+func withBearer(token string) requestOption {
+	if token == "" {
+		return withHeader("Authorization", "")
+	}
+	return withHeader("Authorization", "Bearer "+token)
+}
+
+func withCookie(cookie *http.Cookie) requestOption {
+	return func(req *http.Request) {
+		if cookie != nil && cookie.Value != "" {
+			req.AddCookie(cookie)
+		}
+	}
+}
+
+// withCookies sends every cookie of a browser session, by name.
+func withCookies(cookies map[string]string) requestOption {
+	return func(req *http.Request) {
+		for name, value := range cookies {
+			withCookie(&http.Cookie{Name: name, Value: value})(req)
+		}
+	}
+}
+
+func withHeader(name, value string) requestOption {
+	return func(req *http.Request) {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+}
+
+// Do sends a request to the test server. A url.Values body is sent as a
+// form and any other non-nil body as JSON.
+func (app *TestApp) Do(t *testing.T, method, path string, body any, opts ...requestOption) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	var contentType string
+	switch body := body.(type) {
+	case nil:
+	case url.Values:
+		reader, contentType = strings.NewReader(body.Encode()), "application/x-www-form-urlencoded"
+	default:
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader, contentType = bytes.NewReader(b), "application/json"
+	}
+
+	req, err := http.NewRequest(method, app.Server.URL+path, reader)
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, opt := range opts {
+		opt(req)
+	}
+
+	resp, err := app.Client.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
 func setupPostgresContainer(ctx context.Context) (testcontainers.Container, string, error) {
 	dbName := "testdb"
 	user := "user"
@@ -158,21 +584,46 @@ func applyMigrations(db *sql.DB) error {
 func createUserAndToken(t *testing.T, db *sql.DB) string {
 	t.Helper()
 
+	_, token := createUserWithToken(t, db)
+	return token
+}
+
+func createUserWithToken(t *testing.T, db *sql.DB) (uuid.UUID, string) {
+	t.Helper()
+
 	userID := uuid.New()
 	email := fmt.Sprintf("user-%s@example.com", userID)
 	name := fmt.Sprintf("User %s", userID)
 	_, err := db.Exec("INSERT INTO users (id, email, name) VALUES ($1, $2, $3)", userID, email, name)
 	require.NoError(t, err)
 
-	claims := jwt.MapClaims{
+	signedToken, err := testKeys.Sign(map[string]any{
 		"sub":   userID.String(),
 		"email": email,
 		"exp":   time.Now().Add(15 * time.Minute).Unix(),
 		"iat":   time.Now().Unix(),
-	}
+	})
+	require.NoError(t, err)
+	return userID, signedToken
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte("test-secret"))
+// createUserWithRole is createUserWithToken for a user holding role, whose
+// access token carries the role claim.
+func createUserWithRole(t *testing.T, db *sql.DB, role domain.Role) (uuid.UUID, string) {
+	t.Helper()
+
+	userID := uuid.New()
+	email := fmt.Sprintf("%s-%s@example.com", role, userID)
+	_, err := db.Exec("INSERT INTO users (id, email, name, role) VALUES ($1, $2, $3, $4)", userID, email, string(role), role)
+	require.NoError(t, err)
+
+	signedToken, err := testKeys.Sign(map[string]any{
+		"sub":   userID.String(),
+		"email": email,
+		"role":  string(role),
+		"exp":   time.Now().Add(15 * time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+	})
 	require.NoError(t, err)
-	return signedToken
+	return userID, signedToken
 }
@@ -2,13 +2,20 @@ package integration
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	handler "github.com/vncsmyrnk/poll/internal/adapters/handler/http"
+	"github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
+	"github.com/vncsmyrnk/poll/internal/core/domain"
+	"github.com/vncsmyrnk/poll/internal/core/services"
 )
 
 func TestGetMe(t *testing.T) {
@@ -75,3 +82,89 @@ func TestGetMe_Unauthorized(t *testing.T) {
 
 	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
+
+func TestRoles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	app.Client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	form := url.Values{"credential": {"valid_token"}}
+	resp, err := app.Client.PostForm(app.Server.URL+"/oauth/callback", form)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	cookies := map[string]string{}
+	for _, cookie := range resp.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	roleOf := func(accessToken string) any {
+		claims := jwt.MapClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(accessToken, claims)
+		require.NoError(t, err)
+		return claims["role"]
+	}
+
+	// 1. New users are plain users, in the token and in /api/me
+	assert.Equal(t, "user", roleOf(cookies["access_token"]))
+
+	req, err := http.NewRequest("GET", app.Server.URL+"/api/me", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: cookies["access_token"]})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	var me map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&me))
+	resp.Body.Close()
+	assert.Equal(t, "user", me["role"])
+
+	// 2. RequireRole only admits roles that include the required one
+	protected := handler.NewAuthMiddleware(testKeys, nil)(handler.RequireRole(domain.RoleModerator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	call := func(accessToken string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		protected.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusForbidden, call(cookies["access_token"]))
+
+	// 3. Promotions are picked up on the next refresh
+	userService := services.NewUserService(postgres.NewUserRepository(app.DB))
+	user, err := userService.SetRoleByEmail(context.Background(), "test@example.com", domain.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, domain.RoleAdmin, user.Role)
+
+	req, err = http.NewRequest("POST", app.Server.URL+"/auth/refresh", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: cookies["refresh_token"]})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	for _, cookie := range resp.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	assert.Equal(t, "admin", roleOf(cookies["access_token"]))
+	assert.Equal(t, http.StatusNoContent, call(cookies["access_token"]))
+
+	// 4. Tokens issued before roles existed count as plain users
+	_, legacy := createUserWithToken(t, app.DB)
+	assert.Equal(t, http.StatusForbidden, call(legacy))
+
+	// 5. Unknown roles and users are rejected
+	_, err = userService.SetRoleByEmail(context.Background(), "test@example.com", domain.Role("owner"))
+	assert.ErrorIs(t, err, domain.ErrInvalidRole)
+	_, err = userService.SetRoleByEmail(context.Background(), "nobody@example.com", domain.RoleAdmin)
+	assert.ErrorIs(t, err, domain.ErrUserNotFound)
+}
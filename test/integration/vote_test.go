@@ -2,13 +2,23 @@ package integration
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vncsmyrnk/poll/internal/adapters/challenge"
+	handler "github.com/vncsmyrnk/poll/internal/adapters/handler/http"
+	repo "github.com/vncsmyrnk/poll/internal/adapters/repository/postgres"
 	"github.com/vncsmyrnk/poll/internal/core/domain"
 )
 
@@ -137,3 +147,613 @@ func TestVoteSwitching(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 1, countB)
 }
+
+func TestClientIP(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	body, _ := json.Marshal(map[string]any{"title": "Where are you?", "options": []string{"Here", "There"}})
+	resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var poll domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+	resp.Body.Close()
+
+	vote := func(header, value string) string {
+		userID, token := createUserWithToken(t, app.DB)
+		voteBody, _ := json.Marshal(map[string]any{"option_id": poll.Options[0].ID})
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/polls/%s/votes", app.Server.URL, poll.ID), bytes.NewReader(voteBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, value)
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+		resp, err := app.Client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var voterIP string
+		err = app.DB.QueryRow("SELECT voter_ip FROM votes WHERE poll_id = $1 AND user_id = $2", poll.ID, userID).Scan(&voterIP)
+		require.NoError(t, err)
+		return voterIP
+	}
+
+	// 1. The test client is a trusted proxy, so the address it forwards for is used
+	assert.Equal(t, "203.0.113.7", vote("X-Forwarded-For", "203.0.113.7"))
+
+	// 2. Only the nearest untrusted hop counts, whatever the client claims before it
+	assert.Equal(t, "198.51.100.9", vote("X-Forwarded-For", "10.0.0.1, 198.51.100.9"))
+
+	// 3. The proxies only set X-Forwarded-For, so a Forwarded header is the client's own
+	assert.Equal(t, "127.0.0.1", vote("Forwarded", "for=192.0.2.60"))
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _ := r.Context().Value(handler.ClientIPKey).(string)
+		w.Write([]byte(ip))
+	})
+	clientIP := func(proxies handler.TrustedProxies, header, value string) string {
+		server := httptest.NewServer(handler.NewClientIPMiddleware(proxies)(echo))
+		defer server.Close()
+
+		req, err := http.NewRequest("GET", server.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set(header, value)
+		resp, err := server.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		ip, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(ip)
+	}
+
+	// 4. Proxies configured with Forwarded are read through it instead
+	forwarded := handler.TrustedProxies{Prefixes: testTrustedProxies.Prefixes, Header: "Forwarded"}
+	assert.Equal(t, "2001:db8::17", clientIP(forwarded, "Forwarded", `for=192.0.2.60;proto=https, for="[2001:db8::17]:4711"`))
+	assert.Equal(t, "127.0.0.1", clientIP(forwarded, "X-Forwarded-For", "203.0.113.7"))
+
+	// 5. Headers from untrusted peers are ignored
+	assert.Equal(t, "127.0.0.1", clientIP(handler.TrustedProxies{}, "X-Forwarded-For", "203.0.113.7"))
+}
+
+func TestVoteChallenge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	createPoll := func(requireChallenge bool) domain.Poll {
+		body, _ := json.Marshal(map[string]any{"title": "Human?", "options": []string{"Yes", "No"}, "require_challenge": requireChallenge})
+		resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var poll domain.Poll
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+		return poll
+	}
+	getChallenge := func(pollID uuid.UUID) (int, domain.Challenge) {
+		resp, err := app.Client.Get(fmt.Sprintf("%s/api/polls/%s/challenge", app.Server.URL, pollID))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var challenge domain.Challenge
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&challenge))
+		}
+		return resp.StatusCode, challenge
+	}
+	vote := func(poll domain.Poll, response string) (int, string) {
+		_, token := createUserWithToken(t, app.DB)
+		voteBody, _ := json.Marshal(map[string]any{"option_id": poll.Options[0].ID, "challenge_response": response})
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/polls/%s/votes", app.Server.URL, poll.ID), bytes.NewReader(voteBody))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+		resp, err := app.Client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var problem handler.Problem
+		json.NewDecoder(resp.Body).Decode(&problem)
+		return resp.StatusCode, problem.Code
+	}
+
+	guarded := createPoll(true)
+	assert.True(t, guarded.RequireChallenge)
+
+	// 1. The poll hands out a proof of work challenge
+	status, pow := getChallenge(guarded.ID)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, domain.ChallengeProofOfWork, pow.Type)
+	assert.Equal(t, testChallengeDifficulty, pow.Difficulty)
+	require.NotNil(t, pow.ExpiresAt)
+
+	// 2. Votes without a response or with a wrong one are refused
+	status, code := vote(guarded, "")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "challenge_required", code)
+
+	status, code = vote(guarded, pow.Token+":not-a-solution")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "challenge_failed", code)
+
+	status, code = vote(guarded, "forged.8.00.00:1")
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "challenge_failed", code)
+
+	// 3. A solved challenge lets the vote in, once
+	solution := challenge.Solve(pow.Token, pow.Difficulty)
+	status, _ = vote(guarded, solution)
+	assert.Equal(t, http.StatusCreated, status)
+
+	status, code = vote(guarded, solution)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "challenge_failed", code)
+
+	// Another replica sharing the key and the database refuses it too
+	replica, err := challenge.NewProofOfWork(challenge.ProofOfWorkConfig{
+		Key:   []byte("test-challenge-secret"),
+		Spent: repo.NewChallengeNonceStore(app.DB),
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, replica.Verify(context.Background(), guarded.ID.String(), solution, ""), domain.ErrChallengeFailed)
+
+	// 4. A challenge cannot be spent on another poll
+	other := createPoll(true)
+	_, pow = getChallenge(guarded.ID)
+	status, code = vote(other, challenge.Solve(pow.Token, pow.Difficulty))
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "challenge_failed", code)
+
+	// 5. Polls that do not require one have no challenge
+	open := createPoll(false)
+	status, _ = getChallenge(open.ID)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = vote(open, "")
+	assert.Equal(t, http.StatusCreated, status)
+}
+
+func TestSiteVerifier(t *testing.T) {
+	ctx := context.Background()
+	stub := newStubSiteVerify(t)
+	verifier := stub.Verifier(stubSiteVerifySecret)
+
+	// 1. Clients render the widget with the site key
+	c, err := verifier.NewChallenge(ctx, "poll")
+	require.NoError(t, err)
+	assert.Equal(t, domain.ChallengeCaptcha, c.Type)
+	assert.Equal(t, "stub-site-key", c.SiteKey)
+
+	// 2. A solved token passes once, and the voter's IP is forwarded
+	token := stub.Solve()
+	require.NoError(t, verifier.Verify(ctx, "poll", token, "203.0.113.7"))
+	assert.ErrorIs(t, verifier.Verify(ctx, "poll", token, "203.0.113.7"), domain.ErrChallengeFailed)
+	assert.Equal(t, []string{"203.0.113.7", "203.0.113.7"}, stub.RemoteIPs)
+
+	// 3. A wrong secret is a server problem, not a failed challenge
+	err = stub.Verifier("wrong-secret").Verify(ctx, "poll", stub.Solve(), "")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, domain.ErrChallengeFailed)
+}
+
+func TestAnonymousVoting(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	createPoll := func(allowAnonymous bool) domain.Poll {
+		body, _ := json.Marshal(map[string]any{"title": "Tea or coffee?", "options": []string{"Tea", "Coffee"}, "allow_anonymous": allowAnonymous})
+		resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var poll domain.Poll
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+		return poll
+	}
+	// do sends the device cookie, if any, and returns the one the server
+	// issued in its place, if any.
+	do := func(method, path string, payload any, device *http.Cookie, ip string) (*http.Response, *http.Cookie) {
+		resp := app.Do(t, method, path, payload, withCookie(device), withHeader("X-Forwarded-For", ip))
+		for _, c := range resp.Cookies() {
+			if c.Name == "device_id" {
+				return resp, c
+			}
+		}
+		return resp, nil
+	}
+	vote := func(poll domain.Poll, option int, device *http.Cookie, ip string) (int, string, *http.Cookie) {
+		resp, issued := do("POST", "/api/polls/"+poll.ID.String()+"/votes", map[string]any{"option_id": poll.Options[option].ID}, device, ip)
+		defer resp.Body.Close()
+		var problem handler.Problem
+		json.NewDecoder(resp.Body).Decode(&problem)
+		return resp.StatusCode, problem.Code, issued
+	}
+
+	closed := createPoll(false)
+	open := createPoll(true)
+	assert.True(t, open.AllowAnonymous)
+
+	// 1. Polls need an account unless they allow anonymous votes
+	status, code, _ := vote(closed, 0, nil, "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, "login_required", code)
+
+	// 2. An anonymous vote is tied to a signed device cookie instead of a user
+	status, _, device := vote(open, 0, nil, "")
+	require.Equal(t, http.StatusCreated, status)
+	require.NotNil(t, device)
+	assert.True(t, device.HttpOnly)
+
+	var userID, deviceID sql.NullString
+	err := app.DB.QueryRow("SELECT user_id, device_id FROM votes WHERE poll_id = $1", open.ID).Scan(&userID, &deviceID)
+	require.NoError(t, err)
+	assert.False(t, userID.Valid)
+	assert.Equal(t, strings.Split(device.Value, ".")[0], deviceID.String)
+
+	// 3. The device sees its vote and the results
+	resp, issued := do("GET", "/api/polls/"+open.ID.String()+"/my-vote", nil, device, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Nil(t, issued, "a valid cookie is kept")
+	var myVote map[string]uuid.UUID
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&myVote))
+	resp.Body.Close()
+	assert.Equal(t, open.Options[0].ID, myVote["option_id"])
+
+	resp, _ = do("GET", "/api/polls/"+open.ID.String()+"/count", nil, device, "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// 4. The device votes once, but may change its vote
+	status, code, _ = vote(open, 0, device, "")
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "already_voted", code)
+
+	status, _, _ = vote(open, 1, device, "")
+	assert.Equal(t, http.StatusCreated, status)
+
+	var active int
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM votes WHERE poll_id = $1 AND deleted_at IS NULL", open.ID).Scan(&active)
+	require.NoError(t, err)
+	assert.Equal(t, 1, active)
+
+	// 5. A tampered cookie is replaced by a new device
+	forged := &http.Cookie{Name: "device_id", Value: strings.Split(device.Value, ".")[0] + ".forged"}
+	resp, issued = do("GET", "/api/polls/"+open.ID.String()+"/my-vote", nil, forged, "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NotNil(t, issued)
+	assert.NotEqual(t, device.Value, issued.Value)
+
+	// 6. An address only gets a few anonymous votes per poll
+	for i := 0; i < testAnonymousPerIP; i++ {
+		status, _, _ = vote(open, 0, nil, "198.51.100.20")
+		require.Equal(t, http.StatusCreated, status)
+	}
+	status, code, _ = vote(open, 0, nil, "198.51.100.20")
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "ip_vote_limit", code)
+
+	status, _, _ = vote(open, 0, nil, "198.51.100.21")
+	assert.Equal(t, http.StatusCreated, status)
+
+	// Concurrent votes from one address cannot all slip under the cap
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	accepted := 0
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if status, _, _ := vote(open, 0, nil, "198.51.100.22"); status == http.StatusCreated {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, testAnonymousPerIP, accepted)
+
+	// 7. Signed-in users still vote as themselves, whatever their address
+	_, token := createUserWithToken(t, app.DB)
+	voteBody, _ := json.Marshal(map[string]any{"option_id": open.Options[0].ID})
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/polls/%s/votes", app.Server.URL, open.ID), bytes.NewReader(voteBody))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", "198.51.100.20")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// 8. An expired or forged token is refused rather than voting as the device
+	req, err = http.NewRequest("POST", fmt.Sprintf("%s/api/polls/%s/votes", app.Server.URL, open.ID), bytes.NewReader(voteBody))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: "expired"})
+	req.AddCookie(device)
+	resp, err = app.Client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestSecretBallot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	userID, token := createUserWithToken(t, app.DB)
+	_, otherToken := createUserWithToken(t, app.DB)
+
+	decode := func(resp *http.Response, v any) {
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	// 1. Secret ballots and anonymous votes do not go together
+	resp := app.Do(t, "POST", "/api/polls", map[string]any{"title": "Who leads?", "options": []string{"Ann", "Bob"}, "secret_ballot": true, "allow_anonymous": true}, withToken(token))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	resp = app.Do(t, "POST", "/api/polls", map[string]any{"title": "Who leads?", "options": []string{"Ann", "Bob"}, "secret_ballot": true}, withToken(token))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var poll domain.Poll
+	decode(resp, &poll)
+	assert.True(t, poll.SecretBallot)
+	votesPath := "/api/polls/" + poll.ID.String() + "/votes"
+	myVotePath := "/api/polls/" + poll.ID.String() + "/my-vote"
+
+	// 2. Voting hands out a receipt, and the ballot does not record the voter
+	resp = app.Do(t, "POST", votesPath, map[string]any{"option_id": poll.Options[0].ID}, withToken(token))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var receipt domain.VoteReceipt
+	decode(resp, &receipt)
+	require.NotEmpty(t, receipt.Receipt)
+
+	var userCol, deviceCol, voterIP sql.NullString
+	var receiptHash string
+	err := app.DB.QueryRow("SELECT user_id, device_id, voter_ip, receipt_hash FROM votes WHERE poll_id = $1", poll.ID).Scan(&userCol, &deviceCol, &voterIP, &receiptHash)
+	require.NoError(t, err)
+	assert.False(t, userCol.Valid)
+	assert.False(t, deviceCol.Valid)
+	assert.False(t, voterIP.Valid)
+	assert.NotEqual(t, receipt.Receipt, receiptHash)
+
+	var participant uuid.UUID
+	err = app.DB.QueryRow("SELECT user_id FROM poll_participants WHERE poll_id = $1", poll.ID).Scan(&participant)
+	require.NoError(t, err)
+	assert.Equal(t, userID, participant)
+
+	// 3. The receipt is needed to see the vote
+	resp = app.Do(t, "GET", myVotePath, nil, withToken(token))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = app.Do(t, "GET", myVotePath, nil, withToken(token), withHeader("X-Vote-Receipt", receipt.Receipt))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var myVote map[string]uuid.UUID
+	decode(resp, &myVote)
+	assert.Equal(t, poll.Options[0].ID, myVote["option_id"])
+
+	resp = app.Do(t, "GET", myVotePath, nil, withToken(otherToken), withHeader("X-Vote-Receipt", receipt.Receipt))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "a receipt is of no use to who did not vote")
+
+	// 4. Voting again needs the receipt and replaces the ballot under a new one
+	resp = app.Do(t, "POST", votesPath, map[string]any{"option_id": poll.Options[1].ID}, withToken(token))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = app.Do(t, "POST", votesPath, map[string]any{"option_id": poll.Options[1].ID, "receipt": receipt.Receipt}, withToken(token))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	var changed domain.VoteReceipt
+	decode(resp, &changed)
+	assert.NotEqual(t, receipt.Receipt, changed.Receipt)
+
+	resp = app.Do(t, "GET", myVotePath, nil, withToken(token), withHeader("X-Vote-Receipt", receipt.Receipt))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	var active int
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM votes WHERE poll_id = $1 AND deleted_at IS NULL", poll.ID).Scan(&active)
+	require.NoError(t, err)
+	assert.Equal(t, 1, active)
+
+	// 5. Another participant holding the receipt can neither see nor retract
+	// the ballot
+	resp = app.Do(t, "POST", votesPath, map[string]any{"option_id": poll.Options[0].ID}, withToken(otherToken))
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = app.Do(t, "GET", myVotePath, nil, withToken(otherToken), withHeader("X-Vote-Receipt", changed.Receipt))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = app.Do(t, "DELETE", votesPath, nil, withToken(otherToken), withHeader("X-Vote-Receipt", changed.Receipt))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM votes WHERE poll_id = $1 AND deleted_at IS NULL", poll.ID).Scan(&active)
+	require.NoError(t, err)
+	assert.Equal(t, 2, active)
+
+	// 6. Retracting removes both the ballot and the participant
+	resp = app.Do(t, "DELETE", votesPath, nil, withToken(token), withHeader("X-Vote-Receipt", changed.Receipt))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	var participants int
+	err = app.DB.QueryRow("SELECT COUNT(*) FROM poll_participants WHERE poll_id = $1 AND user_id = $2", poll.ID, userID).Scan(&participants)
+	require.NoError(t, err)
+	assert.Equal(t, 0, participants)
+
+	resp = app.Do(t, "POST", votesPath, map[string]any{"option_id": poll.Options[0].ID}, withToken(token))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestTallyProof(t *testing.T) {
+	pollID := uuid.New()
+	options := []uuid.UUID{uuid.New(), uuid.New()}
+
+	// Trees of every shape, including odd levels, prove each of their votes
+	for ballots := 1; ballots <= 9; ballots++ {
+		var hashes []string
+		var leaves [][]byte
+		for i := 0; i < ballots; i++ {
+			receipt := domain.NewVoteReceipt(pollID, options[i%2], []byte{byte(ballots), byte(i)})
+			require.True(t, receipt.Valid())
+			hash := domain.ReceiptHash(receipt.Receipt)
+			leaf, err := domain.TallyLeaf(hash, receipt.OptionID)
+			require.NoError(t, err)
+			hashes = append(hashes, hash)
+			leaves = append(leaves, leaf)
+		}
+		root := domain.MerkleRoot(leaves)
+
+		for i := range leaves {
+			proof := domain.TallyProof{
+				PollID:     pollID,
+				OptionID:   options[i%2],
+				LeafIndex:  i,
+				Ballots:    ballots,
+				Path:       domain.MerklePath(leaves, i),
+				MerkleRoot: root,
+			}
+			assert.True(t, proof.Verify(hashes[i]), "ballot %d of %d", i, ballots)
+
+			// A proof does not hold for another option, receipt or position
+			wrongOption := proof
+			wrongOption.OptionID = options[(i+1)%2]
+			assert.False(t, wrongOption.Verify(hashes[i]))
+			assert.False(t, proof.Verify(domain.ReceiptHash("forged")))
+			if ballots > 1 {
+				moved := proof
+				moved.LeafIndex = (i + 1) % ballots
+				assert.False(t, moved.Verify(hashes[i]))
+			}
+		}
+	}
+
+	// A receipt commits to its option
+	receipt := domain.NewVoteReceipt(pollID, options[0], []byte("nonce"))
+	receipt.OptionID = options[1]
+	assert.False(t, receipt.Valid())
+}
+
+func TestVoteReceipts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+
+	app := setupTestApp(t)
+	defer app.Teardown(t)
+
+	body, _ := json.Marshal(map[string]any{"title": "Recount?", "options": []string{"Yes", "No"}})
+	resp, err := app.Client.Post(app.Server.URL+"/api/polls", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	var poll domain.Poll
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&poll))
+	resp.Body.Close()
+	auditPath := "/api/polls/" + poll.ID.String() + "/audit"
+
+	voters := make(map[string]string)
+	vote := func(option int) domain.VoteReceipt {
+		token := createUserAndToken(t, app.DB)
+		body, _ := json.Marshal(map[string]any{"option_id": poll.Options[option].ID})
+		req, err := http.NewRequest("POST", app.Server.URL+"/api/polls/"+poll.ID.String()+"/votes", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+		resp, err := app.Client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		var receipt domain.VoteReceipt
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&receipt))
+		voters[receipt.Receipt] = token
+		return receipt
+	}
+	get := func(path string, v any) int {
+		resp, err := app.Client.Get(app.Server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	// 1. Every vote comes with a receipt committing to its option
+	receipts := []domain.VoteReceipt{vote(0), vote(1), vote(0)}
+	for _, r := range receipts {
+		assert.True(t, r.Valid())
+	}
+	assert.Equal(t, http.StatusNotFound, get(auditPath, nil), "nothing is published before processing")
+
+	// 2. Processing the votes publishes the tally
+	require.NoError(t, app.SummarySvc.SummarizeAllVotes(context.Background()))
+
+	var tally domain.Tally
+	require.Equal(t, http.StatusOK, get(auditPath, &tally))
+	assert.Equal(t, 3, tally.Ballots)
+
+	// 3. Each receipt gets a proof leading to the published root
+	for _, r := range receipts {
+		var proof domain.TallyProof
+		require.Equal(t, http.StatusOK, get(auditPath+"/proof?receipt_hash="+domain.ReceiptHash(r.Receipt), &proof))
+		assert.Equal(t, r.OptionID, proof.OptionID)
+		assert.Equal(t, tally.MerkleRoot, proof.MerkleRoot)
+		assert.True(t, proof.Verify(domain.ReceiptHash(r.Receipt)))
+	}
+
+	// A malformed receipt hash is refused
+	assert.Equal(t, http.StatusBadRequest, get(auditPath+"/proof?receipt_hash=not-a-hash", nil))
+
+	// 4. Votes are only provable once counted
+	pending := vote(1)
+	assert.Equal(t, http.StatusNotFound, get(auditPath+"/proof?receipt_hash="+domain.ReceiptHash(pending.Receipt), nil))
+
+	require.NoError(t, app.SummarySvc.SummarizeAllVotes(context.Background()))
+	var updated domain.Tally
+	require.Equal(t, http.StatusOK, get(auditPath, &updated))
+	assert.Equal(t, 4, updated.Ballots)
+	assert.NotEqual(t, tally.MerkleRoot, updated.MerkleRoot)
+
+	var proof domain.TallyProof
+	require.Equal(t, http.StatusOK, get(auditPath+"/proof?receipt_hash="+domain.ReceiptHash(pending.Receipt), &proof))
+	assert.True(t, proof.Verify(domain.ReceiptHash(pending.Receipt)))
+
+	// 5. A retracted vote leaves the tree once processed, and the others
+	// still prove against the new root
+	resp = app.Do(t, "DELETE", "/api/polls/"+poll.ID.String()+"/votes", nil, withToken(voters[receipts[0].Receipt]))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.NoError(t, app.SummarySvc.SummarizeAllVotes(context.Background()))
+
+	var retracted domain.Tally
+	require.Equal(t, http.StatusOK, get(auditPath, &retracted))
+	assert.Equal(t, 3, retracted.Ballots)
+	assert.NotEqual(t, updated.MerkleRoot, retracted.MerkleRoot)
+	assert.Equal(t, http.StatusNotFound, get(auditPath+"/proof?receipt_hash="+domain.ReceiptHash(receipts[0].Receipt), nil))
+
+	for _, r := range append(receipts[1:], pending) {
+		var proof domain.TallyProof
+		require.Equal(t, http.StatusOK, get(auditPath+"/proof?receipt_hash="+domain.ReceiptHash(r.Receipt), &proof))
+		assert.Equal(t, retracted.MerkleRoot, proof.MerkleRoot)
+		assert.True(t, proof.Verify(domain.ReceiptHash(r.Receipt)))
+	}
+}